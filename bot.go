@@ -2,11 +2,13 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -14,6 +16,26 @@ import (
 const FORK_THREAD_EMOJI = "🧵"
 const START_FORK_THREAD_EMOJI = "🪡"
 
+//Forks post this many messages at a time, waiting FORK_BATCH_INTERVAL between
+//batches so that big forks stay under Discord's rate limit of 5 messages per 5
+//seconds in a channel.
+const FORK_BATCH_SIZE = 5
+const FORK_BATCH_INTERVAL = time.Second * 5
+
+//Forks started from a command report back by editing the interaction
+//response, which Discord only allows for 15 minutes, so forks are capped to
+//take at most this long to post, leaving time to fetch the messages too.
+const MAX_FORK_POSTING_TIME = time.Minute * 10
+
+//The most messages that can be posted in MAX_FORK_POSTING_TIME
+const MAX_FORK_MESSAGES = int(MAX_FORK_POSTING_TIME/FORK_BATCH_INTERVAL) * FORK_BATCH_SIZE
+
+//How often to check for threads that have been idle too long
+const IDLE_ARCHIVE_SWEEP_INTERVAL = time.Hour
+
+//The most idle days a group can wait before archiving a thread
+const MAX_IDLE_ARCHIVE_DAYS = 365
+
 type categoryMap map[string]*threadGroupInfo
 
 type bot struct {
@@ -23,7 +45,32 @@ type bot struct {
 	infos           map[string]categoryMap
 	infoMutex       sync.RWMutex
 	indexes         map[string]*IDFIndex
+	settings        map[string]*GuildSettings
 	rebuildIDFTimer *time.Timer
+	idleSweepTimer  *time.Timer
+	reorderer       *reorderScheduler
+	exporter        *transcriptExporter
+	//guildID -> moves we've made that Discord hasn't confirmed yet
+	pendingMoves map[string]*pendingChannelMoves
+	//channelID -> webhook the bot posts webhook forks with
+	forkWebhooks      map[string]*discordgo.Webhook
+	forkWebhooksMutex sync.Mutex
+	//forkUndo.id -> forks that can still be undone
+	forkUndos      map[string]*forkUndo
+	forkUndosMutex sync.Mutex
+	//pendingFork.id -> forks waiting for the user to confirm them
+	pendingForks      map[string]*pendingFork
+	pendingForkCount  int
+	pendingForksMutex sync.Mutex
+	//guildID+userID -> when they last forked via emoji
+	emojiForkTimes map[string]time.Time
+	//messageID -> true for messages forked via emoji, whose forks might not
+	//be indexed yet
+	emojiForkedMessages map[string]bool
+	emojiForkTimesMutex sync.Mutex
+	messageCache        *messageCache
+	//Tombstones and deletions of forks waiting to be tried again
+	forkDeletionRetries sync.WaitGroup
 }
 
 type threadGroupInfo struct {
@@ -32,6 +79,94 @@ type threadGroupInfo struct {
 	nextArchiveCategoryIndex int
 	activeArchiveCategoryID  string
 	archiveCategoryIDs       []string
+	maxActiveThreads         int
+	//0 means keep every archived thread
+	maxArchivedThreads int
+	//0 means never archive threads for being idle
+	idleArchiveDuration time.Duration
+	//channelID -> true for threads that shouldn't be archived or moved
+	pinnedThreadIDs map[string]bool
+	settings        *GuildSettings
+	pendingMoves    *pendingChannelMoves
+}
+
+//pendingChannelMoves tracks channels we've moved to another category but
+//haven't yet seen the channelUpdate for. Until that arrives, State might have
+//the old ParentID--or an older channelUpdate might arrive and put it back--so
+//anything deciding which channels are in a category should consult this. It
+//also tracks channels we're deleting, so that channelDelete can tell them
+//apart from channels someone else deleted.
+type pendingChannelMoves struct {
+	//channelID -> ParentID we moved it to
+	parents map[string]string
+	//channelID -> true for channels we're deleting
+	deleting map[string]bool
+	mutex    sync.Mutex
+}
+
+func newPendingChannelMoves() *pendingChannelMoves {
+	return &pendingChannelMoves{
+		parents:  make(map[string]string),
+		deleting: make(map[string]bool),
+	}
+}
+
+//noteDeleting should be called just before the bot deletes a channel itself.
+func (p *pendingChannelMoves) noteDeleting(channelID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.deleting[channelID] = true
+}
+
+//takeDeleting returns true if the bot deleted the channel itself, and forgets
+//it.
+func (p *pendingChannelMoves) takeDeleting(channelID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	result := p.deleting[channelID]
+	delete(p.deleting, channelID)
+	return result
+}
+
+func (p *pendingChannelMoves) noteMoved(channelID, parentID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.parents[channelID] = parentID
+}
+
+func (p *pendingChannelMoves) forget(channelID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.parents, channelID)
+}
+
+//parentID returns the category the channel is in or soon will be.
+func (p *pendingChannelMoves) parentID(channel *discordgo.Channel) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if parentID, ok := p.parents[channel.ID]; ok {
+		return parentID
+	}
+	return channel.ParentID
+}
+
+//confirm should be called with every channelUpdate. It returns true if this
+//update completed the last outstanding move into the channel's category.
+func (p *pendingChannelMoves) confirm(channel *discordgo.Channel) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	parentID, ok := p.parents[channel.ID]
+	if !ok || parentID != channel.ParentID {
+		//Either not one of ours or a stale update from before the move
+		return false
+	}
+	delete(p.parents, channel.ID)
+	for _, otherParentID := range p.parents {
+		if otherParentID == parentID {
+			return false
+		}
+	}
+	return true
 }
 
 type byArchiveIndex []*discordgo.Channel
@@ -49,11 +184,25 @@ func messageReference(guildID, channelID, messageID string) *discordgo.MessageRe
 
 func newBot(s *discordgo.Session, c Controller) *bot {
 	result := &bot{
-		session:    s,
-		controller: c,
-		infos:      make(map[string]categoryMap),
-		indexes:    make(map[string]*IDFIndex),
-	}
+		session:             s,
+		controller:          c,
+		infos:               make(map[string]categoryMap),
+		indexes:             make(map[string]*IDFIndex),
+		settings:            make(map[string]*GuildSettings),
+		pendingMoves:        make(map[string]*pendingChannelMoves),
+		forkWebhooks:        make(map[string]*discordgo.Webhook),
+		forkUndos:           make(map[string]*forkUndo),
+		pendingForks:        make(map[string]*pendingFork),
+		emojiForkTimes:      make(map[string]time.Time),
+		emojiForkedMessages: make(map[string]bool),
+		messageCache:        newMessageCache(c, MESSAGE_CACHE_SIZE, MESSAGE_CACHE_TTL),
+		reorderer:           newReorderScheduler(c, REORDER_DEBOUNCE_INTERVAL),
+	}
+	dir := exportDir
+	if dir == "" {
+		dir = filepath.Join(CACHE_PATH, EXPORTS_PATH)
+	}
+	result.exporter = newTranscriptExporter(s, dir)
 	s.AddHandler(result.ready)
 	s.AddHandler(result.guildCreate)
 	s.AddHandler(result.messageCreate)
@@ -75,6 +224,7 @@ func (b *bot) start() error {
 		return fmt.Errorf("couldn't register slash commands: %v", err)
 	}
 	b.scheduleRebuildIDFCache()
+	b.scheduleIdleArchiveSweep()
 	return nil
 }
 
@@ -94,11 +244,16 @@ func (b *bot) registerSlashCommands() error {
 func (b *bot) ready(s *discordgo.Session, event *discordgo.Ready) {
 	//GuildInfo isn't populated yet.
 	fmt.Println("Ready and waiting!")
+	//This is a new session, so we might have missed edits and reactions
+	b.messageCache.clear()
 }
 
 // discordgo callback: called after the bot starts up for each guild it's added to
 func (b *bot) guildCreate(s *discordgo.Session, event *discordgo.GuildCreate) {
 	b.setGuildNeedsInfoRegeneration(event.Guild.ID)
+	if warning := guildChannelLimitWarning(event.Guild); warning != "" {
+		fmt.Println(warning)
+	}
 	guildInfos := b.getInfos(event.Guild.ID)
 	if guildInfos == nil {
 		fmt.Printf("Couldn't find guild with ID %v\n", event.Guild.ID)
@@ -114,11 +269,14 @@ func (b *bot) guildCreate(s *discordgo.Session, event *discordgo.GuildCreate) {
 		fmt.Printf("couldn't fetch idf for guild %v: %v\n", event.Guild.ID, err)
 	}
 	b.indexes[event.Guild.ID] = idf
+	b.shareCrossGuildForks(event.Guild.ID)
 }
 
 // discordgo callback: called after the when new message is posted.
 func (b *bot) messageCreate(s *discordgo.Session, event *discordgo.MessageCreate) {
 
+	b.messageCache.put(event.Message)
+
 	if err := b.noteMessageIfFork(event.Message); err != nil {
 		fmt.Printf("couldn't note forked message: %v\n", err)
 	}
@@ -128,6 +286,7 @@ func (b *bot) messageCreate(s *discordgo.Session, event *discordgo.MessageCreate
 		fmt.Println("Couldn't find channel")
 		return
 	}
+	noteChannelActivity(s.State, channel, event.ID)
 	if !b.isThread(channel) {
 		return
 	}
@@ -138,31 +297,64 @@ func (b *bot) messageCreate(s *discordgo.Session, event *discordgo.MessageCreate
 
 // discordgo callback: called after the when a message is edited
 func (b *bot) messageUpdate(s *discordgo.Session, event *discordgo.MessageUpdate) {
-	if err := b.updateForkedMessages(event.Message); err != nil {
+	var msg *discordgo.Message
+	if event.Author == nil {
+		//Some updates, like Discord adding link previews, only include what
+		//changed.
+		b.messageCache.remove(event.ID)
+	} else {
+		msg = b.messageCache.update(event.Message)
+	}
+	if msg == nil {
+		//Without a complete copy the reactions aren't known, so fetch the
+		//whole message if it matters.
+		if err := b.updateForkedMessagesIfTheyExist(messageReference(event.GuildID, event.ChannelID, event.ID)); err != nil {
+			fmt.Printf("couldn't update forked messages if any existed: %v\n", err)
+		}
+		return
+	}
+	if err := b.updateForkedMessages(msg); err != nil {
 		fmt.Printf("couldn't update forked messages if any existed: %v\n", err)
 	}
 }
 
 // discordgo callback: called after the when a message is edited
 func (b *bot) messageDelete(s *discordgo.Session, event *discordgo.MessageDelete) {
+	b.messageCache.remove(event.Message.ID)
 	idf, err := b.getLiveIDFIndex(event.GuildID)
 	if err != nil {
 		fmt.Printf("couldn't get idf index: %v\n", err)
 		return
 	}
+	//Forks of forks show the original's content too
+	forks := forksOfDeletedSource(idf, idf.MessageForkDescendants(event.ChannelID, event.Message.ID))
 	idf.NoteMessageDeleted(event.Message.ID)
+	//It might have been a fork of, or forked to, a message in another guild
+	for _, other := range b.otherLiveIDFIndexes(event.GuildID) {
+		other.NoteMessageDeleted(event.Message.ID)
+	}
+	b.propagateSourceDeletion(event.GuildID, forks)
 }
 
 // discordgo callback: called after the when a message is edited
 func (b *bot) messageDeleteBulk(s *discordgo.Session, event *discordgo.MessageDeleteBulk) {
+	for _, msgID := range event.Messages {
+		b.messageCache.remove(msgID)
+	}
 	idf, err := b.getLiveIDFIndex(event.GuildID)
 	if err != nil {
 		fmt.Printf("couldn't get idf index: %v\n", err)
 		return
 	}
+	var forks []*deletedSourceFork
 	for _, msgID := range event.Messages {
+		forks = append(forks, forksOfDeletedSource(idf, idf.MessageForkDescendants(event.ChannelID, msgID))...)
 		idf.NoteMessageDeleted(msgID)
+		for _, other := range b.otherLiveIDFIndexes(event.GuildID) {
+			other.NoteMessageDeleted(msgID)
+		}
 	}
+	b.propagateSourceDeletion(event.GuildID, forks)
 }
 
 // discordgo callback: called after new channel is created.
@@ -192,19 +384,65 @@ func (b *bot) channelCreate(s *discordgo.Session, event *discordgo.ChannelCreate
 // single channel whose index changed will get called one at a time.
 func (b *bot) channelUpdate(s *discordgo.Session, event *discordgo.ChannelUpdate) {
 	b.setGuildNeedsInfoRegeneration(event.GuildID)
+
+	pendingMoves := b.getPendingMoves(event.GuildID)
+	//Someone might have dragged an archived thread back into a group by hand.
+	//The bot's own unarchives have already forgotten the archive time, and
+	//updates from before a pending move are stale.
+	settings := b.getGuildSettings(event.GuildID)
+	if _, archived := settings.ThreadArchiveTime(event.Channel.ID); archived && pendingMoves.parentID(event.Channel) == event.Channel.ParentID && b.getThreadGroupInfoForThread(event.Channel) != nil {
+		settings.SetThreadArchiveTime(event.Channel.ID, time.Time{})
+		settings.SetThreadReviveTime(event.Channel.ID, b.controller.Now())
+	}
+
+	if !pendingMoves.confirm(event.Channel) {
+		return
+	}
+	//Now that every thread we archived into this category has actually
+	//arrived, it's safe to put them in order.
+	gi := b.getThreadGroupInfoForArchivedThread(event.Channel)
+	if gi == nil {
+		return
+	}
+	if err := gi.sortArchiveCategory(b.controller, b.session, event.Channel.ParentID); err != nil {
+		fmt.Printf("Couldn't sort archive category: %v\n", err)
+	}
 }
 
 // discordgo callback: called after the when a message is edited
 func (b *bot) channelDelete(s *discordgo.Session, event *discordgo.ChannelDelete) {
+	pendingMoves := b.getPendingMoves(event.GuildID)
+	pendingMoves.forget(event.Channel.ID)
+	//Messages in channels the bot deleted itself, like pruned archives, were
+	//exported or undone rather than deleted by their authors, so their forks
+	//are left alone.
+	deletedByBot := pendingMoves.takeDeleting(event.Channel.ID)
+	b.messageCache.removeChannel(event.Channel.ID)
+	settings := b.getGuildSettings(event.GuildID)
+	if settings.ThreadIsPinned(event.Channel.ID) {
+		settings.SetThreadPinned(event.Channel.ID, false)
+	}
+	if _, ok := settings.ThreadReviveTime(event.Channel.ID); ok {
+		settings.SetThreadReviveTime(event.Channel.ID, time.Time{})
+	}
 	idf, err := b.getLiveIDFIndex(event.GuildID)
 	if err != nil {
 		fmt.Printf("couldn't get idf index: %v\n", err)
 		return
 	}
+	forks := forksOfDeletedSource(idf, idf.ChannelForkDescendants(event.Channel.ID))
 	idf.NoteChannelDeleted(event.Channel.ID)
+	for _, other := range b.otherLiveIDFIndexes(event.GuildID) {
+		other.NoteChannelDeleted(event.Channel.ID)
+	}
+	if deletedByBot {
+		return
+	}
+	b.propagateSourceDeletion(event.GuildID, forks)
 }
 
 func (b *bot) messageReactionAdd(s *discordgo.Session, event *discordgo.MessageReactionAdd) {
+	b.messageCache.noteReaction(event.MessageReaction, 1)
 	ref := messageReference(event.GuildID, event.ChannelID, event.MessageID)
 	switch event.Emoji.Name {
 	case FORK_THREAD_EMOJI:
@@ -219,13 +457,20 @@ func (b *bot) messageReactionAdd(s *discordgo.Session, event *discordgo.MessageR
 }
 
 func (b *bot) messageReactionRemove(s *discordgo.Session, event *discordgo.MessageReactionRemove) {
+	b.messageCache.noteReaction(event.MessageReaction, -1)
 	ref := messageReference(event.GuildID, event.ChannelID, event.MessageID)
+	if event.Emoji.Name == FORK_THREAD_EMOJI {
+		if err := b.undoForkViaReactionRemoval(ref, event.UserID); err != nil {
+			fmt.Printf("couldn't undo fork: %v\n", err)
+		}
+	}
 	if err := b.updateForkedMessagesIfTheyExist(ref); err != nil {
 		fmt.Printf("Couldn't update forks if they exist: %v\n", err)
 	}
 }
 
 func (b *bot) messageReactionsRemoveAll(s *discordgo.Session, event *discordgo.MessageReactionRemoveAll) {
+	b.messageCache.noteReactionsRemovedAll(event.MessageID)
 	ref := messageReference(event.GuildID, event.ChannelID, event.MessageID)
 	if err := b.updateForkedMessagesIfTheyExist(ref); err != nil {
 		fmt.Printf("Couldn't update forks if they exist: %v\n", err)
@@ -237,41 +482,237 @@ func (b *bot) forkThreadViaEmojiToNewThread(ref *discordgo.MessageReference, use
 		return nil
 	}
 
+	//The policy counts reactions, which the cached copy might have missed
+	//while disconnected, so ask Discord.
+	msg, err := b.fetchChannelMessage(ref)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch full message to fork: %v", err)
+	}
+
+	//Check the guild's fork policy, which also stops a message being forked
+	//again by reactions after the one that forked it. It's weird to do this
+	//here, but we don't have the reaction count on the message until fetching
+	//the message here.
+	allowed, reason, err := b.emojiForkPolicy(msg, userID)
+	if err != nil {
+		return fmt.Errorf("couldn't check fork policy: %v", err)
+	}
+	if !allowed {
+		fmt.Printf("Fork policy: didn't fork %v for %v because %v\n", msg.ID, userID, reason)
+		return nil
+	}
+	fmt.Printf("Fork policy: forking %v for %v because %v\n", msg.ID, userID, reason)
+	b.noteEmojiFork(ref.GuildID, userID)
+	b.noteEmojiForkedMessage(msg.ID, true)
+	if err := b.forkMessageViaEmoji(msg, ref, userID); err != nil {
+		//Let a later reaction try again
+		b.noteEmojiForkedMessage(msg.ID, false)
+		return err
+	}
+	return nil
+}
+
+//forkMessageViaEmoji forks the messages up to msg, which userID reacted to,
+//into a new thread, or previews the fork if the guild wants that.
+func (b *bot) forkMessageViaEmoji(msg *discordgo.Message, ref *discordgo.MessageReference, userID string) error {
+	filteredMessages, limitReached, err := b.messagesToFork(msg, "")
+	if err != nil {
+		return err
+	}
+
+	intro := "Forking messages from <#" + ref.ChannelID + "> because of a " + FORK_THREAD_EMOJI + " reaction by <@" + userID + ">. If you don't like the auto-generated title, you can change it." + forkLimitNote(limitReached)
+
+	fork, err := b.newPendingFork(ref.GuildID, userID, intro, filteredMessages, ref)
+	if err != nil {
+		return err
+	}
+
+	if b.getGuildSettings(ref.GuildID).ForkPreview() {
+		return b.postForkPreview(fork, ref)
+	}
+
+	if _, err := b.forkToNewThread(fork); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//prepareForkToNewThread gets ready to fork a range of messages into a new
+//thread in the default group. If firstMessageID is "", the range ends at
+//lastRef and starts at the closest earlier START_FORK_THREAD_EMOJI, like the
+//emoji fork; otherwise it is every message from firstMessageID to lastRef.
+//Unlike the emoji fork it works even if disableEmojiFork is set.
+func (b *bot) prepareForkToNewThread(lastRef *discordgo.MessageReference, firstMessageID string, userID string) (*pendingFork, error) {
+	msg, err := b.channelMessage(lastRef)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch full message to fork: %v", err)
+	}
+
+	filteredMessages, limitReached, err := b.messagesToFork(msg, firstMessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	intro := "Forking messages from <#" + lastRef.ChannelID + "> at the request of <@" + userID + ">. If you don't like the auto-generated title, you can change it." + forkLimitNote(limitReached)
+
+	return b.newPendingFork(lastRef.GuildID, userID, intro, filteredMessages, nil)
+}
+
+//newPendingFork gets ready to fork the messages into a new thread titled
+//after the distinctive words in them.
+func (b *bot) newPendingFork(guildID string, userID string, intro string, messages []*discordgo.Message, trigger *discordgo.MessageReference) (*pendingFork, error) {
+	idf, err := b.getLiveIDFIndex(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch live IDF: %v", err)
+	}
+
+	tfidf := idf.TFIDFForMessages(messages...)
+
+	return &pendingFork{
+		guildID:  guildID,
+		userID:   userID,
+		title:    strings.Join(tfidf.AutoTopWords(6), "-"),
+		intro:    intro,
+		messages: messages,
+		trigger:  trigger,
+	}, nil
+}
+
+//forkToNewThread creates the fork's thread and forks its messages into it.
+func (b *bot) forkToNewThread(fork *pendingFork) (*discordgo.Channel, error) {
+	thread, err := b.createNewThreadInDefaultCategory(fork.guildID, fork.title)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create thread: %v", err)
+	}
+
+	undo := newForkUndo(fork.guildID, fork.userID, fork.trigger)
+	undo.threadID = thread.ID
+	if err := b.forkMessage(thread.ID, fork.intro, undo, fork.messages...); err != nil {
+		return thread, fmt.Errorf("couldn't fork message: %v", err)
+	}
+
+	return thread, nil
+}
+
+//forkToExistingThread forks msg, plus the messages before it back to a
+//START_FORK_THREAD_EMOJI if there is one, into a thread that already exists.
+func (b *bot) forkToExistingThread(ref *discordgo.MessageReference, thread *discordgo.Channel, userID string) error {
+	if thread.ID == ref.ChannelID {
+		return fmt.Errorf("can't fork a message into the channel it's already in")
+	}
+
 	msg, err := b.channelMessage(ref)
 	if err != nil {
 		return fmt.Errorf("couldn't fetch full message to fork: %v", err)
 	}
 
-	//Check if the message already had a thread emoji and this is another one;
-	//if so , don't start a new thread. It's weird to do this here, but we don't
-	//have the reaction count on the message until fetching the message here.
-	for _, reaction := range msg.Reactions {
-		if reaction.Emoji == nil {
-			continue
+	filteredMessages, limitReached, err := b.messagesToFork(msg, "")
+	if err != nil {
+		return err
+	}
+
+	intro := "Forking messages from <#" + ref.ChannelID + "> at the request of <@" + userID + ">." + forkLimitNote(limitReached)
+
+	if err := b.forkMessage(thread.ID, intro, newForkUndo(ref.GuildID, userID, nil), filteredMessages...); err != nil {
+		return fmt.Errorf("couldn't fork message: %v", err)
+	}
+
+	return nil
+}
+
+//messagesToFork returns the messages that should be forked if msg is the last
+//message to fork, oldest first. If firstMessageID is given, it's every message
+//from that one to msg. Otherwise it's just msg, unless an earlier message was
+//marked with START_FORK_THREAD_EMOJI, in which case it's every message from
+//that one to msg. It only looks for a START_FORK_THREAD_EMOJI in the first
+//page of earlier messages, and only forks at most maxForkMessages of them;
+//limitReached is true if it found one further back than that. For
+//firstMessageID it pages backwards through at most maxForkMessages messages,
+//and if firstMessageID is further back than that it returns an error. If the guild is configured to, it also
+//includes the messages that the messages replied to; see withReplyContext.
+func (b *bot) messagesToFork(msg *discordgo.Message, firstMessageID string) (messages []*discordgo.Message, limitReached bool, err error) {
+	messages, limitReached, err = b.messagesInForkRange(msg, firstMessageID)
+	if err != nil {
+		return nil, false, err
+	}
+	withContext := b.withReplyContext(messages, b.getGuildSettings(msg.GuildID).ReplyContextDepth())
+	if len(withContext) > MAX_FORK_MESSAGES {
+		//Too many to post before the interaction that asked for the fork
+		//expires
+		return messages, limitReached, nil
+	}
+	return withContext, limitReached, nil
+}
+
+//messagesInForkRange is messagesToFork without the reply context.
+func (b *bot) messagesInForkRange(msg *discordgo.Message, firstMessageID string) (messages []*discordgo.Message, limitReached bool, err error) {
+	if msg.ID == firstMessageID {
+		return []*discordgo.Message{msg}, false, nil
+	}
+
+	var previousMessages []*discordgo.Message
+	beforeID := msg.ID
+	for {
+		//batch will be most recent to least recent by default
+		batch, err := b.controller.ChannelMessages(msg.ChannelID, MESSAGES_TO_FETCH, beforeID, "", "")
+		if err != nil {
+			return nil, false, fmt.Errorf("couldn't fetch previous messages: %v", err)
+		}
+		//See channelMessagesWithGuildID
+		for _, previousMessage := range batch {
+			previousMessage.GuildID = msg.GuildID
 		}
-		if reaction.Emoji.Name != FORK_THREAD_EMOJI {
+		previousMessages = append(previousMessages, batch...)
+		atStartOfChannel := len(batch) < MESSAGES_TO_FETCH
+		if len(previousMessages) > maxForkMessages {
+			previousMessages = previousMessages[:maxForkMessages]
+			atStartOfChannel = false
+		}
+		result, complete := selectMessagesToFork(msg, previousMessages, firstMessageID)
+		if complete {
+			return result, false, nil
+		}
+		if firstMessageID == "" {
+			//Most forks are of a single message, so don't page through a busy
+			//channel looking for a START_FORK_THREAD_EMOJI that isn't there.
+			untruncated, _ := selectMessagesToFork(msg, batch, "")
+			return result, len(untruncated) > 1, nil
+		}
+		atLimit := len(previousMessages) >= maxForkMessages
+		if !atStartOfChannel && !atLimit {
+			beforeID = batch[len(batch)-1].ID
 			continue
 		}
-		if reaction.Count > 1 {
-			fmt.Printf("Didn't fork message because there was already one " + FORK_THREAD_EMOJI + "\n")
-			return nil
+		if atStartOfChannel {
+			return nil, false, fmt.Errorf("couldn't find the first message to fork")
 		}
+		return nil, false, fmt.Errorf("the first message is more than %v messages back, which is the most that can be forked at once", maxForkMessages)
 	}
+}
 
-	//previousMessages will be most recent to least recent by default
-	previousMessages, err := channelMessagesWithGuildID(b.session, ref.GuildID, ref.ChannelID, MESSAGES_TO_FETCH, ref.MessageID, "", "")
-	if err != nil {
-		return fmt.Errorf("couldn't fetch previous messages: %v", err)
-	}
-
+//selectMessagesToFork is the part of messagesToFork that doesn't need the
+//network. previousMessages are the messages before msg, most recent first. It
+//returns the messages to fork, oldest first, and whether previousMessages went
+//back far enough to be sure. If not, the result is just msg.
+func selectMessagesToFork(msg *discordgo.Message, previousMessages []*discordgo.Message, firstMessageID string) ([]*discordgo.Message, bool) {
 	var keptMessages []*discordgo.Message
 
 	foundThreadStart := false
+	complete := false
 
 	for _, previousMessage := range previousMessages {
 		if previousMessage.Type != discordgo.MessageTypeDefault && previousMessage.Type != discordgo.MessageTypeReply {
 			continue
 		}
+		if firstMessageID != "" {
+			keptMessages = append(keptMessages, previousMessage)
+			if previousMessage.ID == firstMessageID {
+				foundThreadStart = true
+				break
+			}
+			continue
+		}
 		hasThreadStart := false
 		hasThreadEnd := false
 		for _, reaction := range previousMessage.Reactions {
@@ -284,6 +725,7 @@ func (b *bot) forkThreadViaEmojiToNewThread(ref *discordgo.MessageReference, use
 		}
 		//If we find another thread end, then there must not be a thread start
 		if hasThreadEnd {
+			complete = true
 			break
 		}
 		keptMessages = append(keptMessages, previousMessage)
@@ -299,6 +741,7 @@ func (b *bot) forkThreadViaEmojiToNewThread(ref *discordgo.MessageReference, use
 	if foundThreadStart {
 		//Only add the other messages before if we found a thread start
 		filteredMessages = append(filteredMessages, keptMessages...)
+		complete = true
 	}
 
 	//flip it so older messages are first, and newer messages are at end.
@@ -306,31 +749,22 @@ func (b *bot) forkThreadViaEmojiToNewThread(ref *discordgo.MessageReference, use
 		filteredMessages[i], filteredMessages[j] = filteredMessages[j], filteredMessages[i]
 	}
 
-	idf, err := b.getLiveIDFIndex(ref.GuildID)
-	if err != nil {
-		return fmt.Errorf("couldn't fetch live IDF: %v", err)
-	}
-
-	tfidf := idf.TFIDFForMessages(filteredMessages...)
-
-	title := strings.Join(tfidf.AutoTopWords(6), "-")
-
-	thread, err := b.createNewThreadInDefaultCategory(ref.GuildID, title)
-	if err != nil {
-		return fmt.Errorf("couldn't create thread: %v", err)
-	}
-
-	refs := make([]*discordgo.MessageReference, len(filteredMessages))
+	return filteredMessages, complete
+}
 
-	for i, msg := range filteredMessages {
-		refs[i] = msg.Reference()
+func forkProgressMessage(done, total int) string {
+	if done == total {
+		return "Forked all " + strconv.Itoa(total) + " messages."
 	}
+	return "Forking " + strconv.Itoa(total) + " messages, " + strconv.Itoa(done) + " done so far..."
+}
 
-	if err := b.forkMessage(thread.ID, userID, refs...); err != nil {
-		return fmt.Errorf("couldn't fork message: %v", err)
+//forkLimitNote explains, if limitReached, why only one message was forked.
+func forkLimitNote(limitReached bool) string {
+	if !limitReached {
+		return ""
 	}
-
-	return nil
+	return " Only the last message was forked: the " + START_FORK_THREAD_EMOJI + " is more than " + strconv.Itoa(maxForkMessages) + " messages back, the most the bot will fork at once."
 }
 
 //session.channelMessages doesn't include GuildID. This sets it. See also bot.channelMessage()
@@ -349,21 +783,15 @@ func urlForMessage(message *discordgo.Message) string {
 	return "https://discord.com/channels/" + message.GuildID + "/" + message.ChannelID + "/" + message.ID
 }
 
-//messageIsForkOf returns a non-zero-length string of the original message ID if
-//the given message appears to be a forked message
+//messageIsForkOf returns a reference to the original message if the given
+//message appears to be a forked message, either an embed fork or a webhook
+//fork, and nil otherwise.
 func messageIsForkOf(message *discordgo.Message) *discordgo.MessageReference {
-	if len(message.Embeds) == 0 {
-		return nil
+	if ref := webhookForkSourceRef(message); ref != nil {
+		return ref
 	}
-	for _, embed := range message.Embeds {
-		if embed.Title != FORKED_MESSAGE_LINK_TEXT {
-			continue
-		}
-		urlPieces := strings.Split(embed.URL, "/")
-		return &discordgo.MessageReference{
-			ChannelID: urlPieces[len(urlPieces)-2],
-			MessageID: urlPieces[len(urlPieces)-1],
-		}
+	if _, metadata := mainForkEmbed(message); metadata != nil {
+		return metadata.reference()
 	}
 	return nil
 }
@@ -379,10 +807,13 @@ func messageEmbedAuthorForMessage(message *discordgo.Message) *discordgo.Message
 	}
 }
 
-//This is how we'll decide if a message with an embed is a forked message
+//The title of an embed fork. Forks from before they recorded metadata are
+//recognized by it.
 const FORKED_MESSAGE_LINK_TEXT = "originally said:"
 
-func createForkMessageEmbed(msg *discordgo.Message) *discordgo.MessageEmbed {
+//forkReactionDescriptions describes each of msg's reactions other than the
+//ones used to fork it.
+func forkReactionDescriptions(msg *discordgo.Message) []string {
 	var emojiDescriptions []string
 	for _, reaction := range msg.Reactions {
 		if reaction.Emoji.Name == FORK_THREAD_EMOJI {
@@ -393,32 +824,54 @@ func createForkMessageEmbed(msg *discordgo.Message) *discordgo.MessageEmbed {
 		}
 		emojiDescriptions = append(emojiDescriptions, reaction.Emoji.MessageFormat()+" : "+strconv.Itoa(reaction.Count))
 	}
+	return emojiDescriptions
+}
+
+func createForkMessageEmbed(msg *discordgo.Message) *discordgo.MessageEmbed {
+	emojiDescriptions := forkReactionDescriptions(msg)
 	var fields []*discordgo.MessageEmbedField
+	if context := replyContext(msg, func(url string) string { return url }); context != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  "In reply to",
+			Value: context,
+		})
+	}
 	if len(emojiDescriptions) > 0 {
 		fields = append(fields, &discordgo.MessageEmbedField{
 			Name:   "Reactions",
-			Value:  strings.Join(emojiDescriptions, "\t"),
+			Value:  truncateString(strings.Join(emojiDescriptions, "\t"), MAX_EMBED_FIELD_VALUE_LENGTH),
 			Inline: true,
 		})
 	}
-	//Note: if you change this, also change messageIsFork to be able to detect
-	//it!
+	//The footer is how messageIsForkOf detects it
 	return &discordgo.MessageEmbed{
 		Title:       FORKED_MESSAGE_LINK_TEXT,
-		Description: msg.Content,
+		Description: truncateString(msg.Content, MAX_EMBED_DESCRIPTION_LENGTH),
 		Author:      messageEmbedAuthorForMessage(msg),
 		URL:         urlForMessage(msg),
 		Fields:      fields,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: newForkMetadata(msg).footerText(),
+		},
 	}
 }
 
-//channelRef is a wrapper around session.ChannelMessage. The Discord API for
-//some reason omits GuildID for messages fetched via ChannelMessage, but other
-//processing assumes it exists. This method will fetch it but also stuff the
-//GuildID in. See also channelMessagesWithGuildID
+//channelRef is a wrapper around controller.ChannelMessage that uses the
+//message cache if it can. The Discord API for some reason omits GuildID for
+//messages fetched via ChannelMessage, but other processing assumes it exists.
+//This method will fetch it but also stuff the GuildID in. See also
+//channelMessagesWithGuildID
 func (b *bot) channelMessage(ref *discordgo.MessageReference) (*discordgo.Message, error) {
-	//OK, it has forks, we need to fetch the updated message.
-	msg, err := b.session.ChannelMessage(ref.ChannelID, ref.MessageID)
+	if msg := b.messageCache.get(ref.MessageID); msg != nil {
+		return msg, nil
+	}
+	return b.fetchChannelMessage(ref)
+}
+
+//fetchChannelMessage fetches the message from Discord even if it's cached,
+//and caches it.
+func (b *bot) fetchChannelMessage(ref *discordgo.MessageReference) (*discordgo.Message, error) {
+	msg, err := b.controller.ChannelMessage(ref.ChannelID, ref.MessageID)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't fetch the raw updated message: %v", err)
 	}
@@ -426,19 +879,50 @@ func (b *bot) channelMessage(ref *discordgo.MessageReference) (*discordgo.Messag
 	//ChannelMessage with a zeroed guildID! Stuff it in for the sake of
 	//downstream stuff...
 	msg.GuildID = ref.GuildID
+	b.messageCache.put(msg)
 	return msg, nil
 }
 
+//The most forks of forks originalOfFork follows back
+const MAX_FORK_SOURCE_DEPTH = 10
+
+//originalOfFork returns the message that msg is a fork of, or that message is
+//a fork of, and so on, so that forking a fork copies the original rather than
+//making an embed of an embed. If msg isn't a fork, or the original can't be
+//fetched, it returns the furthest back message it could get.
+func (b *bot) originalOfFork(msg *discordgo.Message) *discordgo.Message {
+	visited := map[string]bool{msg.ID: true}
+	for i := 0; i < MAX_FORK_SOURCE_DEPTH; i++ {
+		source := messageIsForkOf(msg)
+		if source == nil || visited[source.MessageID] {
+			break
+		}
+		visited[source.MessageID] = true
+		if source.GuildID == "" {
+			source.GuildID = msg.GuildID
+		}
+		original, err := b.channelMessage(source)
+		if err != nil {
+			fmt.Printf("couldn't fetch %v, which %v is a fork of: %v\n", source.MessageID, msg.ID, err)
+			break
+		}
+		msg = original
+	}
+	return msg
+}
+
 func (b *bot) updateForkedMessagesIfTheyExist(ref *discordgo.MessageReference) error {
 	idf, err := b.getLiveIDFIndex(ref.GuildID)
 	if err != nil {
 		return fmt.Errorf("couldn't get idf in update forked messages if they exist: %v", err)
 	}
 	forks := idf.MessageForks(ref.ChannelID, ref.MessageID)
-	if len(forks) == 0 {
+	if len(forks) == 0 || idf.ForkSource(ref.ChannelID, ref.MessageID) != nil {
+		//Forks of forks are kept in sync with the original instead
 		return nil
 	}
-	//OK, it has forks, we need to fetch the updated message.
+	//OK, it has forks, we need the updated message. The reaction events keep
+	//the cached copy current, so this is usually free.
 	sourceMessage, err := b.channelMessage(ref)
 	if err != nil {
 		return fmt.Errorf("couldn't fetch the raw updated message: %v", err)
@@ -461,19 +945,31 @@ func restErrorCode(err error) int {
 	return NOT_REST_ERROR_CODE
 }
 
-//updates the forked messages that are forks of sourceMessage, if there are any
+//updates the forked messages that are forks of sourceMessage, if there are
+//any, along with forks of those forks, so that they all show sourceMessage.
 func (b *bot) updateForkedMessages(sourceMessage *discordgo.Message) error {
 	idf, err := b.getLiveIDFIndex(sourceMessage.GuildID)
 	if err != nil {
 		return fmt.Errorf("couldn't get idf in message update: %v", err)
 	}
-	forks := idf.MessageForks(sourceMessage.ChannelID, sourceMessage.ID)
+	if idf.ForkSource(sourceMessage.ChannelID, sourceMessage.ID) != nil {
+		//sourceMessage is itself a fork, probably one we just updated. Its
+		//forks are kept in sync with the original instead.
+		return nil
+	}
+	forks := idf.MessageForkDescendants(sourceMessage.ChannelID, sourceMessage.ID)
 	if len(forks) == 0 {
 		return nil
 	}
-	embed := createForkMessageEmbed(sourceMessage)
+	embeds := createForkMessageEmbeds(sourceMessage)
 	for _, fork := range forks {
-		if _, err := b.session.ChannelMessageEditEmbed(fork.ChannelID, fork.MessageID, embed); err != nil {
+		var err error
+		if webhookID := idf.ForkWebhookID(fork); webhookID != "" {
+			err = b.editWebhookFork(webhookID, fork, sourceMessage)
+		} else {
+			_, err = b.controller.ChannelMessageEditEmbeds(fork.ChannelID, fork.MessageID, embeds)
+		}
+		if err != nil {
 			if restErrorCode(err) == discordgo.ErrCodeUnknownMessage {
 				//Perhaps the forked message that we saw at some point
 				//has been deleted. That's fine, just skip it!
@@ -486,126 +982,520 @@ func (b *bot) updateForkedMessages(sourceMessage *discordgo.Message) error {
 	return nil
 }
 
-func (b *bot) forkMessage(targetChannelID string, userID string, sourceRefs ...*discordgo.MessageReference) error {
+//forkMessage posts intro to the target channel, followed by a fork of each of
+//the source messages, and then posts a read out in the source channel. If
+//undo is given, the read out has a button to undo the fork during its grace
+//period.
+func (b *bot) forkMessage(targetChannelID string, intro string, undo *forkUndo, sourceMessages ...*discordgo.Message) error {
 
-	if len(sourceRefs) == 0 {
+	if len(sourceMessages) == 0 {
 		return nil
 	}
 
-	firstRef := sourceRefs[0]
-
-	if _, err := b.session.ChannelMessageSend(targetChannelID, "Forking messages from <#"+firstRef.ChannelID+"> because of a "+FORK_THREAD_EMOJI+" reaction by <@"+userID+">. If you don't like the auto-generated title, you can change it."); err != nil {
+	introMessage, err := b.session.ChannelMessageSend(targetChannelID, intro)
+	if err != nil {
 		return fmt.Errorf("couldn't post initial thread messagae: %v", err)
 	}
+	undo.notePosted(introMessage)
 
-	for i, sourceRef := range sourceRefs {
-		//TODO: it is expensive to fetch each of these individually, especially
-		//since likely upstream we have them already.
-		msg, err := b.channelMessage(sourceRef)
+	useWebhook := b.getGuildSettings(sourceMessages[0].GuildID).WebhookForks()
+
+	var progress *discordgo.Message
+	if len(sourceMessages) > FORK_BATCH_SIZE {
+		var err error
+		progress, err = b.session.ChannelMessageSend(targetChannelID, forkProgressMessage(0, len(sourceMessages)))
 		if err != nil {
-			return fmt.Errorf("couldn't fetch message %v: %v", i, err)
+			return fmt.Errorf("couldn't post progress message: %v", err)
+		}
+		undo.notePosted(progress)
+	}
+
+	for i, msg := range sourceMessages {
+		if i > 0 && i%FORK_BATCH_SIZE == 0 {
+			if progress != nil {
+				if _, err := b.session.ChannelMessageEdit(targetChannelID, progress.ID, forkProgressMessage(i, len(sourceMessages))); err != nil {
+					//Not worth failing the fork over
+					fmt.Printf("couldn't update fork progress: %v\n", err)
+				}
+			}
+			time.Sleep(FORK_BATCH_INTERVAL)
+		}
+		//It might have been edited since it was fetched, for example while
+		//the fork was waiting to be confirmed.
+		if cached := b.messageCache.get(msg.ID); cached != nil {
+			msg = cached
+		}
+		msg = b.originalOfFork(msg)
+
+		if useWebhook {
+			posted, err := b.sendWebhookFork(targetChannelID, msg)
+			if err == nil {
+				undo.notePosted(posted)
+				continue
+			}
+			fmt.Printf("couldn't send message %v via webhook, sending it as an embed: %v\n", i, err)
 		}
 
-		embed := createForkMessageEmbed(msg)
+		posted, err := b.session.ChannelMessageSendEmbeds(targetChannelID, createForkMessageEmbeds(msg))
+		if err != nil {
+			//Perhaps one of the attachments or embeds was something Discord
+			//won't take; don't fail the whole fork over it.
+			fmt.Printf("couldn't send message %v with its attachments and embeds, sending just its text: %v\n", i, err)
+			posted, err = b.session.ChannelMessageSendEmbed(targetChannelID, createForkMessageEmbed(msg))
+			if err != nil {
+				return fmt.Errorf("couldn't send message %v: %v", i, err)
+			}
+		}
+		undo.notePosted(posted)
+	}
 
-		if _, err := b.session.ChannelMessageSendEmbed(targetChannelID, embed); err != nil {
-			return fmt.Errorf("couldn't send message %v: %v", i, err)
+	if progress != nil {
+		if _, err := b.session.ChannelMessageEdit(targetChannelID, progress.ID, forkProgressMessage(len(sourceMessages), len(sourceMessages))); err != nil {
+			fmt.Printf("couldn't update fork progress: %v\n", err)
 		}
 	}
 
-	lastSourceRef := sourceRefs[len(sourceRefs)-1]
+	lastSourceRef := sourceMessages[len(sourceMessages)-1].Reference()
 
 	var message string
 
-	if len(sourceRefs) == 1 {
+	if len(sourceMessages) == 1 {
 		message = "Forked 1 message to <#" + targetChannelID + ">. If you would have marked an earlier message with " + START_FORK_THREAD_EMOJI + " then all of the messages between the two emojis would have been forked."
 	} else {
-		message = "Forked " + strconv.Itoa(len(sourceRefs)) + " messages to <#" + targetChannelID + ">."
+		message = "Forked " + strconv.Itoa(len(sourceMessages)) + " messages to <#" + targetChannelID + ">."
 	}
 
 	data := &discordgo.MessageSend{
 		Content:   message,
 		Reference: lastSourceRef,
 	}
+	if undo != nil {
+		undo.id = introMessage.ID
+		data.Components = undoForkComponents(undo.id)
+	}
 
-	if _, err := b.session.ChannelMessageSendComplex(lastSourceRef.ChannelID, data); err != nil {
+	readOut, err := b.session.ChannelMessageSendComplex(lastSourceRef.ChannelID, data)
+	if err != nil {
 		return fmt.Errorf("couldn't post read out message for fork: %v", err)
 	}
 
+	if undo != nil {
+		undo.readOut = messageReference(lastSourceRef.GuildID, readOut.ChannelID, readOut.ID)
+		b.registerForkUndo(undo)
+	}
+
 	return nil
 
 }
 
 func (b *bot) interactionCreate(s *discordgo.Session, event *discordgo.InteractionCreate) {
-	//NOTE: all handlers must use s.InteractionRespond or the user will see an error.
-	switch event.Interaction.Data.Name {
-	case ARCHIVE_COMMAND_NAME:
-		b.archiveThreadInteraction(s, event)
-	case SUGGEST_THREAD_NAME_COMMAND_NAME:
-		b.suggestThreadNameInteraction(s, event)
+	switch event.Type {
+	case discordgo.InteractionApplicationCommand:
+		//NOTE: all handlers must use s.InteractionRespond or the user will see an error.
+		switch event.ApplicationCommandData().Name {
+		case ARCHIVE_COMMAND_NAME:
+			b.archiveThreadInteraction(s, event)
+		case UNARCHIVE_COMMAND_NAME:
+			b.unarchiveThreadInteraction(s, event)
+		case PIN_THREAD_COMMAND_NAME:
+			b.pinThreadInteraction(s, event, true)
+		case UNPIN_THREAD_COMMAND_NAME:
+			b.pinThreadInteraction(s, event, false)
+		case SUGGEST_THREAD_NAME_COMMAND_NAME:
+			b.suggestThreadNameInteraction(s, event)
+		case NEW_THREAD_COMMAND_NAME:
+			b.newThreadInteraction(s, event)
+		case MOVE_THREAD_COMMAND_NAME:
+			b.moveThreadInteraction(s, event)
+		case FORK_TO_EXISTING_THREAD_COMMAND_NAME:
+			b.forkToExistingThreadInteraction(s, event)
+		case FORK_TO_NEW_THREAD_COMMAND_NAME:
+			b.forkToNewThreadInteraction(s, event, false)
+		case FORK_TO_LATEST_COMMAND_NAME:
+			b.forkToNewThreadInteraction(s, event, true)
+		case THREAD_GROUP_CONFIG_COMMAND_NAME:
+			b.threadGroupConfigInteraction(s, event)
+		case FORK_CONFIG_COMMAND_NAME:
+			b.forkConfigInteraction(s, event)
+		case FORKS_COMMAND_NAME, FORKS_CONTEXT_COMMAND_NAME:
+			b.forksInteraction(s, event)
+		case FORK_TO_OTHER_SERVER_COMMAND_NAME:
+			b.forkToOtherGuildInteraction(s, event)
+		case COMPACT_ARCHIVES_COMMAND_NAME:
+			b.compactArchivesInteraction(s, event)
+		case EXPORT_ARCHIVES_COMMAND_NAME:
+			b.exportArchivesInteraction(s, event)
+		default:
+			fmt.Println("Unknown interaction name: " + event.ApplicationCommandData().Name)
+		}
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		switch event.ApplicationCommandData().Name {
+		case UNARCHIVE_COMMAND_NAME:
+			b.unarchiveThreadAutocomplete(s, event)
+		case THREAD_GROUP_CONFIG_COMMAND_NAME, COMPACT_ARCHIVES_COMMAND_NAME, EXPORT_ARCHIVES_COMMAND_NAME, NEW_THREAD_COMMAND_NAME, MOVE_THREAD_COMMAND_NAME:
+			b.threadGroupAutocomplete(s, event, GROUP_OPTION_NAME)
+		default:
+			fmt.Println("Unknown autocomplete interaction name: " + event.ApplicationCommandData().Name)
+		}
+	case discordgo.InteractionMessageComponent:
+		customID := event.MessageComponentData().CustomID
+		switch strings.Split(customID, ":")[0] {
+		case FORK_TO_EXISTING_THREAD_SELECT_ID:
+			b.forkToExistingThreadSelected(s, event)
+		case FORK_TO_EXISTING_THREAD_PAGE_ID:
+			b.forkToExistingThreadPage(s, event)
+		case UNDO_FORK_BUTTON_ID:
+			b.undoForkInteraction(s, event)
+		case FORK_PREVIEW_ID:
+			b.forkPreviewInteraction(s, event)
+		default:
+			fmt.Println("Unknown message component: " + customID)
+		}
+	case discordgo.InteractionModalSubmit:
+		customID := event.ModalSubmitData().CustomID
+		switch strings.Split(customID, ":")[0] {
+		case FORK_PREVIEW_ID:
+			b.forkPreviewInteraction(s, event)
+		default:
+			fmt.Println("Unknown modal: " + customID)
+		}
 	default:
-		fmt.Println("Unknown interaction name: " + event.Interaction.Data.Name)
+		fmt.Printf("Unknown interaction type: %v\n", event.Type)
 	}
 }
 
-func (b *bot) noteMessageIfFork(msg *discordgo.Message) error {
-	forkedFrom := messageIsForkOf(msg)
-	if forkedFrom == nil {
-		return nil
-	}
-	fmt.Printf("Indexing %v which appears to be a fork\n", msg.ID)
-
-	idf, err := b.getLiveIDFIndex(msg.GuildID)
-	if err != nil {
-		return fmt.Errorf("couldn't fetch idf: %v", err)
+//interactionOption returns the top-level option with the given name, or nil if
+//it wasn't provided.
+func interactionOption(event *discordgo.InteractionCreate, name string) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, option := range event.ApplicationCommandData().Options {
+		if option.Name == name {
+			return option
+		}
 	}
-	idf.NoteForkedMessage(forkedFrom, msg.Reference())
-	idf.RequestPeristence()
 	return nil
 }
 
-func (b *bot) scheduleRebuildIDFCache() {
-	if b.rebuildIDFTimer != nil {
-		b.rebuildIDFTimer.Stop()
-	}
-	//Set up timer to rebuild IDF caches automatically. We run way more often
-	//than the actual interval; if we run too early, it's OK, we'll just load
-	//the cache from disk without rebuilding. Running so often means that we
-	//avoid lots of timing issues where we run _just_ before the cache expires,
-	//meaning we would have waited roughly 2x the expiration interval.
-	b.rebuildIDFTimer = time.AfterFunc(REBUILD_IDF_INTERVAL/16, b.rebuildIDFCaches)
-}
-
-func (b *bot) getLiveIDFIndex(guildID string) (*IDFIndex, error) {
-	result := b.indexes[guildID]
-	if result != nil {
-		return result, nil
-	}
-	result, err := IDFIndexForGuild(guildID, b.session)
+//latestMessageID returns the ID of the newest message in the channel. The
+//channel's LastMessageID in State can be out of date, so it asks Discord.
+func (b *bot) latestMessageID(channelID string) (string, error) {
+	messages, err := b.controller.ChannelMessages(channelID, 1, "", "", "")
 	if err != nil {
-		return nil, fmt.Errorf("couldn't fetch live IDF index: %v", err)
+		return "", fmt.Errorf("couldn't fetch the latest message: %w", err)
 	}
-	b.indexes[guildID] = result
-	return result, nil
+	if len(messages) == 0 {
+		return "", fmt.Errorf("the channel has no messages")
+	}
+	return messages[0].ID, nil
 }
 
-func (b *bot) rebuildIDFCaches() {
-	fmt.Printf("Checking if IDF caches need rebuilding\n")
+//forkToNewThreadInteraction handles the message context menu commands that
+//fork into a new thread. If toLatest is true, the clicked message is the first
+//message forked and the latest message in the channel is the last; otherwise
+//the clicked message is the last, just like reacting to it with
+//FORK_THREAD_EMOJI.
+func (b *bot) forkToNewThreadInteraction(s *discordgo.Session, event *discordgo.InteractionCreate, toLatest bool) {
+	//Fetching and posting the messages can take longer than the 3 seconds we
+	//have to respond.
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: uint64(discordgo.MessageFlagsEphemeral),
+		},
+	})
 
-	for guildID := range b.infos {
-		if !IDFIndexForGuildNeedsRebuilding(guildID) && b.indexes[guildID] != nil {
-			continue
-		}
-		idf, err := IDFIndexForGuild(guildID, b.session)
-		if err != nil {
-			fmt.Printf("couldn't recreate guild idf for guild %v: %v\n", guildID, err)
+	var userID string
+	if event.Member != nil && event.Member.User != nil {
+		userID = event.Member.User.ID
+	}
+	messageID := event.ApplicationCommandData().TargetID
+	lastRef := messageReference(event.GuildID, event.ChannelID, messageID)
+	firstMessageID := ""
+
+	message := "Couldn't fork: "
+	var err error
+	if toLatest {
+		firstMessageID = messageID
+		lastRef.MessageID, err = b.latestMessageID(event.ChannelID)
+	}
+	var fork *pendingFork
+	if err == nil {
+		fork, err = b.prepareForkToNewThread(lastRef, firstMessageID, userID)
+	}
+	var components []discordgo.MessageComponent
+	if err == nil && b.getGuildSettings(event.GuildID).ForkPreview() {
+		b.notePendingFork(fork)
+		message = forkPreviewContent(fork)
+		components = forkPreviewComponents(fork.id)
+	} else if err == nil {
+		var thread *discordgo.Channel
+		thread, err = b.forkToNewThread(fork)
+		if err == nil {
+			message = "Forked to <#" + thread.ID + ">"
 		}
-		b.indexes[guildID] = idf
 	}
-	b.scheduleRebuildIDFCache()
+	if err != nil {
+		message += err.Error()
+		fmt.Println(message)
+	}
+
+	s.InteractionResponseEdit(s.State.User.ID, event.Interaction, &discordgo.WebhookEdit{
+		Content:    message,
+		Components: components,
+	})
 }
 
-func (b *bot) suggestThreadNameInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+//forkToExistingThreadInteraction responds to the message context menu command
+//with a picker of threads to fork the message into.
+func (b *bot) forkToExistingThreadInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	messageID := event.ApplicationCommandData().TargetID
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: b.existingThreadPicker(event.GuildID, event.ChannelID, messageID, 0),
+	})
+}
+
+//forkToExistingThreadPage shows another page of the thread picker.
+func (b *bot) forkToExistingThreadPage(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	customID := event.MessageComponentData().CustomID
+	pieces := strings.Split(customID, ":")
+	var data *discordgo.InteractionResponseData
+	if len(pieces) != 4 {
+		data = &discordgo.InteractionResponseData{
+			Content:    "Couldn't show more threads: Unexpected button " + customID,
+			Components: []discordgo.MessageComponent{},
+		}
+	} else {
+		offset, _ := strconv.Atoi(pieces[3])
+		data = b.existingThreadPicker(event.GuildID, pieces[1], pieces[2], offset)
+	}
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: data,
+	})
+}
+
+//existingThreadPicker asks which active thread the message should be forked
+//into. A select menu only fits MAX_SELECT_MENU_OPTIONS threads, so it shows
+//the ones starting at offset, with buttons to page through the rest.
+func (b *bot) existingThreadPicker(guildID, channelID, messageID string, offset int) *discordgo.InteractionResponseData {
+	var threads []*discordgo.Channel
+	for _, thread := range b.activeThreads(guildID) {
+		if thread.ID != channelID {
+			threads = append(threads, thread)
+		}
+	}
+
+	data := &discordgo.InteractionResponseData{
+		Flags: uint64(discordgo.MessageFlagsEphemeral),
+	}
+	if len(threads) == 0 {
+		data.Content = "There are no other active threads to fork into."
+		data.Components = []discordgo.MessageComponent{}
+		return data
+	}
+	if offset < 0 || offset >= len(threads) {
+		offset = 0
+	}
+	end := offset + MAX_SELECT_MENU_OPTIONS
+	if end > len(threads) {
+		end = len(threads)
+	}
+
+	var options []discordgo.SelectMenuOption
+	for _, thread := range threads[offset:end] {
+		option := discordgo.SelectMenuOption{
+			Label: "#" + thread.Name,
+			Value: thread.ID,
+		}
+		if group := b.getThreadGroupInfoForThread(thread); group != nil {
+			option.Description = b.displayNameForThreadGroup(group)
+		}
+		options = append(options, option)
+	}
+
+	data.Content = "Which thread should the message be forked into? If you marked an earlier message with " + START_FORK_THREAD_EMOJI + " everything from there on will be forked."
+	data.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:    FORK_TO_EXISTING_THREAD_SELECT_ID + ":" + channelID + ":" + messageID,
+					Placeholder: "Choose a thread",
+					Options:     options,
+				},
+			},
+		},
+	}
+	if len(threads) <= MAX_SELECT_MENU_OPTIONS {
+		return data
+	}
+
+	data.Content += " Showing threads " + strconv.Itoa(offset+1) + " to " + strconv.Itoa(end) + " of " + strconv.Itoa(len(threads)) + "."
+	pageID := FORK_TO_EXISTING_THREAD_PAGE_ID + ":" + channelID + ":" + messageID + ":"
+	var buttons []discordgo.MessageComponent
+	if offset > 0 {
+		previous := offset - MAX_SELECT_MENU_OPTIONS
+		if previous < 0 {
+			previous = 0
+		}
+		buttons = append(buttons, discordgo.Button{
+			Label:    "Previous threads",
+			Style:    discordgo.SecondaryButton,
+			CustomID: pageID + strconv.Itoa(previous),
+		})
+	}
+	if end < len(threads) {
+		buttons = append(buttons, discordgo.Button{
+			Label:    "More threads",
+			Style:    discordgo.SecondaryButton,
+			CustomID: pageID + strconv.Itoa(end),
+		})
+	}
+	data.Components = append(data.Components, discordgo.ActionsRow{
+		Components: buttons,
+	})
+	return data
+}
+
+//forkToExistingThreadSelected does the fork once a thread has been picked from
+//the menu sent by forkToExistingThreadInteraction.
+func (b *bot) forkToExistingThreadSelected(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	data := event.MessageComponentData()
+
+	//Fetching and posting the messages can take longer than the 3 seconds we
+	//have to respond.
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+
+	message := "Couldn't fork: "
+	pieces := strings.Split(data.CustomID, ":")
+	var userID string
+	if event.Member != nil && event.Member.User != nil {
+		userID = event.Member.User.ID
+	}
+	if len(pieces) != 3 || len(data.Values) == 0 {
+		message += "Unexpected thread picker " + data.CustomID
+	} else if thread, err := b.session.State.Channel(data.Values[0]); err != nil {
+		message += "Couldn't find that thread: " + err.Error()
+	} else if err := b.forkToExistingThread(messageReference(event.GuildID, pieces[1], pieces[2]), thread, userID); err != nil {
+		message += err.Error()
+		fmt.Println(message)
+	} else {
+		message = "Forked to <#" + thread.ID + ">"
+	}
+
+	s.InteractionResponseEdit(s.State.User.ID, event.Interaction, &discordgo.WebhookEdit{
+		Content:    message,
+		Components: []discordgo.MessageComponent{},
+	})
+}
+
+func (b *bot) noteMessageIfFork(msg *discordgo.Message) error {
+	if messageIsForkOf(msg) == nil {
+		return nil
+	}
+	fmt.Printf("Indexing %v which appears to be a fork\n", msg.ID)
+
+	idf, err := b.getLiveIDFIndex(msg.GuildID)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch idf: %v", err)
+	}
+	idf.NoteForkMessage(msg)
+	idf.RequestPeristence()
+	return b.noteCrossGuildFork(msg)
+}
+
+func (b *bot) scheduleRebuildIDFCache() {
+	if b.rebuildIDFTimer != nil {
+		b.rebuildIDFTimer.Stop()
+	}
+	//Set up timer to rebuild IDF caches automatically. We run way more often
+	//than the actual interval; if we run too early, it's OK, we'll just load
+	//the cache from disk without rebuilding. Running so often means that we
+	//avoid lots of timing issues where we run _just_ before the cache expires,
+	//meaning we would have waited roughly 2x the expiration interval.
+	b.rebuildIDFTimer = time.AfterFunc(REBUILD_IDF_INTERVAL/16, b.rebuildIDFCaches)
+}
+
+func (b *bot) scheduleIdleArchiveSweep() {
+	if b.idleSweepTimer != nil {
+		b.idleSweepTimer.Stop()
+	}
+	b.idleSweepTimer = time.AfterFunc(IDLE_ARCHIVE_SWEEP_INTERVAL, func() {
+		b.archiveIdleThreads()
+		b.scheduleIdleArchiveSweep()
+	})
+}
+
+//archiveIdleThreads archives threads in every guild that haven't had a message
+//in longer than their group's idle duration.
+func (b *bot) archiveIdleThreads() {
+	for _, guild := range b.session.State.Guilds {
+		for _, group := range b.getInfos(guild.ID) {
+			if err := group.archiveIdleThreads(b.controller, b.session); err != nil {
+				fmt.Printf("Couldn't archive idle threads: %v\n", err)
+			}
+			if _, err := group.pruneArchives(b.controller, b.session, b.exporter); err != nil {
+				fmt.Printf("Couldn't prune archived threads: %v\n", err)
+			}
+		}
+	}
+}
+
+func (b *bot) getLiveIDFIndex(guildID string) (*IDFIndex, error) {
+	result := b.indexes[guildID]
+	if result != nil {
+		return result, nil
+	}
+	result, err := IDFIndexForGuild(guildID, b.session)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch live IDF index: %v", err)
+	}
+	b.indexes[guildID] = result
+	b.shareCrossGuildForks(guildID)
+	return result, nil
+}
+
+func (b *bot) getPendingMoves(guildID string) *pendingChannelMoves {
+	b.infoMutex.Lock()
+	defer b.infoMutex.Unlock()
+	result := b.pendingMoves[guildID]
+	if result == nil {
+		result = newPendingChannelMoves()
+		b.pendingMoves[guildID] = result
+	}
+	return result
+}
+
+func (b *bot) getGuildSettings(guildID string) *GuildSettings {
+	result := b.settings[guildID]
+	if result != nil {
+		return result
+	}
+	result = LoadGuildSettings(guildID)
+	b.settings[guildID] = result
+	return result
+}
+
+func (b *bot) rebuildIDFCaches() {
+	fmt.Printf("Checking if IDF caches need rebuilding\n")
+
+	for guildID := range b.infos {
+		if !IDFIndexForGuildNeedsRebuilding(guildID) && b.indexes[guildID] != nil {
+			continue
+		}
+		idf, err := IDFIndexForGuild(guildID, b.session)
+		if err != nil {
+			fmt.Printf("couldn't recreate guild idf for guild %v: %v\n", guildID, err)
+		}
+		b.indexes[guildID] = idf
+		b.shareCrossGuildForks(guildID)
+	}
+	b.scheduleRebuildIDFCache()
+}
+
+func (b *bot) suggestThreadNameInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
 
 	//We have to respond to the message within 3 seconds, and it might take
 	//longer to fetch all messages, so we have to do a deferred channel message.
@@ -673,12 +1563,576 @@ func (b *bot) archiveThreadInteraction(s *discordgo.Session, event *discordgo.In
 
 	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionApplicationCommandResponseData{
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+		},
+	})
+}
+
+func (b *bot) pinThreadInteraction(s *discordgo.Session, event *discordgo.InteractionCreate, pinned bool) {
+
+	channel, err := b.session.State.Channel(event.ChannelID)
+	if err != nil {
+		//TODO: respond to the interaction in the canonical way so it shows up in user's UI
+		fmt.Printf("Couldn't fetch channel %v: %v\n", event.ChannelID, err)
+		return
+	}
+	message := "Couldn't change pin: "
+	if !memberCanManageChannels(event) {
+		message += "You need the Manage Channels permission to pin or unpin threads"
+	} else if b.isThread(channel) {
+		if err := b.setThreadPinned(channel, pinned); err != nil {
+			message += err.Error()
+			fmt.Println(message)
+		} else if pinned {
+			message = "Pinned thread! It will stay where it is and won't be archived."
+		} else {
+			message = "Unpinned thread! It will be reordered and archived like other threads."
+		}
+	} else {
+		message += "This channel is not a thread!"
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+		},
+	})
+}
+
+//setThreadPinned persists whether the thread is pinned. Unpinning might put
+//the group over its limit, so it archives threads if necessary.
+func (b *bot) setThreadPinned(thread *discordgo.Channel, pinned bool) error {
+	b.getGuildSettings(thread.GuildID).SetThreadPinned(thread.ID, pinned)
+	b.setGuildNeedsInfoRegeneration(thread.GuildID)
+	if pinned {
+		return nil
+	}
+	gi := b.getThreadGroupInfoForThread(thread)
+	if gi == nil {
+		return nil
+	}
+	if err := gi.archiveThreadsIfNecessary(b.controller, b.session); err != nil {
+		return fmt.Errorf("couldn't archive threads after unpinning: %w", err)
+	}
+	return nil
+}
+
+func (b *bot) moveThreadInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	var value string
+	if option := interactionOption(event, GROUP_OPTION_NAME); option != nil {
+		value = option.StringValue()
+	}
+
+	message := "Couldn't move thread: "
+	channel, err := b.session.State.Channel(event.ChannelID)
+	destination := b.findThreadGroup(event.GuildID, value)
+	switch {
+	case err != nil:
+		message += "Couldn't fetch channel: " + err.Error()
+	case !b.isThread(channel):
+		message += "This channel is not a thread!"
+	case destination == nil:
+		message += "Couldn't find a thread group called " + value
+	default:
+		if err := b.moveThread(channel, destination); err != nil {
+			message += err.Error()
+			fmt.Println(message)
+		} else {
+			message = "Moved thread to **" + b.displayNameForThreadGroup(destination) + "**"
+		}
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+		},
+	})
+}
+
+func (b *bot) unarchiveThreadInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+
+	message := "Couldn't unarchive: "
+	var value string
+	if option := interactionOption(event, THREAD_OPTION_NAME); option != nil {
+		value = option.StringValue()
+	}
+	thread := b.findArchivedThread(event.GuildID, value)
+	if thread != nil {
+		if err := b.unarchiveThread(thread); err != nil {
+			message += err.Error()
+			fmt.Println(message)
+		} else {
+			message = "Unarchived <#" + thread.ID + ">!"
+		}
+	} else {
+		message += "Couldn't find an archived thread called " + value
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
 			Content: message,
 		},
 	})
 }
 
+func (b *bot) unarchiveThreadAutocomplete(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	var partial string
+	if option := interactionOption(event, THREAD_OPTION_NAME); option != nil {
+		partial = strings.ToLower(option.StringValue())
+	}
+
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, thread := range b.archivedThreads(event.GuildID) {
+		if !strings.Contains(strings.ToLower(thread.Name), partial) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  thread.Name,
+			Value: thread.ID,
+		})
+		if len(choices) >= MAX_AUTOCOMPLETE_CHOICES {
+			break
+		}
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+}
+
+//memberCanManageChannels returns true if the user who triggered the interaction
+//is allowed to change how threads are organized.
+func memberCanManageChannels(event *discordgo.InteractionCreate) bool {
+	if event.Member == nil {
+		return false
+	}
+	return event.Member.Permissions&(discordgo.PermissionManageChannels|discordgo.PermissionAdministrator) != 0
+}
+
+func (b *bot) threadGroupAutocomplete(s *discordgo.Session, event *discordgo.InteractionCreate, optionName string) {
+	var partial string
+	if option := interactionOption(event, optionName); option != nil {
+		partial = strings.ToLower(option.StringValue())
+	}
+
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, group := range b.sortedThreadGroups(event.GuildID) {
+		name := b.displayNameForThreadGroup(group)
+		if !strings.Contains(strings.ToLower(name), partial) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  name,
+			Value: group.threadCategoryID,
+		})
+		if len(choices) >= MAX_AUTOCOMPLETE_CHOICES {
+			break
+		}
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+}
+
+func (b *bot) threadGroupConfigInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	message := "Couldn't configure thread group: "
+	var value string
+	if option := interactionOption(event, GROUP_OPTION_NAME); option != nil {
+		value = option.StringValue()
+	}
+	group := b.findThreadGroup(event.GuildID, value)
+	maxThreadsOption := interactionOption(event, MAX_THREADS_OPTION_NAME)
+	idleDaysOption := interactionOption(event, IDLE_DAYS_OPTION_NAME)
+	maxArchivedOption := interactionOption(event, MAX_ARCHIVED_OPTION_NAME)
+	defaultOption := interactionOption(event, DEFAULT_GROUP_OPTION_NAME)
+	acceptForksFromOption := interactionOption(event, ACCEPT_FORKS_FROM_OPTION_NAME)
+	stopForksFromOption := interactionOption(event, STOP_FORKS_FROM_OPTION_NAME)
+	switch {
+	case group == nil:
+		message += "Couldn't find a thread group called " + value
+	case maxThreadsOption == nil && idleDaysOption == nil && maxArchivedOption == nil && defaultOption == nil && acceptForksFromOption == nil && stopForksFromOption == nil:
+		//Nothing to change, just report the current settings
+		message = b.describeThreadGroup(group)
+	case !memberCanManageChannels(event):
+		message += "You need the Manage Channels permission to change thread group settings"
+	default:
+		if err := b.configureThreadGroup(group, maxThreadsOption, idleDaysOption, maxArchivedOption, defaultOption, acceptForksFromOption, stopForksFromOption); err != nil {
+			message += err.Error()
+			fmt.Println(message)
+		} else {
+			message = b.describeThreadGroup(b.findThreadGroup(event.GuildID, group.threadCategoryID))
+		}
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+		},
+	})
+}
+
+//configureThreadGroup changes and persists whichever settings were provided
+//for the group and then archives anything that no longer fits.
+func (b *bot) configureThreadGroup(group *threadGroupInfo, maxThreadsOption, idleDaysOption, maxArchivedOption, defaultOption, acceptForksFromOption, stopForksFromOption *discordgo.ApplicationCommandInteractionDataOption) error {
+	category, err := b.session.State.Channel(group.threadCategoryID)
+	if err != nil {
+		return fmt.Errorf("couldn't find category: %w", err)
+	}
+	settings := b.getGuildSettings(category.GuildID)
+	if maxThreadsOption != nil {
+		if err := settings.SetMaxActiveThreadsForGroup(group.name, int(maxThreadsOption.IntValue())); err != nil {
+			return err
+		}
+	}
+	if idleDaysOption != nil {
+		if err := settings.SetIdleArchiveDaysForGroup(group.name, int(idleDaysOption.IntValue())); err != nil {
+			return err
+		}
+	}
+	if maxArchivedOption != nil {
+		if err := settings.SetMaxArchivedThreadsForGroup(group.name, int(maxArchivedOption.IntValue())); err != nil {
+			return err
+		}
+	}
+	if defaultOption != nil {
+		if defaultOption.BoolValue() {
+			settings.SetDefaultThreadGroup(group.name)
+		} else if settings.DefaultThreadGroup() == group.name {
+			settings.SetDefaultThreadGroup("")
+		}
+	}
+	if acceptForksFromOption != nil {
+		sourceGuildID := strings.TrimSpace(acceptForksFromOption.StringValue())
+		if sourceGuildID == category.GuildID {
+			return fmt.Errorf("messages in this server can already be forked into any of its thread groups")
+		}
+		if _, err := b.session.State.Guild(sourceGuildID); err != nil {
+			return fmt.Errorf("the bot isn't in a server with the ID %v", sourceGuildID)
+		}
+		settings.SetForkInboxGroup(sourceGuildID, group.name)
+	}
+	if stopForksFromOption != nil {
+		settings.RemoveForkInboxGroup(strings.TrimSpace(stopForksFromOption.StringValue()))
+	}
+	b.setGuildNeedsInfoRegeneration(category.GuildID)
+	group = b.getInfos(category.GuildID)[group.threadCategoryID]
+	if group == nil {
+		return fmt.Errorf("thread group disappeared unexpectedly")
+	}
+	if err := group.archiveThreadsIfNecessary(b.controller, b.session); err != nil {
+		return fmt.Errorf("couldn't archive threads with new limit: %w", err)
+	}
+	if err := group.archiveIdleThreads(b.controller, b.session); err != nil {
+		return fmt.Errorf("couldn't archive idle threads: %w", err)
+	}
+	if _, err := group.pruneArchives(b.controller, b.session, b.exporter); err != nil {
+		return fmt.Errorf("couldn't prune archived threads: %w", err)
+	}
+	return nil
+}
+
+func (b *bot) describeThreadGroup(group *threadGroupInfo) string {
+	if group == nil {
+		return "Unknown thread group"
+	}
+	result := "**" + b.displayNameForThreadGroup(group) + "**"
+	category, err := b.session.State.Channel(group.threadCategoryID)
+	if err == nil && b.defaultThreadGroup(category.GuildID) == group {
+		result += " (default)"
+	}
+	result += ": up to " + strconv.Itoa(group.maxActiveThreads) + " active threads"
+	if group.idleArchiveDuration > 0 {
+		result += ", archived after " + strconv.Itoa(int(group.idleArchiveDuration/(time.Hour*24))) + " idle days"
+	}
+	if group.maxArchivedThreads > 0 {
+		result += ", keeping " + strconv.Itoa(group.maxArchivedThreads) + " archived threads before exporting and deleting the oldest"
+	}
+	if err == nil {
+		var sources []string
+		for _, sourceGuildID := range b.getGuildSettings(category.GuildID).ForkInboxSourceGuildIDs(group.name) {
+			name := sourceGuildID
+			if guild, err := b.session.State.Guild(sourceGuildID); err == nil {
+				name = guild.Name
+			}
+			sources = append(sources, name)
+		}
+		if len(sources) > 0 {
+			result += ", and messages can be forked into it from " + strings.Join(sources, ", ")
+		}
+	}
+	return result
+}
+
+func (b *bot) forkConfigInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	settings := b.getGuildSettings(event.GuildID)
+	message := "Couldn't configure forks: "
+	switch {
+	case len(event.ApplicationCommandData().Options) == 0:
+		//Nothing to change, just report the current settings
+		message = describeForkSettings(settings, event.ChannelID)
+	case !memberCanManageChannels(event):
+		message += "You need the Manage Channels permission to change fork settings"
+	default:
+		if err := configureForks(settings, event); err != nil {
+			message += err.Error()
+			fmt.Println(message)
+		} else {
+			message = describeForkSettings(settings, event.ChannelID)
+		}
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+		},
+	})
+}
+
+//configureForks changes and persists whichever settings were provided in
+//event.
+func configureForks(settings *GuildSettings, event *discordgo.InteractionCreate) error {
+	if webhookOption := interactionOption(event, WEBHOOK_OPTION_NAME); webhookOption != nil {
+		settings.SetWebhookForks(webhookOption.BoolValue())
+	}
+	if onDeleteOption := interactionOption(event, ON_DELETE_OPTION_NAME); onDeleteOption != nil {
+		if err := settings.SetDeletedSourceForks(onDeleteOption.StringValue()); err != nil {
+			return err
+		}
+	}
+	if replyDepthOption := interactionOption(event, REPLY_DEPTH_OPTION_NAME); replyDepthOption != nil {
+		if err := settings.SetReplyContextDepth(int(replyDepthOption.IntValue())); err != nil {
+			return err
+		}
+	}
+	if undoMinutesOption := interactionOption(event, UNDO_MINUTES_OPTION_NAME); undoMinutesOption != nil {
+		if err := settings.SetForkUndoMinutes(int(undoMinutesOption.IntValue())); err != nil {
+			return err
+		}
+	}
+	if previewOption := interactionOption(event, PREVIEW_OPTION_NAME); previewOption != nil {
+		settings.SetForkPreview(previewOption.BoolValue())
+	}
+	if quorumOption := interactionOption(event, QUORUM_OPTION_NAME); quorumOption != nil {
+		if err := settings.SetForkQuorum(int(quorumOption.IntValue())); err != nil {
+			return err
+		}
+	}
+	if allowRoleOption := interactionOption(event, ALLOW_ROLE_OPTION_NAME); allowRoleOption != nil {
+		settings.SetForkRoleAllowed(allowRoleOption.RoleValue(nil, "").ID, true)
+	}
+	if disallowRoleOption := interactionOption(event, DISALLOW_ROLE_OPTION_NAME); disallowRoleOption != nil {
+		settings.SetForkRoleAllowed(disallowRoleOption.RoleValue(nil, "").ID, false)
+	}
+	if forkingHereOption := interactionOption(event, FORKING_HERE_OPTION_NAME); forkingHereOption != nil {
+		settings.SetForkingDisabledInChannel(event.ChannelID, !forkingHereOption.BoolValue())
+	}
+	if cooldownOption := interactionOption(event, COOLDOWN_MINUTES_OPTION_NAME); cooldownOption != nil {
+		if err := settings.SetForkCooldownMinutes(int(cooldownOption.IntValue())); err != nil {
+			return err
+		}
+	}
+	if forksToServerOption := interactionOption(event, FORKS_TO_SERVER_OPTION_NAME); forksToServerOption != nil {
+		guildID := strings.TrimSpace(forksToServerOption.StringValue())
+		switch {
+		case strings.EqualFold(guildID, FORKS_TO_NO_SERVER):
+			settings.SetForkDestinationGuild("")
+		case guildID == event.GuildID:
+			return fmt.Errorf("messages in this server can already be forked into any of its thread groups")
+		case !snowflakeRegExp.MatchString(guildID):
+			return fmt.Errorf("%v isn't a server ID", guildID)
+		default:
+			settings.SetForkDestinationGuild(guildID)
+		}
+	}
+	return nil
+}
+
+//describeForkSettings describes the guild's fork settings, including whether
+//forking is allowed in channelID.
+func describeForkSettings(settings *GuildSettings, channelID string) string {
+	var result string
+	if settings.WebhookForks() {
+		result = "Forks are posted with the original author's name and avatar"
+	} else {
+		result = "Forks are posted by the bot as an embed"
+	}
+	if settings.DeletedSourceForks() == DELETED_SOURCE_FORK_DELETE {
+		result += ", and are deleted when the original message is"
+	} else {
+		result += ", and say " + FORK_TOMBSTONE_TEXT + " when the original message is deleted"
+	}
+	if depth := settings.ReplyContextDepth(); depth > 0 {
+		result += ". Forks include up to " + strconv.Itoa(depth) + " earlier messages that forked replies replied to"
+	}
+	result += ". Forks can be undone for " + strconv.Itoa(int(settings.ForkUndoWindow()/time.Minute)) + " minutes"
+	if settings.ForkPreview() {
+		result += ". Forks into a new thread are previewed and have to be confirmed"
+	}
+	if guildID := settings.ForkDestinationGuild(); guildID != "" {
+		result += ". Messages can be forked to the server " + guildID + ", if it accepts them"
+	}
+	if settings.ForkingDisabledInChannel(channelID) {
+		result += ". Messages in this channel can't be forked with " + FORK_THREAD_EMOJI
+		return result
+	}
+	noun := "people"
+	if settings.ForkQuorum() == 1 {
+		noun = "person"
+	}
+	result += ". Messages are forked once " + strconv.Itoa(settings.ForkQuorum()) + " " + noun
+	if roleIDs := settings.ForkRoleIDs(); len(roleIDs) > 0 {
+		var roles []string
+		for _, roleID := range roleIDs {
+			roles = append(roles, "<@&"+roleID+">")
+		}
+		result += " with " + strings.Join(roles, " or ")
+	}
+	result += " react with " + FORK_THREAD_EMOJI
+	if cooldown := settings.ForkCooldown(); cooldown > 0 {
+		result += ", and each person can only do that once every " + strconv.Itoa(int(cooldown/time.Minute)) + " minutes"
+	}
+	return result
+}
+
+func (b *bot) compactArchivesInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+
+	if !memberCanManageChannels(event) {
+		s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "You need the Manage Channels permission to compact archives",
+			},
+		})
+		return
+	}
+
+	//Moving lots of channels will take longer than the 3 seconds we have to
+	//respond, so do a deferred channel message.
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	var value string
+	if option := interactionOption(event, GROUP_OPTION_NAME); option != nil {
+		value = option.StringValue()
+	}
+
+	message, err := b.compactArchives(event.GuildID, value)
+	if err != nil {
+		message = "*Error* Couldn't compact archives: " + err.Error()
+		fmt.Println(message)
+	}
+
+	s.InteractionResponseEdit(s.State.User.ID, event.Interaction, &discordgo.WebhookEdit{
+		Content: message,
+	})
+}
+
+//compactArchives compacts the archives of the named group, or every group if
+//groupIDOrName is "", returning a summary of what it did.
+func (b *bot) compactArchives(guildID string, groupIDOrName string) (string, error) {
+	groups := b.sortedThreadGroups(guildID)
+	if groupIDOrName != "" {
+		group := b.findThreadGroup(guildID, groupIDOrName)
+		if group == nil {
+			return "", fmt.Errorf("couldn't find a thread group called %v", groupIDOrName)
+		}
+		groups = []*threadGroupInfo{group}
+	}
+
+	var lines []string
+	for _, group := range groups {
+		result, err := group.compactArchives(b.controller, b.session)
+		if err != nil {
+			return "", fmt.Errorf("couldn't compact %v: %w", b.displayNameForThreadGroup(group), err)
+		}
+		lines = append(lines, "**"+b.displayNameForThreadGroup(group)+"**: "+result.String())
+	}
+	b.setGuildNeedsInfoRegeneration(guildID)
+
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		return "", fmt.Errorf("couldn't fetch guild: %w", err)
+	}
+	if warning := guildChannelLimitWarning(guild); warning != "" {
+		lines = append(lines, warning)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (b *bot) exportArchivesInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+
+	if !memberCanManageChannels(event) {
+		s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "You need the Manage Channels permission to export archives",
+			},
+		})
+		return
+	}
+
+	//Fetching every message will take longer than the 3 seconds we have to
+	//respond, so do a deferred channel message.
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+
+	var value string
+	if option := interactionOption(event, GROUP_OPTION_NAME); option != nil {
+		value = option.StringValue()
+	}
+
+	message, err := b.exportArchives(event.GuildID, value)
+	if err != nil {
+		message = "*Error* Couldn't export archives: " + err.Error()
+		fmt.Println(message)
+	}
+
+	s.InteractionResponseEdit(s.State.User.ID, event.Interaction, &discordgo.WebhookEdit{
+		Content: message,
+	})
+}
+
+//exportArchives writes transcripts of the archived threads in the named group,
+//or every group if groupIDOrName is "", returning a summary of what it did.
+func (b *bot) exportArchives(guildID string, groupIDOrName string) (string, error) {
+	groups := b.sortedThreadGroups(guildID)
+	if groupIDOrName != "" {
+		group := b.findThreadGroup(guildID, groupIDOrName)
+		if group == nil {
+			return "", fmt.Errorf("couldn't find a thread group called %v", groupIDOrName)
+		}
+		groups = []*threadGroupInfo{group}
+	}
+
+	var lines []string
+	for _, group := range groups {
+		count, err := group.exportArchives(b.controller, b.session, b.exporter)
+		if err != nil {
+			return "", fmt.Errorf("couldn't export %v: %w", b.displayNameForThreadGroup(group), err)
+		}
+		lines = append(lines, "**"+b.displayNameForThreadGroup(group)+"**: exported "+strconv.Itoa(count)+" archived threads")
+	}
+	lines = append(lines, "Transcripts are in "+filepath.Join(b.exporter.dir, guildID))
+
+	return strings.Join(lines, "\n"), nil
+}
+
 func (b *bot) setGuildNeedsInfoRegeneration(guildID string) {
 	b.infoMutex.Lock()
 	delete(b.infos, guildID)
@@ -692,31 +2146,194 @@ func (b *bot) getInfos(guildID string) categoryMap {
 	if currentInfos == nil {
 		b.rebuildCategoryMap(guildID, false)
 	}
-	b.infoMutex.RLock()
-	currentInfos = b.infos[guildID]
-	b.infoMutex.RUnlock()
-	return currentInfos
+	b.infoMutex.RLock()
+	currentInfos = b.infos[guildID]
+	b.infoMutex.RUnlock()
+	return currentInfos
+}
+
+//returns nil if not a thraed
+func (b *bot) getThreadGroupInfoForThread(channel *discordgo.Channel) *threadGroupInfo {
+	guildInfos := b.getInfos(channel.GuildID)
+	if guildInfos == nil {
+		//Must be a message from a server without a Threads category
+		return nil
+	}
+	for _, group := range guildInfos {
+		if channel.ParentID == group.threadCategoryID {
+			//A message outside of Threads category
+			return group
+		}
+	}
+	//Didn't match any of the infos
+	return nil
+}
+
+func (b *bot) isThread(channel *discordgo.Channel) bool {
+	return b.getThreadGroupInfoForThread(channel) != nil
+}
+
+//sortedThreadGroups returns the guild's thread groups sorted by name, so that
+//the result is stable unlike iterating over the categoryMap.
+func (b *bot) sortedThreadGroups(guildID string) []*threadGroupInfo {
+	var result []*threadGroupInfo
+	for _, group := range b.getInfos(guildID) {
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+//findThreadGroup returns the group with the given threadCategoryID, or failing
+//that the given display name or group name, or nil if there is none.
+func (b *bot) findThreadGroup(guildID string, idOrName string) *threadGroupInfo {
+	groups := b.sortedThreadGroups(guildID)
+	for _, group := range groups {
+		if group.threadCategoryID == idOrName {
+			return group
+		}
+	}
+	for _, group := range groups {
+		if strings.EqualFold(b.displayNameForThreadGroup(group), idOrName) || strings.EqualFold(group.name, idOrName) {
+			return group
+		}
+	}
+	return nil
+}
+
+//displayNameForThreadGroup returns the name of the group's category, e.g.
+//"Design Threads".
+func (b *bot) displayNameForThreadGroup(group *threadGroupInfo) string {
+	category, err := b.session.State.Channel(group.threadCategoryID)
+	if err != nil {
+		if group.name == "" {
+			return THREAD_CATEGORY_NAME
+		}
+		return group.name + " " + THREAD_CATEGORY_NAME
+	}
+	return category.Name
+}
+
+//activeThreads returns every thread that isn't archived, group by group, in the
+//order Discord shows them.
+func (b *bot) activeThreads(guildID string) []*discordgo.Channel {
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		return nil
+	}
+	var result []*discordgo.Channel
+	for _, group := range b.sortedThreadGroups(guildID) {
+		var threads byDiscordOrder
+		for _, channel := range guild.Channels {
+			if channel.ParentID == group.threadCategoryID {
+				threads = append(threads, channel)
+			}
+		}
+		sort.Sort(threads)
+		result = append(result, threads...)
+	}
+	return result
 }
 
-//returns nil if not a thraed
-func (b *bot) getThreadGroupInfoForThread(channel *discordgo.Channel) *threadGroupInfo {
+//returns nil if not an archived thread
+func (b *bot) getThreadGroupInfoForArchivedThread(channel *discordgo.Channel) *threadGroupInfo {
 	guildInfos := b.getInfos(channel.GuildID)
 	if guildInfos == nil {
-		//Must be a message from a server without a Threads category
 		return nil
 	}
 	for _, group := range guildInfos {
-		if channel.ParentID == group.threadCategoryID {
-			//A message outside of Threads category
-			return group
+		for _, archiveCategoryID := range group.archiveCategoryIDs {
+			if channel.ParentID == archiveCategoryID {
+				return group
+			}
 		}
 	}
-	//Didn't match any of the infos
 	return nil
 }
 
-func (b *bot) isThread(channel *discordgo.Channel) bool {
-	return b.getThreadGroupInfoForThread(channel) != nil
+//archivedThreads returns every thread in any of the guild's archive
+//categories, sorted by name.
+func (b *bot) archivedThreads(guildID string) []*discordgo.Channel {
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		fmt.Printf("couldn't fetch guild %v: %v\n", guildID, err)
+		return nil
+	}
+	var result []*discordgo.Channel
+	for _, channel := range guild.Channels {
+		if channel.Type != discordgo.ChannelTypeGuildText {
+			continue
+		}
+		if b.getThreadGroupInfoForArchivedThread(channel) == nil {
+			continue
+		}
+		result = append(result, channel)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+//findArchivedThread returns the archived thread with the given ID, or failing
+//that the given name, or nil if there is none.
+func (b *bot) findArchivedThread(guildID string, idOrName string) *discordgo.Channel {
+	threads := b.archivedThreads(guildID)
+	for _, thread := range threads {
+		if thread.ID == idOrName {
+			return thread
+		}
+	}
+	for _, thread := range threads {
+		if thread.Name == idOrName {
+			return thread
+		}
+	}
+	return nil
+}
+
+//moveThread moves an active thread into another thread group, puts it at the
+//top, and archives threads in the destination that no longer fit.
+func (b *bot) moveThread(thread *discordgo.Channel, destination *threadGroupInfo) error {
+	source := b.getThreadGroupInfoForThread(thread)
+	if source == nil {
+		return fmt.Errorf("%v is not an active thread", nameForThread(thread))
+	}
+	if source.threadCategoryID == destination.threadCategoryID {
+		return fmt.Errorf("%v is already in %v", nameForThread(thread), b.displayNameForThreadGroup(destination))
+	}
+	fmt.Println("Moving thread " + nameForThread(thread) + " to " + b.displayNameForThreadGroup(destination))
+	if err := destination.moveThreadIntoGroup(b.controller, b.session, thread); err != nil {
+		return fmt.Errorf("couldn't move thread: %w", err)
+	}
+	if err := b.moveThreadToTopOfCategory(thread); err != nil {
+		return fmt.Errorf("couldn't move thread to top: %w", err)
+	}
+	if err := destination.archiveThreadsIfNecessary(b.controller, b.session); err != nil {
+		return fmt.Errorf("couldn't archive threads after moving: %w", err)
+	}
+	return nil
+}
+
+//unarchiveThread moves an archived thread back into the active category of its
+//group, pops it to the top, and then archives whatever no longer fits.
+func (b *bot) unarchiveThread(thread *discordgo.Channel) error {
+	gi := b.getThreadGroupInfoForArchivedThread(thread)
+	if gi == nil {
+		return fmt.Errorf("%v is not an archived thread", nameForThread(thread))
+	}
+	if err := gi.unarchiveThread(b.controller, b.session, thread); err != nil {
+		return fmt.Errorf("couldn't move thread out of archive: %w", err)
+	}
+	if err := b.moveThreadToTopOfCategory(thread); err != nil {
+		return fmt.Errorf("couldn't move unarchived thread to top: %w", err)
+	}
+	if err := gi.archiveThreadsIfNecessary(b.controller, b.session); err != nil {
+		return fmt.Errorf("couldn't archive threads after unarchiving: %w", err)
+	}
+	return nil
 }
 
 type categoryStruct struct {
@@ -724,7 +2341,7 @@ type categoryStruct struct {
 	archiveCategories byArchiveIndex
 }
 
-func createCategoryMap(guild *discordgo.Guild, alert bool) (infos categoryMap) {
+func createCategoryMap(guild *discordgo.Guild, settings *GuildSettings, pendingMoves *pendingChannelMoves, alert bool) (infos categoryMap) {
 	categories := make(map[string]*categoryStruct)
 
 	for _, channel := range guild.Channels {
@@ -786,6 +2403,12 @@ func createCategoryMap(guild *discordgo.Guild, alert bool) (infos categoryMap) {
 		info := &threadGroupInfo{
 			name:                     name,
 			threadCategoryID:         category.threadGroup.ID,
+			maxActiveThreads:         settings.MaxActiveThreadsForGroup(name),
+			idleArchiveDuration:      settings.IdleArchiveDurationForGroup(name),
+			maxArchivedThreads:       settings.MaxArchivedThreadsForGroup(name),
+			pinnedThreadIDs:          settings.PinnedThreadIDs(),
+			settings:                 settings,
+			pendingMoves:             pendingMoves,
 			activeArchiveCategoryID:  activeArchiveCategoryID,
 			archiveCategoryIDs:       archiveIDs,
 			nextArchiveCategoryIndex: nextArchiveCategoryIndex,
@@ -814,35 +2437,129 @@ func (b *bot) rebuildCategoryMap(guildID string, alert bool) {
 		return
 	}
 
-	infos := createCategoryMap(guild, alert)
+	infos := createCategoryMap(guild, b.getGuildSettings(guildID), b.getPendingMoves(guildID), alert)
 
 	b.infoMutex.Lock()
 	b.infos[guild.ID] = infos
 	b.infoMutex.Unlock()
 }
 
-func (b *bot) createNewThreadInDefaultCategory(guildID string, threadName string) (*discordgo.Channel, error) {
-	infos := b.getInfos(guildID)
-	var categoryID string
-	//find at least one categoryID, defaulting to the one that is default ""
-	for id, info := range infos {
-		categoryID = id
-		if info.name == "" {
-			break
+//defaultThreadGroup returns the group new threads go in when none is given:
+//the one the guild configured, else the "" group, else the first group by name.
+func (b *bot) defaultThreadGroup(guildID string) *threadGroupInfo {
+	groups := b.sortedThreadGroups(guildID)
+	if len(groups) == 0 {
+		return nil
+	}
+	defaultName := b.getGuildSettings(guildID).DefaultThreadGroup()
+	for _, group := range groups {
+		if group.name == defaultName {
+			return group
 		}
 	}
+	//sortedThreadGroups puts the "" group first if there is one
+	return groups[0]
+}
 
-	if categoryID == "" {
+func (b *bot) createNewThreadInDefaultCategory(guildID string, threadName string) (*discordgo.Channel, error) {
+	group := b.defaultThreadGroup(guildID)
+	if group == nil {
 		return nil, fmt.Errorf("thread is no threads category to create a thread in")
 	}
+	return b.createNewThread(guildID, group, threadName)
+}
 
+func (b *bot) createNewThread(guildID string, group *threadGroupInfo, threadName string) (*discordgo.Channel, error) {
 	return b.controller.GuildChannelCreateComplex(guildID, discordgo.GuildChannelCreateData{
 		Name:     threadName,
 		Type:     discordgo.ChannelTypeGuildText,
-		ParentID: categoryID,
+		ParentID: group.threadCategoryID,
+	})
+}
+
+//sanitizeChannelName turns a title into something Discord will accept as a
+//text channel name: lowercase, dashes instead of spaces and punctuation, and
+//not too long.
+func sanitizeChannelName(title string) string {
+	var builder strings.Builder
+	lastWasDash := true
+	length := 0
+	for _, r := range strings.ToLower(title) {
+		if length >= MAX_CHANNEL_NAME_LENGTH {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_' {
+			builder.WriteRune(r)
+			lastWasDash = false
+			length++
+			continue
+		}
+		if lastWasDash {
+			continue
+		}
+		builder.WriteRune('-')
+		lastWasDash = true
+		length++
+	}
+	return strings.TrimRight(builder.String(), "-")
+}
+
+func (b *bot) newThreadInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	var title string
+	if option := interactionOption(event, TITLE_OPTION_NAME); option != nil {
+		title = option.StringValue()
+	}
+	var groupValue string
+	if option := interactionOption(event, GROUP_OPTION_NAME); option != nil {
+		groupValue = option.StringValue()
+	}
+	var userID string
+	if event.Member != nil && event.Member.User != nil {
+		userID = event.Member.User.ID
+	}
+
+	message := "Couldn't create thread: "
+	thread, err := b.newThread(event.GuildID, groupValue, title, userID)
+	if err != nil {
+		message += err.Error()
+		fmt.Println(message)
+	} else {
+		message = "Created <#" + thread.ID + ">"
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+		},
 	})
 }
 
+//newThread creates a thread with the given title in the named group, or the
+//guild's default group if groupIDOrName is "", and posts an opening message
+//crediting userID.
+func (b *bot) newThread(guildID, groupIDOrName, title, userID string) (*discordgo.Channel, error) {
+	group := b.defaultThreadGroup(guildID)
+	if groupIDOrName != "" {
+		group = b.findThreadGroup(guildID, groupIDOrName)
+	}
+	if group == nil {
+		return nil, fmt.Errorf("couldn't find a thread group called %v", groupIDOrName)
+	}
+	name := sanitizeChannelName(title)
+	if name == "" {
+		return nil, fmt.Errorf("%v doesn't have any characters that can be used in a channel name", title)
+	}
+	thread, err := b.createNewThread(guildID, group, name)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create channel: %w", err)
+	}
+	if _, err := b.session.ChannelMessageSend(thread.ID, "Thread started by <@"+userID+"> with /"+NEW_THREAD_COMMAND_NAME+": "+title); err != nil {
+		return thread, fmt.Errorf("created the thread but couldn't post the opening message: %w", err)
+	}
+	return thread, nil
+}
+
 func numThreadsInCategory(guild *discordgo.Guild, category *discordgo.Channel) int {
 	threads := threadsInCategory(guild, category)
 	if threads == nil {
@@ -863,42 +2580,67 @@ func threadsInCategory(guild *discordgo.Guild, category *discordgo.Channel) []*d
 }
 
 // Moves this thread to position 0, sliding everything else down, but maintaining
-// their order.
+// their order. Pinned threads keep their positions and everything else flows
+// around them.
 func (b *bot) moveThreadToTopOfCategory(thread *discordgo.Channel) error {
 
+	settings := b.getGuildSettings(thread.GuildID)
+
+	if settings.ThreadIsPinned(thread.ID) {
+		// Pinned threads stay where they are
+		return nil
+	}
+
 	guild, err := b.session.State.Guild(thread.GuildID)
 	if err != nil {
 		return fmt.Errorf("couldn't fetch guild: %w", err)
 	}
 	var threads byDiscordOrder
-	// the thread we want to move to the head, but refreshed
-	var headThread *discordgo.Channel
 	for _, channel := range guild.Channels {
 		if channel.ParentID == thread.ParentID {
-			if channel.ID == thread.ID {
-				headThread = channel
-			} else {
-				threads = append(threads, channel)
-			}
+			threads = append(threads, channel)
 		}
 	}
 
 	// The order we come across them in has nothing to do with their actual order...
 	sort.Sort(threads)
 
+	// the thread we want to move to the head, but refreshed
+	var headThread *discordgo.Channel
+	// Pull the pinned threads out, remembering which slots they had. The
+	// slots count the head thread too, so that pinned threads below it stay
+	// put when it moves up.
+	pinnedThreadsBySlot := make(map[int]*discordgo.Channel)
+	var movableThreads []*discordgo.Channel
+	for i, channel := range threads {
+		switch {
+		case channel.ID == thread.ID:
+			headThread = channel
+		case settings.ThreadIsPinned(channel.ID):
+			pinnedThreadsBySlot[i] = channel
+		default:
+			movableThreads = append(movableThreads, channel)
+		}
+	}
+
 	if headThread == nil {
 		return fmt.Errorf("didn't find the target thread unexpectedly")
 	}
 
-	threads = append([]*discordgo.Channel{headThread}, threads...)
+	movableThreads = append([]*discordgo.Channel{headThread}, movableThreads...)
 
-	for i, channel := range threads {
-		channel.Position = i
+	threads = nil
+	for i := 0; len(movableThreads) > 0 || len(pinnedThreadsBySlot) > 0; i++ {
+		if pinned, ok := pinnedThreadsBySlot[i]; ok {
+			threads = append(threads, pinned)
+			delete(pinnedThreadsBySlot, i)
+			continue
+		}
+		threads = append(threads, movableThreads[0])
+		movableThreads = movableThreads[1:]
 	}
 
-	if err := b.controller.GuildChannelsReorder(guild.ID, threads); err != nil {
-		return fmt.Errorf("couldn't reorder channels: %w", err)
-	}
+	b.reorderer.requestOrder(guild.ID, thread.ParentID, threads)
 
 	return nil
 }
@@ -954,14 +2696,21 @@ func (g *threadGroupInfo) archiveThreadsIfNecessary(controller Controller, sessi
 		return fmt.Errorf("archiveThreadsIfNecessary couldn't find guild: %w", err)
 	}
 
-	threads := threadsInCategory(guild, category)
+	var threads []*discordgo.Channel
+	for _, thread := range threadsInCategory(guild, category) {
+		// Pinned threads don't count against the limit
+		if g.pinnedThreadIDs[thread.ID] {
+			continue
+		}
+		threads = append(threads, thread)
+	}
 
-	if len(threads) <= maxActiveThreads {
+	if len(threads) <= g.maxActiveThreads {
 		// Not necessary to remove any
 		return nil
 	}
 
-	extraCount := len(threads) - maxActiveThreads
+	extraCount := len(threads) - g.maxActiveThreads
 
 	for i := 0; i < extraCount; i++ {
 		thread := threads[len(threads)-1-i]
@@ -973,60 +2722,173 @@ func (g *threadGroupInfo) archiveThreadsIfNecessary(controller Controller, sessi
 	return nil
 }
 
-func (g *threadGroupInfo) archiveThread(controller Controller, session *discordgo.Session, thread *discordgo.Channel) error {
-	fmt.Println("Archiving thread " + nameForThread(thread) + " to because it no longer fits")
-	var activeArchiveCategoryID = g.activeArchiveCategoryID
-	if activeArchiveCategoryID == "" {
-		// Need to create an archive category to put into
+func (g *threadGroupInfo) archiveIdleThreads(controller Controller, session *discordgo.Session) error {
+	if g.idleArchiveDuration == 0 {
+		return nil
+	}
+	category, err := session.State.Channel(g.threadCategoryID)
+	if err != nil {
+		return fmt.Errorf("archiveIdleThreads couldn't find category: %w", err)
+	}
+	guild, err := session.State.Guild(category.GuildID)
+	if err != nil {
+		return fmt.Errorf("archiveIdleThreads couldn't find guild: %w", err)
+	}
 
-		guild, err := session.State.Guild(thread.GuildID)
-		if err != nil {
-			return fmt.Errorf("couldn't get guild: %w", err)
-		}
+	cutoff := controller.Now().Add(-g.idleArchiveDuration)
 
-		mainCategory, err := session.State.Channel(g.threadCategoryID)
+	for _, thread := range threadsInCategory(guild, category) {
+		if g.pinnedThreadIDs[thread.ID] {
+			continue
+		}
+		lastActivity, err := g.lastActivity(thread)
 		if err != nil {
-			return fmt.Errorf("couldn't get main category: %w", err)
+			fmt.Printf("Couldn't tell when %v was last active: %v\n", nameForThread(thread), err)
+			continue
 		}
-
-		var everyoneRoleID string
-		for _, role := range guild.Roles {
-			if strings.Contains(role.Name, EVERYONE_ROLE_NAME) {
-				everyoneRoleID = role.ID
-			}
+		if !lastActivity.Before(cutoff) {
+			continue
 		}
-
-		if everyoneRoleID == "" {
-			return fmt.Errorf("couldn't find role @everyone")
+		if err := g.archiveThread(controller, session, thread); err != nil {
+			return fmt.Errorf("couldn't archive idle thread %v: %w", nameForThread(thread), err)
 		}
+	}
 
-		var name string
-		if g.name != "" {
-			name = g.name + " "
-		}
+	return nil
+}
 
-		name += THREAD_ARCHIVE_CATEGORY_NAME + " " + strconv.Itoa(g.nextArchiveCategoryIndex)
+//lastActivityForThread returns when the last message was posted in the thread,
+//or when it was created if it has no messages.
+func lastActivityForThread(thread *discordgo.Channel) (time.Time, error) {
+	if thread.LastMessageID != "" {
+		return discordgo.SnowflakeTimestamp(thread.LastMessageID)
+	}
+	return discordgo.SnowflakeTimestamp(thread.ID)
+}
 
-		extendedPermissions := []*discordgo.PermissionOverwrite{
-			{
-				ID:   everyoneRoleID,
-				Type: discordgo.PermissionOverwriteTypeRole,
-				Deny: discordgo.PermissionSendMessages,
-			},
+//lastActivity is lastActivityForThread, but counts the thread being
+//unarchived or moved into the group as activity too, so that the idle sweep
+//doesn't archive it again straight away.
+func (g *threadGroupInfo) lastActivity(thread *discordgo.Channel) (time.Time, error) {
+	result, err := lastActivityForThread(thread)
+	if err != nil {
+		return result, err
+	}
+	if revived, ok := g.settings.ThreadReviveTime(thread.ID); ok && revived.After(result) {
+		return revived, nil
+	}
+	return result, nil
+}
+
+//noteChannelActivity records messageID as the channel's last message. State
+//doesn't update LastMessageID when messages are posted, and if the channel
+//doesn't need reordering there's no channelUpdate to do it either.
+func noteChannelActivity(state *discordgo.State, channel *discordgo.Channel, messageID string) {
+	state.Lock()
+	defer state.Unlock()
+	//Snowflakes of the same length sort like the numbers they are
+	if len(messageID) > len(channel.LastMessageID) || (len(messageID) == len(channel.LastMessageID) && messageID > channel.LastMessageID) {
+		channel.LastMessageID = messageID
+	}
+}
+
+//createArchiveCategory creates the next "Threads Archive N" category for the
+//group, with the main category's permissions but without letting people post.
+func (g *threadGroupInfo) createArchiveCategory(controller Controller, session *discordgo.Session, guildID string) (*discordgo.Channel, error) {
+	guild, err := session.State.Guild(guildID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get guild: %w", err)
+	}
+
+	if len(guild.Channels) >= MAX_GUILD_CHANNELS {
+		return nil, fmt.Errorf("guild already has %v channels, Discord's limit. Run /%v or delete old archived threads", len(guild.Channels), COMPACT_ARCHIVES_COMMAND_NAME)
+	}
+
+	mainCategory, err := session.State.Channel(g.threadCategoryID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get main category: %w", err)
+	}
+
+	var everyoneRoleID string
+	for _, role := range guild.Roles {
+		if strings.Contains(role.Name, EVERYONE_ROLE_NAME) {
+			everyoneRoleID = role.ID
 		}
-		// Copy over the main categorie's permissions
-		extendedPermissions = append(extendedPermissions, mainCategory.PermissionOverwrites...)
+	}
 
-		archiveCategory, err := controller.GuildChannelCreateComplex(thread.GuildID, discordgo.GuildChannelCreateData{
-			Name:                 name,
-			Type:                 discordgo.ChannelTypeGuildCategory,
-			PermissionOverwrites: extendedPermissions,
-		})
+	if everyoneRoleID == "" {
+		return nil, fmt.Errorf("couldn't find role @everyone")
+	}
 
-		if err != nil {
-			return fmt.Errorf("archiveThreadCreate failed: %w", err)
+	var name string
+	if g.name != "" {
+		name = g.name + " "
+	}
+
+	name += THREAD_ARCHIVE_CATEGORY_NAME + " " + strconv.Itoa(g.nextArchiveCategoryIndex)
+
+	extendedPermissions := []*discordgo.PermissionOverwrite{
+		{
+			ID:   everyoneRoleID,
+			Type: discordgo.PermissionOverwriteTypeRole,
+			Deny: discordgo.PermissionSendMessages,
+		},
+	}
+	// Copy over the main categorie's permissions
+	extendedPermissions = append(extendedPermissions, mainCategory.PermissionOverwrites...)
+
+	archiveCategory, err := controller.GuildChannelCreateComplex(guildID, discordgo.GuildChannelCreateData{
+		Name:                 name,
+		Type:                 discordgo.ChannelTypeGuildCategory,
+		PermissionOverwrites: extendedPermissions,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("archiveThreadCreate failed: %w", err)
+	}
+
+	// Remember it so that the rest of a batch goes into it too, instead of
+	// creating yet another category.
+	g.activeArchiveCategoryID = archiveCategory.ID
+	g.archiveCategoryIDs = append([]string{archiveCategory.ID}, g.archiveCategoryIDs...)
+	g.nextArchiveCategoryIndex++
+
+	if warning := guildChannelLimitWarning(guild); warning != "" {
+		fmt.Println(warning)
+	}
+
+	return archiveCategory, nil
+}
+
+//numThreadsInArchiveCategory counts the threads in the category, including
+//ones we've just moved there that State doesn't know about yet.
+func (g *threadGroupInfo) numThreadsInArchiveCategory(guild *discordgo.Guild, archiveCategoryID string) int {
+	result := 0
+	for _, channel := range guild.Channels {
+		if g.pendingMoves.parentID(channel) == archiveCategoryID {
+			result++
 		}
+	}
+	return result
+}
 
+func (g *threadGroupInfo) archiveThread(controller Controller, session *discordgo.Session, thread *discordgo.Channel) error {
+	fmt.Println("Archiving thread " + nameForThread(thread) + " to because it no longer fits")
+	guild, err := session.State.Guild(thread.GuildID)
+	if err != nil {
+		return fmt.Errorf("couldn't get guild: %w", err)
+	}
+	var activeArchiveCategoryID = g.activeArchiveCategoryID
+	if activeArchiveCategoryID != "" && g.numThreadsInArchiveCategory(guild, activeArchiveCategoryID) >= MAX_CATEGORY_CHANNELS {
+		// It filled up, e.g. earlier in this same batch
+		activeArchiveCategoryID = ""
+	}
+	if activeArchiveCategoryID == "" {
+		// Need to create an archive category to put into
+		archiveCategory, err := g.createArchiveCategory(controller, session, thread.GuildID)
+		if err != nil {
+			return err
+		}
 		activeArchiveCategoryID = archiveCategory.ID
 	}
 
@@ -1048,22 +2910,138 @@ func (g *threadGroupInfo) archiveThread(controller Controller, session *discordg
 		return fmt.Errorf("couldn't move categories: %w", err)
 	}
 
-	// TODO: we really should make sure the thread is at the top of the archive.
-	// But b.moveThreadToTopOfCategory won't work naively because at this point
-	// we haven't yet received the channelUpdate message (I think). Currently the
-	// behavior works OK if only one thread is being archived.
+	// Like unarchiveThread, update our copy so that other archiving done before
+	// the channelUpdate arrives doesn't try to archive this thread again.
+	thread.ParentID = activeArchiveCategoryID
+	g.settings.SetThreadArchiveTime(thread.ID, controller.Now())
+
+	// We can't put the thread at the top of the archive yet, because State
+	// won't reflect the move until the channelUpdate arrives, and other threads
+	// archived in the same batch are likely still in flight. bot.channelUpdate
+	// calls sortArchiveCategory once all of them have landed.
+	g.pendingMoves.noteMoved(thread.ID, activeArchiveCategoryID)
+
+	return nil
+}
+
+//archivedBefore returns true if left was archived before right. Threads that
+//were archived before we recorded archive times fall back on their last
+//activity.
+func (g *threadGroupInfo) archivedBefore(left, right *discordgo.Channel) bool {
+	leftTime, leftOK := g.settings.ThreadArchiveTime(left.ID)
+	rightTime, rightOK := g.settings.ThreadArchiveTime(right.ID)
+	leftActivity, _ := lastActivityForThread(left)
+	rightActivity, _ := lastActivityForThread(right)
+	if !leftOK {
+		leftTime = leftActivity
+	}
+	if !rightOK {
+		rightTime = rightActivity
+	}
+	if !leftTime.Equal(rightTime) {
+		return leftTime.Before(rightTime)
+	}
+	// Threads archived in the same batch all have the same time. They were
+	// archived least recently active first.
+	return leftActivity.Before(rightActivity)
+}
+
+//sortArchiveCategory orders the threads in the archive category so the most
+//recently archived is at the top. Because we always archive into the newest
+//archive category, this keeps the whole archive in order.
+func (g *threadGroupInfo) sortArchiveCategory(controller Controller, session *discordgo.Session, archiveCategoryID string) error {
+	archiveCategory, err := session.State.Channel(archiveCategoryID)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch archive category: %w", err)
+	}
+	guild, err := session.State.Guild(archiveCategory.GuildID)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch guild: %w", err)
+	}
+
+	var threads []*discordgo.Channel
+	for _, channel := range guild.Channels {
+		if g.pendingMoves.parentID(channel) == archiveCategoryID {
+			threads = append(threads, channel)
+		}
+	}
+
+	sort.SliceStable(threads, func(i, j int) bool {
+		return g.archivedBefore(threads[j], threads[i])
+	})
+
+	alreadySorted := true
+	for i, channel := range threads {
+		if channel.Position != i {
+			alreadySorted = false
+		}
+		channel.Position = i
+	}
+
+	if alreadySorted {
+		return nil
+	}
+
+	if err := controller.GuildChannelsReorder(guild.ID, threads); err != nil {
+		return fmt.Errorf("couldn't reorder archive: %w", err)
+	}
+	return nil
+}
+
+func (g *threadGroupInfo) unarchiveThread(controller Controller, session *discordgo.Session, thread *discordgo.Channel) error {
+	fmt.Println("Unarchiving thread " + nameForThread(thread))
+
+	if err := g.moveThreadIntoGroup(controller, session, thread); err != nil {
+		return err
+	}
+	g.settings.SetThreadArchiveTime(thread.ID, time.Time{})
+
+	return nil
+}
+
+//moveThreadIntoGroup moves the thread into the group's main category, syncing
+//its permissions with the category.
+func (g *threadGroupInfo) moveThreadIntoGroup(controller Controller, session *discordgo.Session, thread *discordgo.Channel) error {
+	mainCategory, err := session.State.Channel(g.threadCategoryID)
+	if err != nil {
+		return fmt.Errorf("couldn't get main category: %w", err)
+	}
+
+	_, err = controller.ChannelEditComplex(thread.ID, &discordgo.ChannelEdit{
+		Position: 0,
+		ParentID: g.threadCategoryID,
+		// Set the same permission overwrites so it will be synced
+		PermissionOverwrites: mainCategory.PermissionOverwrites,
+	})
+
+	if err != nil {
+		return fmt.Errorf("couldn't move categories: %w", err)
+	}
+
+	// We won't receive the channelUpdate for a bit, but callers will want to
+	// reorder the main category right away, so update our copy in place.
+	thread.ParentID = g.threadCategoryID
+	thread.PermissionOverwrites = mainCategory.PermissionOverwrites
+	g.pendingMoves.noteMoved(thread.ID, g.threadCategoryID)
+	g.settings.SetThreadReviveTime(thread.ID, controller.Now())
 
 	return nil
 }
 
 //Called before the program exits when the bot should clean up, persist state, etc.
 func (b *bot) Close() {
+	b.reorderer.flushAll()
 	for _, index := range b.indexes {
 		if err := index.Persist(); err != nil {
 			fmt.Printf("Couln't persist IDF %v: %v\n", index.guildID, err)
 			//Continue on and try to save the other ones too
 		}
 	}
+	for _, settings := range b.settings {
+		if err := settings.Persist(); err != nil {
+			fmt.Printf("Couldn't persist settings %v: %v\n", settings.guildID, err)
+		}
+	}
 }
 
 func indexForThreadArchive(channel *discordgo.Channel) int {
@@ -1080,6 +3058,15 @@ func indexForThreadArchive(channel *discordgo.Channel) int {
 	return result
 }
 
+//guildChannelLimitWarning returns a message if the guild is getting close to
+//Discord's limit on the number of channels, or "" if it's fine.
+func guildChannelLimitWarning(guild *discordgo.Guild) string {
+	if len(guild.Channels) < MAX_GUILD_CHANNELS-GUILD_CHANNEL_WARNING_MARGIN {
+		return ""
+	}
+	return "Warning: " + nameForGuild(guild) + " has " + strconv.Itoa(len(guild.Channels)) + " of a maximum of " + strconv.Itoa(MAX_GUILD_CHANNELS) + " channels. New threads and archives will fail once it's full; run /" + COMPACT_ARCHIVES_COMMAND_NAME + " or delete old archived threads."
+}
+
 func nameForGuild(guild *discordgo.Guild) string {
 	return guild.Name + " (" + guild.ID + ")"
 }
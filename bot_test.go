@@ -1,14 +1,18 @@
 package main
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
 const (
 	TEST_TOKEN    string = "fake-value"
-	TEST_GUILD_ID string = "guild-1"
+	TEST_GUILD_ID string = "100000000000000001"
 )
 
 type TestController struct {
@@ -16,6 +20,30 @@ type TestController struct {
 	channelEditComplexCallCount        int
 	guildChannelCreateComplexCallCount int
 	guildChannelsReorderCallCount      int
+	editedChannelIDs                   []string
+	lastReorderedChannels              []*discordgo.Channel
+	deletedChannelIDs                  []string
+	channelMessagesCallCount           int
+	channelMessageCallCount            int
+	//Most recent first
+	messages                []*discordgo.Message
+	now                     time.Time
+	webhooks                []*discordgo.Webhook
+	webhookCreateCallCount  int
+	executedWebhookParams   []*discordgo.WebhookParams
+	editedWebhookMessageIDs []string
+	lastWebhookEdit         *discordgo.WebhookEdit
+	//channelID+messageID -> embeds it was last edited to have
+	editedMessageEmbeds map[string][]*discordgo.MessageEmbed
+	//channelID+messageID -> components it was last edited to have
+	editedMessageComponents map[string][]discordgo.MessageComponent
+	deletedMessageIDs       []string
+	//The next this many message edits fail
+	failingEditCount int
+	//Everyone who reacted to any message, with any emoji
+	reactors []*discordgo.User
+	//userID -> member
+	members map[string]*discordgo.Member
 }
 
 func (tc *TestController) GuildChannelCreateComplex(guildID string, data discordgo.GuildChannelCreateData) (st *discordgo.Channel, err error) {
@@ -31,22 +59,152 @@ func (tc *TestController) GuildChannelCreateComplex(guildID string, data discord
 }
 func (tc *TestController) ChannelEditComplex(channelID string, data *discordgo.ChannelEdit) (st *discordgo.Channel, err error) {
 	tc.channelEditComplexCallCount++
+	tc.editedChannelIDs = append(tc.editedChannelIDs, channelID)
 	return nil, nil
 }
 
 func (tc *TestController) GuildChannelsReorder(guildID string, channels []*discordgo.Channel) (err error) {
 	tc.guildChannelsReorderCallCount++
+	tc.lastReorderedChannels = channels
 	return nil
 }
 
-func (tc *TestController) ChannelMessage(channelID, messageID string) (st *discordgo.Message, err error) {
+func (tc *TestController) ChannelDelete(channelID string) (st *discordgo.Channel, err error) {
+	tc.deletedChannelIDs = append(tc.deletedChannelIDs, channelID)
 	return nil, nil
 }
 
-func (tc *TestController) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string) (st []*discordgo.Message, err error) {
+func (tc *TestController) Now() time.Time {
+	return tc.now
+}
+
+func (tc *TestController) ChannelMessageEditEmbeds(channelID, messageID string, embeds []*discordgo.MessageEmbed) (st *discordgo.Message, err error) {
+	if tc.failingEditCount > 0 {
+		tc.failingEditCount--
+		return nil, fmt.Errorf("edit failed")
+	}
+	if tc.editedMessageEmbeds == nil {
+		tc.editedMessageEmbeds = make(map[string][]*discordgo.MessageEmbed)
+	}
+	tc.editedMessageEmbeds[channelID+"+"+messageID] = embeds
+	return nil, nil
+}
+
+func (tc *TestController) ChannelMessageDelete(channelID, messageID string) error {
+	tc.deletedMessageIDs = append(tc.deletedMessageIDs, messageID)
+	return nil
+}
+
+func (tc *TestController) ChannelMessageEditComplex(m *discordgo.MessageEdit) (st *discordgo.Message, err error) {
+	if tc.editedMessageComponents == nil {
+		tc.editedMessageComponents = make(map[string][]discordgo.MessageComponent)
+	}
+	tc.editedMessageComponents[m.Channel+"+"+m.ID] = m.Components
+	return nil, nil
+}
+
+//MessageReactions returns tc.reactors, ignoring every argument but limit.
+func (tc *TestController) MessageReactions(channelID, messageID, emojiID string, limit int, beforeID, afterID string) (st []*discordgo.User, err error) {
+	if len(tc.reactors) > limit {
+		return tc.reactors[:limit], nil
+	}
+	return tc.reactors, nil
+}
+
+func (tc *TestController) GuildMember(guildID, userID string) (st *discordgo.Member, err error) {
+	member, ok := tc.members[userID]
+	if !ok {
+		return nil, fmt.Errorf("no member %v", userID)
+	}
+	return member, nil
+}
+
+func (tc *TestController) ChannelWebhooks(channelID string) (st []*discordgo.Webhook, err error) {
+	for _, webhook := range tc.webhooks {
+		if webhook.ChannelID == channelID {
+			st = append(st, webhook)
+		}
+	}
+	return st, nil
+}
+
+func (tc *TestController) WebhookCreate(channelID, name, avatar string) (st *discordgo.Webhook, err error) {
+	tc.webhookCreateCallCount++
+	webhook := &discordgo.Webhook{
+		ID:        "webhook-" + strconv.Itoa(len(tc.webhooks)+1),
+		ChannelID: channelID,
+		Name:      name,
+		Token:     "webhook-token",
+	}
+	tc.webhooks = append(tc.webhooks, webhook)
+	return webhook, nil
+}
+
+func (tc *TestController) Webhook(webhookID string) (st *discordgo.Webhook, err error) {
+	for _, webhook := range tc.webhooks {
+		if webhook.ID == webhookID {
+			return webhook, nil
+		}
+	}
+	return nil, fmt.Errorf("no webhook %v", webhookID)
+}
+
+//WebhookExecute records data and returns a message as Discord would have
+//posted it.
+func (tc *TestController) WebhookExecute(webhookID, token string, wait bool, data *discordgo.WebhookParams) (st *discordgo.Message, err error) {
+	tc.executedWebhookParams = append(tc.executedWebhookParams, data)
+	return &discordgo.Message{
+		ID:        "webhook-message-" + strconv.Itoa(len(tc.executedWebhookParams)),
+		WebhookID: webhookID,
+		Content:   data.Content,
+		Embeds:    data.Embeds,
+		Author: &discordgo.User{
+			ID:       webhookID,
+			Username: data.Username,
+		},
+	}, nil
+}
+
+func (tc *TestController) WebhookMessageEdit(webhookID, token, messageID string, data *discordgo.WebhookEdit) (st *discordgo.Message, err error) {
+	tc.editedWebhookMessageIDs = append(tc.editedWebhookMessageIDs, messageID)
+	tc.lastWebhookEdit = data
 	return nil, nil
 }
 
+//snowflakeForTime returns a snowflake ID that Discord would have generated at t
+func snowflakeForTime(t time.Time) string {
+	ms := t.UnixNano()/int64(time.Millisecond) - 1420070400000
+	return strconv.FormatInt(ms<<22, 10)
+}
+
+//ChannelMessage returns the message from tc.messages, ignoring channelID.
+func (tc *TestController) ChannelMessage(channelID, messageID string) (st *discordgo.Message, err error) {
+	tc.channelMessageCallCount++
+	for _, message := range tc.messages {
+		if message.ID == messageID {
+			return message, nil
+		}
+	}
+	return nil, fmt.Errorf("no message %v", messageID)
+}
+
+//ChannelMessages pages backwards through tc.messages, ignoring channelID,
+//afterID and aroundID.
+func (tc *TestController) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string) (st []*discordgo.Message, err error) {
+	tc.channelMessagesCallCount++
+	start := 0
+	for i, message := range tc.messages {
+		if message.ID == beforeID {
+			start = i + 1
+		}
+	}
+	end := start + limit
+	if end > len(tc.messages) {
+		end = len(tc.messages)
+	}
+	return tc.messages[start:end], nil
+}
+
 func TestReady(t *testing.T) {
 	session, _ := discordgo.New(TEST_TOKEN)
 	bot := newBot(session, &TestController{})
@@ -250,6 +408,7 @@ func TestMessageCreate(t *testing.T) {
 			GuildID:   TEST_GUILD_ID,
 		},
 	})
+	bot.reorderer.flushAll()
 	if controller.guildChannelsReorderCallCount != 1 {
 		t.Errorf("GuildChannelsReorder should have been called once.")
 	}
@@ -297,6 +456,7 @@ func TestChannelCreateSimple(t *testing.T) {
 	if controller.guildChannelCreateComplexCallCount != 0 {
 		t.Errorf("GuildChannelCreateComplex should not have been called.")
 	}
+	bot.reorderer.flushAll()
 	if controller.guildChannelsReorderCallCount != 1 {
 		t.Errorf("GuildChannelsReorder should have been called once.")
 	}
@@ -350,8 +510,9 @@ func TestChannelCreateArchive(t *testing.T) {
 	if controller.guildChannelCreateComplexCallCount != 1 {
 		t.Errorf("GuildChannelCreateComplex should have been called once.")
 	}
-	if controller.guildChannelsReorderCallCount != 1 {
-		t.Errorf("GuildChannelsReorder should have been called once.")
+	bot.reorderer.flushAll()
+	if controller.guildChannelsReorderCallCount != 0 {
+		t.Errorf("GuildChannelsReorder should not have been called since channel-1 was archived before the reorder was sent.")
 	}
 }
 
@@ -368,3 +529,1397 @@ func TestChannelUpdate(t *testing.T) {
 		t.Errorf("ChannelUpdate should have cleared cached state")
 	}
 }
+
+func TestUnarchiveThread(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 1
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	archivedChannel := &discordgo.Channel{
+		ID:       "archived-channel",
+		Type:     discordgo.ChannelTypeGuildText,
+		Name:     "archived-channel",
+		GuildID:  TEST_GUILD_ID,
+		ParentID: "thread-archive-category",
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "thread-archive-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_ARCHIVE_CATEGORY_NAME + " 1",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:       "channel-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+		},
+		archivedChannel,
+	}
+	session.State.GuildAdd(guild)
+	if thread := bot.findArchivedThread(TEST_GUILD_ID, "archived-channel"); thread != archivedChannel {
+		t.Errorf("findArchivedThread should have found the archived channel.")
+	}
+	if thread := bot.findArchivedThread(TEST_GUILD_ID, "channel-1"); thread != nil {
+		t.Errorf("findArchivedThread should not have found an active channel.")
+	}
+	if err := bot.unarchiveThread(archivedChannel); err != nil {
+		t.Errorf("unarchiveThread returned an error: %v", err)
+	}
+	if archivedChannel.ParentID != "thread-category" {
+		t.Errorf("Unarchived thread should have been moved to the thread category.")
+	}
+	// Once to unarchive, and once to archive channel-1 which no longer fits
+	if controller.channelEditComplexCallCount != 2 {
+		t.Errorf("ChannelEditComplex should have been called twice.")
+	}
+	if controller.guildChannelCreateComplexCallCount != 0 {
+		t.Errorf("GuildChannelCreateComplex should not have been called.")
+	}
+	bot.reorderer.flushAll()
+	if controller.guildChannelsReorderCallCount != 0 {
+		t.Errorf("GuildChannelsReorder should not have been called since channel-1 was archived before the reorder was sent.")
+	}
+}
+
+func TestGuildCreatePerGroupMaxActiveThreads(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 5
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	settings := newGuildSettings(TEST_GUILD_ID)
+	if err := settings.SetMaxActiveThreadsForGroup("Design", 1); err != nil {
+		t.Errorf("Couldn't set max active threads: %v", err)
+	}
+	bot.settings[TEST_GUILD_ID] = settings
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "design-thread-archive-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    "Design " + THREAD_ARCHIVE_CATEGORY_NAME + " 1",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "design-thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    "Design " + THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:       "design-channel-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "design-channel-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "design-thread-category",
+		},
+		{
+			ID:       "design-channel-2",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "design-channel-2",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "design-thread-category",
+		},
+		{
+			ID:       "channel-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+		},
+		{
+			ID:       "channel-2",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-2",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+		},
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+	infos := bot.getInfos(TEST_GUILD_ID)
+	if infos["design-thread-category"].maxActiveThreads != 1 {
+		t.Errorf("Design group should have had its configured limit.")
+	}
+	if infos["thread-category"].maxActiveThreads != 5 {
+		t.Errorf("Default group should have had the global limit.")
+	}
+	// Only the design group is over its limit
+	if controller.channelEditComplexCallCount != 1 {
+		t.Errorf("ChannelEditComplex should have been called once.")
+	}
+}
+
+func TestArchiveIdleThreads(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 5
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	controller := &TestController{
+		now: now,
+	}
+	controller.session = session
+	bot := newBot(session, controller)
+	settings := newGuildSettings(TEST_GUILD_ID)
+	if err := settings.SetIdleArchiveDaysForGroup("", 21); err != nil {
+		t.Errorf("Couldn't set idle archive days: %v", err)
+	}
+	bot.settings[TEST_GUILD_ID] = settings
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "thread-archive-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_ARCHIVE_CATEGORY_NAME + " 1",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:            "dead-channel",
+			Type:          discordgo.ChannelTypeGuildText,
+			Name:          "dead-channel",
+			GuildID:       TEST_GUILD_ID,
+			ParentID:      "thread-category",
+			LastMessageID: snowflakeForTime(now.Add(-time.Hour * 24 * 30)),
+		},
+		{
+			ID:            "quiet-channel",
+			Type:          discordgo.ChannelTypeGuildText,
+			Name:          "quiet-channel",
+			GuildID:       TEST_GUILD_ID,
+			ParentID:      "thread-category",
+			LastMessageID: snowflakeForTime(now.Add(-time.Hour * 24 * 14)),
+		},
+		{
+			ID:            "busy-channel",
+			Type:          discordgo.ChannelTypeGuildText,
+			Name:          "busy-channel",
+			GuildID:       TEST_GUILD_ID,
+			ParentID:      "thread-category",
+			LastMessageID: snowflakeForTime(now.Add(-time.Hour)),
+		},
+	}
+	session.State.GuildAdd(guild)
+
+	bot.archiveIdleThreads()
+	if len(controller.editedChannelIDs) != 1 || controller.editedChannelIDs[0] != "dead-channel" {
+		t.Errorf("Only the dead channel should have been archived, got %v", controller.editedChannelIDs)
+	}
+
+	//Unarchiving the dead channel counts as activity, even though its last
+	//message is still old
+	deadChannel, _ := session.State.Channel("dead-channel")
+	if err := bot.unarchiveThread(deadChannel); err != nil {
+		t.Fatalf("Couldn't unarchive the dead channel: %v", err)
+	}
+	bot.archiveIdleThreads()
+	if strings.Join(controller.editedChannelIDs, ",") != "dead-channel,dead-channel" {
+		t.Errorf("An unarchived thread shouldn't be archived again straight away, got %v", controller.editedChannelIDs)
+	}
+
+	//A week later the quiet channel has been idle too long as well
+	controller.now = now.Add(time.Hour * 24 * 8)
+	bot.archiveIdleThreads()
+	if strings.Join(controller.editedChannelIDs, ",") != "dead-channel,dead-channel,quiet-channel" {
+		t.Errorf("The quiet channel should have been archived next, got %v", controller.editedChannelIDs)
+	}
+	if controller.guildChannelCreateComplexCallCount != 0 {
+		t.Errorf("GuildChannelCreateComplex should not have been called.")
+	}
+}
+
+func TestMessageCreateNotesActivity(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 5
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	controller := &TestController{
+		now: now,
+	}
+	controller.session = session
+	bot := newBot(session, controller)
+	settings := newGuildSettings(TEST_GUILD_ID)
+	if err := settings.SetIdleArchiveDaysForGroup("", 21); err != nil {
+		t.Errorf("Couldn't set idle archive days: %v", err)
+	}
+	bot.settings[TEST_GUILD_ID] = settings
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:            "top-channel",
+			Type:          discordgo.ChannelTypeGuildText,
+			Name:          "top-channel",
+			GuildID:       TEST_GUILD_ID,
+			ParentID:      "thread-category",
+			LastMessageID: snowflakeForTime(now.Add(-time.Hour * 24 * 30)),
+		},
+	}
+	session.State.GuildAdd(guild)
+
+	messageID := snowflakeForTime(now.Add(-time.Hour))
+	bot.messageCreate(session, &discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			ID:        messageID,
+			ChannelID: "top-channel",
+			GuildID:   TEST_GUILD_ID,
+		},
+	})
+	bot.reorderer.flushAll()
+	if controller.guildChannelsReorderCallCount != 0 {
+		t.Errorf("The only thread shouldn't have needed reordering")
+	}
+	channel, _ := session.State.Channel("top-channel")
+	if channel.LastMessageID != messageID {
+		t.Errorf("Expected the new message to be the channel's last, got %v", channel.LastMessageID)
+	}
+
+	bot.archiveIdleThreads()
+	if len(controller.editedChannelIDs) != 0 {
+		t.Errorf("A thread with a new message shouldn't be archived as idle, got %v", controller.editedChannelIDs)
+	}
+}
+
+func TestPinnedThreads(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 2
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	settings := newGuildSettings(TEST_GUILD_ID)
+	settings.SetThreadPinned("pinned-channel", true)
+	bot.settings[TEST_GUILD_ID] = settings
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	pinnedChannel := &discordgo.Channel{
+		ID:       "pinned-channel",
+		Type:     discordgo.ChannelTypeGuildText,
+		Name:     "pinned-channel",
+		GuildID:  TEST_GUILD_ID,
+		ParentID: "thread-category",
+		Position: 1,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "thread-archive-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_ARCHIVE_CATEGORY_NAME + " 1",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:       "channel-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+			Position: 0,
+		},
+		pinnedChannel,
+		{
+			ID:       "channel-2",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-2",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+			Position: 2,
+		},
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+	if controller.channelEditComplexCallCount != 0 {
+		t.Errorf("ChannelEditComplex should not have been called since the pinned thread doesn't count.")
+	}
+
+	bot.messageCreate(session, &discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			ID:        "message-1",
+			ChannelID: "pinned-channel",
+			GuildID:   TEST_GUILD_ID,
+		},
+	})
+	if controller.guildChannelsReorderCallCount != 0 {
+		t.Errorf("GuildChannelsReorder should not have been called for a pinned thread.")
+	}
+
+	bot.messageCreate(session, &discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			ID:        "message-2",
+			ChannelID: "channel-2",
+			GuildID:   TEST_GUILD_ID,
+		},
+	})
+	bot.reorderer.flushAll()
+	if controller.guildChannelsReorderCallCount != 1 {
+		t.Errorf("GuildChannelsReorder should have been called once.")
+	}
+	if pinnedChannel.Position != 1 {
+		t.Errorf("Pinned thread should have kept its position, got %v", pinnedChannel.Position)
+	}
+	channel2, _ := session.State.Channel("channel-2")
+	if channel2.Position != 0 {
+		t.Errorf("channel-2 should have moved to the top, got %v", channel2.Position)
+	}
+	channel1, _ := session.State.Channel("channel-1")
+	if channel1.Position != 2 {
+		t.Errorf("channel-1 should have slid past the pinned thread, got %v", channel1.Position)
+	}
+
+	//The top thread is already above the pinned one, so neither should move
+	bot.messageCreate(session, &discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			ID:        "message-3",
+			ChannelID: "channel-2",
+			GuildID:   TEST_GUILD_ID,
+		},
+	})
+	bot.reorderer.flushAll()
+	if pinnedChannel.Position != 1 {
+		t.Errorf("Pinned thread should have kept its position when the thread above it was bumped, got %v", pinnedChannel.Position)
+	}
+	if channel2.Position != 0 {
+		t.Errorf("channel-2 should have stayed at the top, got %v", channel2.Position)
+	}
+
+	if err := bot.setThreadPinned(pinnedChannel, false); err != nil {
+		t.Errorf("Couldn't unpin thread: %v", err)
+	}
+	if controller.channelEditComplexCallCount != 1 {
+		t.Errorf("ChannelEditComplex should have been called once after unpinning put the group over its limit.")
+	}
+}
+
+func TestArchivedThreadsSortedNewestFirst(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 1
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	controller := &TestController{
+		now: now,
+	}
+	controller.session = session
+	bot := newBot(session, controller)
+	settings := newGuildSettings(TEST_GUILD_ID)
+	settings.SetThreadArchiveTime("old-archived-channel", now.Add(-time.Hour*24))
+	bot.settings[TEST_GUILD_ID] = settings
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "thread-archive-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_ARCHIVE_CATEGORY_NAME + " 1",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:       "old-archived-channel",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "old-archived-channel",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-archive-category",
+			Position: 0,
+		},
+		{
+			ID:            "channel-1",
+			Type:          discordgo.ChannelTypeGuildText,
+			Name:          "channel-1",
+			GuildID:       TEST_GUILD_ID,
+			ParentID:      "thread-category",
+			Position:      0,
+			LastMessageID: snowflakeForTime(now.Add(-time.Hour)),
+		},
+		{
+			ID:            "channel-2",
+			Type:          discordgo.ChannelTypeGuildText,
+			Name:          "channel-2",
+			GuildID:       TEST_GUILD_ID,
+			ParentID:      "thread-category",
+			Position:      1,
+			LastMessageID: snowflakeForTime(now.Add(-time.Hour * 2)),
+		},
+		{
+			ID:            "channel-3",
+			Type:          discordgo.ChannelTypeGuildText,
+			Name:          "channel-3",
+			GuildID:       TEST_GUILD_ID,
+			ParentID:      "thread-category",
+			Position:      2,
+			LastMessageID: snowflakeForTime(now.Add(-time.Hour * 3)),
+		},
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+	if controller.channelEditComplexCallCount != 2 {
+		t.Errorf("ChannelEditComplex should have been called twice.")
+	}
+	if controller.guildChannelsReorderCallCount != 0 {
+		t.Errorf("GuildChannelsReorder should not be called before the moves are confirmed.")
+	}
+
+	// A stale update from before channel-3 was archived arrives late.
+	staleUpdate := &discordgo.Channel{
+		ID:       "channel-3",
+		Type:     discordgo.ChannelTypeGuildText,
+		Name:     "channel-3",
+		GuildID:  TEST_GUILD_ID,
+		ParentID: "thread-category",
+		Position: 2,
+	}
+	session.State.ChannelAdd(staleUpdate)
+	bot.channelUpdate(session, &discordgo.ChannelUpdate{Channel: staleUpdate})
+
+	for _, id := range []string{"channel-3", "channel-2"} {
+		channel, _ := session.State.Channel(id)
+		update := *channel
+		update.ParentID = "thread-archive-category"
+		update.Position = 0
+		session.State.ChannelAdd(&update)
+		bot.channelUpdate(session, &discordgo.ChannelUpdate{Channel: &update})
+		if id == "channel-3" && controller.guildChannelsReorderCallCount != 0 {
+			t.Errorf("GuildChannelsReorder should wait for the whole batch to arrive.")
+		}
+	}
+	if controller.guildChannelsReorderCallCount != 1 {
+		t.Errorf("GuildChannelsReorder should have been called once, got %v", controller.guildChannelsReorderCallCount)
+	}
+	expectedPositions := map[string]int{
+		"channel-2":            0,
+		"channel-3":            1,
+		"old-archived-channel": 2,
+	}
+	for id, expected := range expectedPositions {
+		channel, _ := session.State.Channel(id)
+		if channel.Position != expected {
+			t.Errorf("%v should have been at position %v but was at %v", id, expected, channel.Position)
+		}
+	}
+}
+
+func TestReorderCoalesced(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 5
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+	}
+	for i := 1; i <= 5; i++ {
+		guild.Channels = append(guild.Channels, &discordgo.Channel{
+			ID:       "channel-" + strconv.Itoa(i),
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-" + strconv.Itoa(i),
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+			Position: i - 1,
+		})
+	}
+	session.State.GuildAdd(guild)
+
+	sendMessage := func(channelID string) {
+		bot.messageCreate(session, &discordgo.MessageCreate{
+			Message: &discordgo.Message{
+				ID:        "message-in-" + channelID,
+				ChannelID: channelID,
+				GuildID:   TEST_GUILD_ID,
+			},
+		})
+	}
+
+	// Already at the top, so nothing to do
+	sendMessage("channel-1")
+	// A burst in a few threads
+	sendMessage("channel-2")
+	sendMessage("channel-3")
+	sendMessage("channel-3")
+	if controller.guildChannelsReorderCallCount != 0 {
+		t.Errorf("GuildChannelsReorder should not have been called before the burst settled.")
+	}
+	bot.reorderer.flushAll()
+	if controller.guildChannelsReorderCallCount != 1 {
+		t.Errorf("GuildChannelsReorder should have been called once, got %v", controller.guildChannelsReorderCallCount)
+	}
+	var reorderedIDs []string
+	for _, channel := range controller.lastReorderedChannels {
+		reorderedIDs = append(reorderedIDs, channel.ID+":"+strconv.Itoa(channel.Position))
+	}
+	// channel-2 ended up back where it started, and channel-4 and channel-5
+	// never moved, so they shouldn't be sent.
+	expectedIDs := []string{"channel-3:0", "channel-1:2"}
+	if len(reorderedIDs) != len(expectedIDs) {
+		t.Errorf("Expected only changed channels %v to be sent, got %v", expectedIDs, reorderedIDs)
+	} else {
+		for i, id := range expectedIDs {
+			if reorderedIDs[i] != id {
+				t.Errorf("Expected only changed channels %v to be sent, got %v", expectedIDs, reorderedIDs)
+				break
+			}
+		}
+	}
+	stats := bot.reorderer.Stats()
+	if stats.SkippedNoOp != 2 {
+		t.Errorf("Expected 2 no-op reorders to be skipped, got %v", stats.SkippedNoOp)
+	}
+	if stats.Coalesced != 1 {
+		t.Errorf("Expected 1 reorder to be coalesced, got %v", stats.Coalesced)
+	}
+	if stats.CallsSaved() != 3 {
+		t.Errorf("Expected 3 calls to be saved, got %v", stats.CallsSaved())
+	}
+	if stats.ChannelsUnchanged != 3 {
+		t.Errorf("Expected 3 channels to be left out, got %v", stats.ChannelsUnchanged)
+	}
+}
+
+func TestArchiveOverflowCreatesCategory(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 1
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Roles = []*discordgo.Role{
+		{
+			ID:   "everyone-role",
+			Name: EVERYONE_ROLE_NAME,
+		},
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "full-archive-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_ARCHIVE_CATEGORY_NAME + " 12",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:       "channel-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+			Position: 0,
+		},
+		{
+			ID:       "channel-2",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-2",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+			Position: 1,
+		},
+	}
+	for i := 0; i < MAX_CATEGORY_CHANNELS; i++ {
+		guild.Channels = append(guild.Channels, &discordgo.Channel{
+			ID:       "archived-" + strconv.Itoa(i),
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "archived-" + strconv.Itoa(i),
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "full-archive-category",
+			Position: i,
+		})
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+	if controller.guildChannelCreateComplexCallCount != 1 {
+		t.Errorf("A new archive category should have been created since the active one was full.")
+	}
+	channel, _ := session.State.Channel("channel-2")
+	if channel.ParentID != "thread-archive-category" {
+		t.Errorf("channel-2 should have been archived into the new category, but was in %v", channel.ParentID)
+	}
+}
+
+func TestCompactArchives(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 5
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	controller := &TestController{
+		now: now,
+	}
+	controller.session = session
+	bot := newBot(session, controller)
+	settings := newGuildSettings(TEST_GUILD_ID)
+	for i, id := range []string{"archived-1", "archived-2", "archived-3", "archived-4"} {
+		settings.SetThreadArchiveTime(id, now.Add(time.Hour*time.Duration(i-10)))
+	}
+	bot.settings[TEST_GUILD_ID] = settings
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "archive-category-1",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_ARCHIVE_CATEGORY_NAME + " 1",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "archive-category-2",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_ARCHIVE_CATEGORY_NAME + " 2",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:       "archived-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "archived-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "archive-category-1",
+			Position: 0,
+		},
+		{
+			ID:       "archived-3",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "archived-3",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "archive-category-2",
+			Position: 1,
+		},
+		{
+			ID:       "archived-2",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "archived-2",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "archive-category-2",
+			Position: 0,
+		},
+		{
+			ID:       "archived-4",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "archived-4",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "archive-category-2",
+			Position: 2,
+		},
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+
+	group := bot.findThreadGroup(TEST_GUILD_ID, "thread-category")
+	if _, err := bot.compactArchives(TEST_GUILD_ID, ""); err != nil {
+		t.Errorf("compactArchives returned an error: %v", err)
+	}
+	if controller.channelEditComplexCallCount != 3 {
+		t.Errorf("The three threads in the second archive should have been moved, got %v", controller.editedChannelIDs)
+	}
+	if len(controller.deletedChannelIDs) != 1 || controller.deletedChannelIDs[0] != "archive-category-2" {
+		t.Errorf("The emptied archive category should have been deleted, got %v", controller.deletedChannelIDs)
+	}
+	if group.activeArchiveCategoryID != "archive-category-1" {
+		t.Errorf("The remaining archive category should have become active, got %v", group.activeArchiveCategoryID)
+	}
+	if len(group.archiveCategoryIDs) != 1 || group.archiveCategoryIDs[0] != "archive-category-1" {
+		t.Errorf("Only the remaining archive category should be left, got %v", group.archiveCategoryIDs)
+	}
+	if group.nextArchiveCategoryIndex != 2 {
+		t.Errorf("The next archive category should have been number 2, got %v", group.nextArchiveCategoryIndex)
+	}
+	expectedPositions := map[string]int{
+		"archived-4": 0,
+		"archived-3": 1,
+		"archived-2": 2,
+		"archived-1": 3,
+	}
+	for id, expected := range expectedPositions {
+		channel, _ := session.State.Channel(id)
+		if channel.ParentID != "archive-category-1" {
+			t.Errorf("%v should have been moved to the first archive category", id)
+		}
+		if channel.Position != expected {
+			t.Errorf("%v should have been at position %v but was at %v", id, expected, channel.Position)
+		}
+	}
+}
+
+func TestSanitizeChannelName(t *testing.T) {
+	tests := []struct {
+		Description string
+		Input       string
+		Expected    string
+	}{
+		{
+			"Already valid",
+			"design-review",
+			"design-review",
+		},
+		{
+			"Spaces and capitals",
+			"Design Review",
+			"design-review",
+		},
+		{
+			"Punctuation collapses",
+			"  What's next?!  Q3 -- planning ",
+			"what-s-next-q3-planning",
+		},
+		{
+			"Unicode letters kept",
+			"Café ideas",
+			"café-ideas",
+		},
+		{
+			"Nothing usable",
+			"?!",
+			"",
+		},
+		{
+			"Too long",
+			strings.Repeat("a", MAX_CHANNEL_NAME_LENGTH+10),
+			strings.Repeat("a", MAX_CHANNEL_NAME_LENGTH),
+		},
+	}
+	for i, test := range tests {
+		result := sanitizeChannelName(test.Input)
+		if result != test.Expected {
+			t.Errorf("Test %v (%v) failed. Got %v, expected %v", i, test.Description, result, test.Expected)
+		}
+	}
+}
+
+func TestDefaultThreadGroup(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 5
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	settings := newGuildSettings(TEST_GUILD_ID)
+	bot.settings[TEST_GUILD_ID] = settings
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "zeta-thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    "Zeta " + THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "design-thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    "Design " + THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+	for i := 0; i < 10; i++ {
+		if group := bot.defaultThreadGroup(TEST_GUILD_ID); group == nil || group.threadCategoryID != "design-thread-category" {
+			t.Fatalf("Without a \"\" group the first group by name should be the default")
+		}
+	}
+
+	session.State.ChannelAdd(&discordgo.Channel{
+		ID:      "thread-category",
+		Type:    discordgo.ChannelTypeGuildCategory,
+		Name:    THREAD_CATEGORY_NAME,
+		GuildID: TEST_GUILD_ID,
+	})
+	bot.setGuildNeedsInfoRegeneration(TEST_GUILD_ID)
+	if group := bot.defaultThreadGroup(TEST_GUILD_ID); group == nil || group.threadCategoryID != "thread-category" {
+		t.Errorf("The \"\" group should be the default if there is one")
+	}
+
+	settings.SetDefaultThreadGroup("Zeta")
+	if group := bot.defaultThreadGroup(TEST_GUILD_ID); group == nil || group.threadCategoryID != "zeta-thread-category" {
+		t.Errorf("The configured default group should be used")
+	}
+
+	if _, err := bot.createNewThreadInDefaultCategory(TEST_GUILD_ID, "new-thread"); err != nil {
+		t.Errorf("createNewThreadInDefaultCategory returned an error: %v", err)
+	}
+	if controller.guildChannelCreateComplexCallCount != 1 {
+		t.Errorf("GuildChannelCreateComplex should have been called once")
+	}
+}
+
+func TestMoveThread(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 5
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	settings := newGuildSettings(TEST_GUILD_ID)
+	settings.SetMaxActiveThreadsForGroup("Design", 1)
+	bot.settings[TEST_GUILD_ID] = settings
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	designOverwrites := []*discordgo.PermissionOverwrite{
+		{
+			ID:   "design-role",
+			Type: discordgo.PermissionOverwriteTypeRole,
+		},
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:                   "design-thread-category",
+			Type:                 discordgo.ChannelTypeGuildCategory,
+			Name:                 "Design " + THREAD_CATEGORY_NAME,
+			GuildID:              TEST_GUILD_ID,
+			PermissionOverwrites: designOverwrites,
+		},
+		{
+			ID:      "design-thread-archive-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    "Design " + THREAD_ARCHIVE_CATEGORY_NAME + " 1",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:       "channel-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+			Position: 0,
+		},
+		{
+			ID:       "design-channel-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "design-channel-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "design-thread-category",
+			Position: 0,
+		},
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+	if controller.channelEditComplexCallCount != 0 {
+		t.Fatalf("Nothing should have been archived on boot")
+	}
+
+	thread, _ := session.State.Channel("channel-1")
+	destination := bot.findThreadGroup(TEST_GUILD_ID, "Design")
+	if err := bot.moveThread(thread, destination); err != nil {
+		t.Fatalf("moveThread returned an error: %v", err)
+	}
+	if thread.ParentID != "design-thread-category" {
+		t.Errorf("Thread should have been moved to the design category")
+	}
+	if len(thread.PermissionOverwrites) != 1 || thread.PermissionOverwrites[0].ID != "design-role" {
+		t.Errorf("Thread should have been synced with the design category's permissions")
+	}
+	expectedEdits := []string{"channel-1", "design-channel-1"}
+	if strings.Join(controller.editedChannelIDs, ",") != strings.Join(expectedEdits, ",") {
+		t.Errorf("Expected edits to %v, got %v", expectedEdits, controller.editedChannelIDs)
+	}
+	designChannel, _ := session.State.Channel("design-channel-1")
+	if designChannel.ParentID != "design-thread-archive-category" {
+		t.Errorf("The design group's old thread should have been archived to make room")
+	}
+
+	if err := bot.moveThread(thread, destination); err == nil {
+		t.Errorf("Moving a thread to the group it is already in should fail")
+	}
+}
+
+func TestExistingThreadPicker(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 50
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+	}
+	for i := 0; i <= 30; i++ {
+		guild.Channels = append(guild.Channels, &discordgo.Channel{
+			ID:       "channel-" + strconv.Itoa(i),
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-" + strconv.Itoa(i),
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+			Position: i,
+		})
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+
+	pickerPage := func(offset int) ([]discordgo.SelectMenuOption, []string) {
+		data := bot.existingThreadPicker(TEST_GUILD_ID, "channel-0", "message-1", offset)
+		if len(data.Components) != 2 {
+			t.Fatalf("Expected a thread picker and buttons, got %v", data.Components)
+		}
+		options := data.Components[0].(discordgo.ActionsRow).Components[0].(discordgo.SelectMenu).Options
+		var buttonIDs []string
+		for _, button := range data.Components[1].(discordgo.ActionsRow).Components {
+			buttonIDs = append(buttonIDs, button.(discordgo.Button).CustomID)
+		}
+		return options, buttonIDs
+	}
+
+	options, buttonIDs := pickerPage(0)
+	if len(options) != MAX_SELECT_MENU_OPTIONS || options[0].Value != "channel-1" {
+		t.Errorf("Expected the first page to start at channel-1 and be full, got %v options starting at %v", len(options), options[0].Value)
+	}
+	expectedButtonIDs := FORK_TO_EXISTING_THREAD_PAGE_ID + ":channel-0:message-1:25"
+	if strings.Join(buttonIDs, ",") != expectedButtonIDs {
+		t.Errorf("Expected buttons %v, got %v", expectedButtonIDs, buttonIDs)
+	}
+
+	options, buttonIDs = pickerPage(25)
+	if len(options) != 5 || options[0].Value != "channel-26" {
+		t.Errorf("Expected the second page to have the last 5 threads, got %v options starting at %v", len(options), options[0].Value)
+	}
+	expectedButtonIDs = FORK_TO_EXISTING_THREAD_PAGE_ID + ":channel-0:message-1:0"
+	if strings.Join(buttonIDs, ",") != expectedButtonIDs {
+		t.Errorf("Expected buttons %v, got %v", expectedButtonIDs, buttonIDs)
+	}
+}
+
+func TestActiveThreads(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 5
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "design-thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    "Design " + THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-archive-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_ARCHIVE_CATEGORY_NAME + " 1",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:       "design-channel-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "design-channel-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "design-thread-category",
+			Position: 0,
+		},
+		{
+			ID:       "channel-2",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-2",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+			Position: 1,
+		},
+		{
+			ID:       "channel-1",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "channel-1",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-category",
+			Position: 0,
+		},
+		{
+			ID:       "archived-channel",
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     "archived-channel",
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-archive-category",
+		},
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+
+	var ids []string
+	for _, thread := range bot.activeThreads(TEST_GUILD_ID) {
+		ids = append(ids, thread.ID)
+	}
+	expected := []string{"channel-1", "channel-2", "design-channel-1"}
+	if strings.Join(ids, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected active threads %v, got %v", expected, ids)
+	}
+
+	thread, _ := session.State.Channel("channel-1")
+	if err := bot.forkToExistingThread(messageReference(TEST_GUILD_ID, "channel-1", "message-1"), thread, "user-1"); err == nil {
+		t.Errorf("Forking a message into its own channel should fail")
+	}
+}
+
+func TestSelectMessagesToFork(t *testing.T) {
+	reactions := func(emojis ...string) []*discordgo.MessageReactions {
+		var result []*discordgo.MessageReactions
+		for _, emoji := range emojis {
+			result = append(result, &discordgo.MessageReactions{
+				Count: 1,
+				Emoji: &discordgo.Emoji{Name: emoji},
+			})
+		}
+		return result
+	}
+	last := &discordgo.Message{ID: "5", Reactions: reactions(FORK_THREAD_EMOJI)}
+	tests := []struct {
+		Description      string
+		PreviousMessages []*discordgo.Message
+		FirstMessageID   string
+		Expected         []string
+		ExpectComplete   bool
+	}{
+		{
+			"No start marker",
+			[]*discordgo.Message{
+				{ID: "4"},
+				{ID: "3"},
+			},
+			"",
+			[]string{"5"},
+			false,
+		},
+		{
+			"Start marker",
+			[]*discordgo.Message{
+				{ID: "4"},
+				{ID: "3", Reactions: reactions(START_FORK_THREAD_EMOJI)},
+				{ID: "2"},
+			},
+			"",
+			[]string{"3", "4", "5"},
+			true,
+		},
+		{
+			"Earlier fork hides start marker",
+			[]*discordgo.Message{
+				{ID: "4", Reactions: reactions(FORK_THREAD_EMOJI)},
+				{ID: "3", Reactions: reactions(START_FORK_THREAD_EMOJI)},
+			},
+			"",
+			[]string{"5"},
+			true,
+		},
+		{
+			"Explicit first message ignores markers",
+			[]*discordgo.Message{
+				{ID: "4", Reactions: reactions(FORK_THREAD_EMOJI)},
+				{ID: "3", Type: discordgo.MessageTypeChannelPinnedMessage},
+				{ID: "2"},
+				{ID: "1"},
+			},
+			"2",
+			[]string{"2", "4", "5"},
+			true,
+		},
+		{
+			"Explicit first message not found",
+			[]*discordgo.Message{
+				{ID: "4"},
+			},
+			"1",
+			[]string{"5"},
+			false,
+		},
+	}
+	for i, test := range tests {
+		result, complete := selectMessagesToFork(last, test.PreviousMessages, test.FirstMessageID)
+		if complete != test.ExpectComplete {
+			t.Errorf("Test %v (%v) expected complete to be %v", i, test.Description, test.ExpectComplete)
+		}
+		var ids []string
+		for _, msg := range result {
+			ids = append(ids, msg.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(test.Expected, ",") {
+			t.Errorf("Test %v (%v) failed. Got %v, expected %v", i, test.Description, ids, test.Expected)
+		}
+	}
+}
+
+func TestMessagesToForkPages(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxForkMessages = 250
+	controller := &TestController{}
+	controller.session = session
+	bot := newBot(session, controller)
+	//Message IDs count down from 300 to 1, most recent first
+	for i := 300; i > 0; i-- {
+		controller.messages = append(controller.messages, &discordgo.Message{
+			ID:        strconv.Itoa(i),
+			ChannelID: "channel-1",
+		})
+	}
+	last := controller.messages[0]
+
+	messages, limitReached, err := bot.messagesToFork(last, "120")
+	if err != nil {
+		t.Fatalf("messagesToFork returned an error: %v", err)
+	}
+	if len(messages) != 181 || messages[0].ID != "120" || messages[len(messages)-1].ID != "300" {
+		t.Errorf("Expected messages 120 through 300, got %v messages", len(messages))
+	}
+	if limitReached {
+		t.Errorf("The limit shouldn't have been reached")
+	}
+	if controller.channelMessagesCallCount != 2 {
+		t.Errorf("Expected two pages to be fetched, got %v", controller.channelMessagesCallCount)
+	}
+
+	if _, _, err := bot.messagesToFork(last, "10"); err == nil {
+		t.Errorf("Forking from further back than maxForkMessages should fail")
+	}
+
+	startMarker := []*discordgo.MessageReactions{
+		{
+			Count: 1,
+			Emoji: &discordgo.Emoji{Name: START_FORK_THREAD_EMOJI},
+		},
+	}
+	tests := []struct {
+		Description     string
+		MaxMessages     int
+		StartMarkerAt   int
+		ExpectedFirstID string
+		ExpectedLimit   bool
+	}{
+		{
+			"No start marker",
+			250,
+			0,
+			"300",
+			false,
+		},
+		{
+			"Start marker in the first page",
+			250,
+			50,
+			"250",
+			false,
+		},
+		{
+			"Start marker in the first page but beyond the limit",
+			20,
+			50,
+			"300",
+			true,
+		},
+		{
+			"Start marker beyond the first page",
+			250,
+			200,
+			"300",
+			false,
+		},
+	}
+	for i, test := range tests {
+		maxForkMessages = test.MaxMessages
+		for _, msg := range controller.messages {
+			msg.Reactions = nil
+		}
+		if test.StartMarkerAt > 0 {
+			controller.messages[test.StartMarkerAt].Reactions = startMarker
+		}
+		controller.channelMessagesCallCount = 0
+		messages, limitReached, err := bot.messagesToFork(last, "")
+		if err != nil {
+			t.Errorf("Test %v (%v) returned an error: %v", i, test.Description, err)
+			continue
+		}
+		if messages[0].ID != test.ExpectedFirstID || limitReached != test.ExpectedLimit {
+			t.Errorf("Test %v (%v) expected to fork from %v with limit reached %v, got %v messages from %v with %v", i, test.Description, test.ExpectedFirstID, test.ExpectedLimit, len(messages), messages[0].ID, limitReached)
+		}
+		if controller.channelMessagesCallCount != 1 {
+			t.Errorf("Test %v (%v) expected only the first page to be fetched, got %v", i, test.Description, controller.channelMessagesCallCount)
+		}
+	}
+}
+
+func TestLatestMessageID(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	controller := &TestController{}
+	bot := newBot(session, controller)
+	if _, err := bot.latestMessageID("channel-1"); err == nil {
+		t.Errorf("Expected an error for a channel with no messages")
+	}
+	controller.messages = []*discordgo.Message{
+		{ID: "message-3"},
+		{ID: "message-2"},
+		{ID: "message-1"},
+	}
+	if id, err := bot.latestMessageID("channel-1"); err != nil || id != "message-3" {
+		t.Errorf("Expected the newest message, got %v (%v)", id, err)
+	}
+}
+
+func TestForksOfForks(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	original := &discordgo.Message{
+		ID:        "300000000000000001",
+		ChannelID: "200000000000000001",
+		GuildID:   TEST_GUILD_ID,
+		Content:   "hello",
+		Author: &discordgo.User{
+			ID:       "user-1",
+			Username: "alice",
+		},
+	}
+	fork := &discordgo.Message{
+		ID:        "500000000000000001",
+		ChannelID: "400000000000000001",
+		GuildID:   TEST_GUILD_ID,
+		Embeds:    []*discordgo.MessageEmbed{createForkMessageEmbed(original)},
+	}
+	//Forked before forks of forks copied the original
+	forkOfFork := &discordgo.Message{
+		ID:        "500000000000000002",
+		ChannelID: "400000000000000002",
+		GuildID:   TEST_GUILD_ID,
+		Embeds:    []*discordgo.MessageEmbed{createForkMessageEmbed(fork)},
+	}
+	controller := &TestController{
+		messages: []*discordgo.Message{forkOfFork, fork, original},
+	}
+	bot := newBot(session, controller)
+	bot.settings[TEST_GUILD_ID] = newGuildSettings(TEST_GUILD_ID)
+	idf := newIDFIndex(TEST_GUILD_ID)
+	bot.indexes[TEST_GUILD_ID] = idf
+	for _, msg := range []*discordgo.Message{fork, forkOfFork} {
+		if !idf.NoteForkMessage(msg) {
+			t.Fatalf("Expected %v to be indexed as a fork", msg.ID)
+		}
+	}
+	if result := bot.originalOfFork(forkOfFork); result.ID != original.ID {
+		t.Errorf("Expected forking a fork of a fork to copy the original, got %v", result.ID)
+	}
+	if result := bot.originalOfFork(original); result != original {
+		t.Errorf("Expected a message that isn't a fork to be forked as is, got %v", result.ID)
+	}
+
+	original.Content = "hello again"
+	if err := bot.updateForkedMessages(original); err != nil {
+		t.Fatalf("updateForkedMessages returned an error: %v", err)
+	}
+	for _, key := range []string{"400000000000000001+500000000000000001", "400000000000000002+500000000000000002"} {
+		embeds := controller.editedMessageEmbeds[key]
+		if len(embeds) == 0 {
+			t.Errorf("Expected %v to be updated", key)
+			continue
+		}
+		if embeds[0].Description != "hello again" || embeds[0].Author == nil || embeds[0].Author.Name != "alice" {
+			t.Errorf("Expected %v to show the original, got %v by %v", key, embeds[0].Description, embeds[0].Author)
+		}
+	}
+
+	controller.editedMessageEmbeds = nil
+	if err := bot.updateForkedMessages(fork); err != nil {
+		t.Fatalf("updateForkedMessages returned an error: %v", err)
+	}
+	if len(controller.editedMessageEmbeds) != 0 {
+		t.Errorf("Changes to a fork shouldn't be synced to its forks, got %v", controller.editedMessageEmbeds)
+	}
+
+	//A cycle shouldn't be possible, but shouldn't hang if it happens
+	idf.NoteForkedMessage(forkOfFork.Reference(), original.Reference())
+	var descendants []string
+	for _, ref := range idf.MessageForkDescendants("400000000000000001", "500000000000000001") {
+		descendants = append(descendants, ref.MessageID)
+	}
+	if strings.Join(descendants, ",") != "500000000000000002,300000000000000001" {
+		t.Errorf("Expected each fork in the cycle once, got %v", descendants)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//The most messages the bot keeps in memory
+const MESSAGE_CACHE_SIZE = 1000
+
+//How long a cached message is trusted for. Events keep cached messages
+//current, but any missed while disconnected would leave them stale.
+const MESSAGE_CACHE_TTL = 10 * time.Minute
+
+type cachedMessage struct {
+	msg     *discordgo.Message
+	expires time.Time
+}
+
+//messageCache holds recently seen messages so that forking and syncing forks
+//doesn't have to fetch them again. messageCreate, messageUpdate and the
+//reaction events keep it current. Cached messages are shared, so they're
+//replaced rather than modified.
+type messageCache struct {
+	controller Controller
+	size       int
+	ttl        time.Duration
+	//Least recently put first, of *cachedMessage
+	order *list.List
+	//messageID -> element in order
+	elements map[string]*list.Element
+	mutex    sync.Mutex
+}
+
+func newMessageCache(controller Controller, size int, ttl time.Duration) *messageCache {
+	return &messageCache{
+		controller: controller,
+		size:       size,
+		ttl:        ttl,
+		order:      list.New(),
+		elements:   make(map[string]*list.Element),
+	}
+}
+
+//get returns the cached message, or nil if it isn't cached or has expired.
+func (c *messageCache) get(messageID string) *discordgo.Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.elements[messageID]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*cachedMessage)
+	if !c.controller.Now().Before(entry.expires) {
+		c.removeElement(element)
+		return nil
+	}
+	return entry.msg
+}
+
+//put caches msg, which should be the whole message, including its GuildID. If
+//the cache is full the least recently put message is dropped.
+func (c *messageCache) put(msg *discordgo.Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.putLocked(msg)
+}
+
+func (c *messageCache) putLocked(msg *discordgo.Message) {
+	entry := &cachedMessage{
+		msg:     msg,
+		expires: c.controller.Now().Add(c.ttl),
+	}
+	if element, ok := c.elements[msg.ID]; ok {
+		element.Value = entry
+		c.order.MoveToBack(element)
+		return
+	}
+	c.elements[msg.ID] = c.order.PushBack(entry)
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Front())
+	}
+}
+
+//update replaces the cached copy of msg, an edited message, and returns the
+//new copy. Edits don't include reactions, so it keeps the ones the cached copy
+//had. If msg isn't cached it returns nil and caches nothing, since without a
+//cached copy its reactions aren't known.
+func (c *messageCache) update(msg *discordgo.Message) *discordgo.Message {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.elements[msg.ID]
+	if !ok {
+		return nil
+	}
+	if msg.Reactions == nil {
+		updated := *msg
+		updated.Reactions = element.Value.(*cachedMessage).msg.Reactions
+		msg = &updated
+	}
+	c.putLocked(msg)
+	return msg
+}
+
+func (c *messageCache) remove(messageID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.elements[messageID]; ok {
+		c.removeElement(element)
+	}
+}
+
+//removeChannel drops every cached message in the channel.
+func (c *messageCache) removeChannel(channelID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, element := range c.elements {
+		if element.Value.(*cachedMessage).msg.ChannelID == channelID {
+			c.removeElement(element)
+		}
+	}
+}
+
+//clear drops every cached message, for when events might have been missed.
+func (c *messageCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.order.Init()
+	c.elements = make(map[string]*list.Element)
+}
+
+func (c *messageCache) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.elements, element.Value.(*cachedMessage).msg.ID)
+}
+
+//noteReaction applies a reaction being added (delta 1) or removed (delta -1)
+//to the cached copy of the message it's on, if there is one.
+func (c *messageCache) noteReaction(reaction *discordgo.MessageReaction, delta int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.elements[reaction.MessageID]
+	if !ok {
+		return
+	}
+	msg := *element.Value.(*cachedMessage).msg
+	var reactions []*discordgo.MessageReactions
+	found := false
+	for _, existing := range msg.Reactions {
+		if existing.Emoji == nil || existing.Emoji.Name != reaction.Emoji.Name || existing.Emoji.ID != reaction.Emoji.ID {
+			reactions = append(reactions, existing)
+			continue
+		}
+		found = true
+		changed := *existing
+		changed.Count += delta
+		if changed.Count > 0 {
+			reactions = append(reactions, &changed)
+		}
+	}
+	if !found && delta > 0 {
+		emoji := reaction.Emoji
+		reactions = append(reactions, &discordgo.MessageReactions{
+			Count: delta,
+			Emoji: &emoji,
+		})
+	}
+	msg.Reactions = reactions
+	element.Value = &cachedMessage{
+		msg:     &msg,
+		expires: element.Value.(*cachedMessage).expires,
+	}
+}
+
+//noteReactionsRemovedAll clears the reactions on the cached copy of the
+//message, if there is one.
+func (c *messageCache) noteReactionsRemovedAll(messageID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.elements[messageID]
+	if !ok {
+		return
+	}
+	entry := element.Value.(*cachedMessage)
+	msg := *entry.msg
+	msg.Reactions = nil
+	element.Value = &cachedMessage{
+		msg:     &msg,
+		expires: entry.expires,
+	}
+}
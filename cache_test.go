@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestMessageCache(t *testing.T) {
+	controller := &TestController{
+		now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	cache := newMessageCache(controller, 2, time.Minute)
+	message := func(id string, channelID string) *discordgo.Message {
+		return &discordgo.Message{
+			ID:        id,
+			ChannelID: channelID,
+			GuildID:   TEST_GUILD_ID,
+			Author:    &discordgo.User{ID: "user-1"},
+		}
+	}
+
+	cache.put(message("message-1", "channel-1"))
+	cache.put(message("message-2", "channel-1"))
+	cache.put(message("message-1", "channel-1"))
+	cache.put(message("message-3", "channel-2"))
+	if cache.get("message-2") != nil {
+		t.Errorf("Expected the least recently put message to be dropped when the cache was full")
+	}
+	if cache.get("message-1") == nil || cache.get("message-3") == nil {
+		t.Errorf("Expected the most recently put messages to be cached")
+	}
+
+	cache.removeChannel("channel-2")
+	if cache.get("message-3") != nil {
+		t.Errorf("Expected messages in a deleted channel to be dropped")
+	}
+
+	reaction := &discordgo.MessageReaction{
+		MessageID: "message-1",
+		ChannelID: "channel-1",
+		Emoji:     discordgo.Emoji{Name: "👍"},
+	}
+	before := cache.get("message-1")
+	cache.noteReaction(reaction, 1)
+	cache.noteReaction(reaction, 1)
+	msg := cache.get("message-1")
+	if len(msg.Reactions) != 1 || msg.Reactions[0].Count != 2 {
+		t.Errorf("Expected two reactions to be counted, got %v", msg.Reactions)
+	}
+	if len(before.Reactions) != 0 {
+		t.Errorf("Expected the previously cached message not to be modified, got %v", before.Reactions)
+	}
+
+	edited := message("message-1", "channel-1")
+	edited.Content = "edited"
+	if result := cache.update(edited); result.Content != "edited" || len(result.Reactions) != 1 || result.Reactions[0].Count != 2 {
+		t.Errorf("Expected an edit to keep the cached reactions, got %v with %v", result.Content, result.Reactions)
+	}
+
+	uncached := message("message-4", "channel-1")
+	uncached.Content = "edited"
+	if result := cache.update(uncached); result != nil || cache.get("message-4") != nil {
+		t.Errorf("Expected an edit of a message that isn't cached not to be cached without its reactions, got %v", result)
+	}
+
+	cache.noteReaction(reaction, -1)
+	cache.noteReaction(reaction, -1)
+	if msg := cache.get("message-1"); len(msg.Reactions) != 0 {
+		t.Errorf("Expected the reaction to be gone once its count reached 0, got %v", msg.Reactions)
+	}
+
+	cache.noteReaction(reaction, 1)
+	cache.noteReactionsRemovedAll("message-1")
+	if msg := cache.get("message-1"); len(msg.Reactions) != 0 {
+		t.Errorf("Expected every reaction to be removed, got %v", msg.Reactions)
+	}
+
+	controller.now = controller.now.Add(time.Minute)
+	if cache.get("message-1") != nil {
+		t.Errorf("Expected the message to expire")
+	}
+}
+
+func TestReactionSyncUsesCache(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	original := &discordgo.Message{
+		ID:        "300000000000000001",
+		ChannelID: "200000000000000001",
+		GuildID:   TEST_GUILD_ID,
+		Content:   "hello",
+		Author: &discordgo.User{
+			ID:       "user-1",
+			Username: "alice",
+		},
+	}
+	fork := &discordgo.Message{
+		ID:        "500000000000000001",
+		ChannelID: "400000000000000001",
+		GuildID:   TEST_GUILD_ID,
+		Embeds:    createForkMessageEmbeds(original),
+	}
+	controller := &TestController{
+		messages: []*discordgo.Message{fork, original},
+	}
+	bot := newBot(session, controller)
+	bot.settings[TEST_GUILD_ID] = newGuildSettings(TEST_GUILD_ID)
+	idf := newIDFIndex(TEST_GUILD_ID)
+	bot.indexes[TEST_GUILD_ID] = idf
+	if !idf.NoteForkMessage(fork) {
+		t.Fatalf("Expected the fork to be indexed")
+	}
+
+	bot.messageCreate(session, &discordgo.MessageCreate{Message: original})
+	for i := 0; i < 3; i++ {
+		bot.messageReactionAdd(session, &discordgo.MessageReactionAdd{
+			MessageReaction: &discordgo.MessageReaction{
+				UserID:    "user-2",
+				MessageID: original.ID,
+				ChannelID: original.ChannelID,
+				GuildID:   TEST_GUILD_ID,
+				Emoji:     discordgo.Emoji{Name: "👍"},
+			},
+		})
+	}
+	if controller.channelMessageCallCount != 0 {
+		t.Errorf("Expected reactions on a cached message not to fetch it, got %v fetches", controller.channelMessageCallCount)
+	}
+	embeds := controller.editedMessageEmbeds["400000000000000001+500000000000000001"]
+	if len(embeds) == 0 {
+		t.Fatalf("Expected the fork to be updated")
+	}
+	if len(embeds[0].Fields) == 0 || embeds[0].Fields[0].Value != "👍 : 3" {
+		t.Errorf("Expected the fork to show all three reactions, got %v", embeds[0].Fields)
+	}
+
+	bot.messageUpdate(session, &discordgo.MessageUpdate{
+		Message: &discordgo.Message{
+			ID:        original.ID,
+			ChannelID: original.ChannelID,
+			GuildID:   TEST_GUILD_ID,
+		},
+	})
+	if controller.channelMessageCallCount != 1 {
+		t.Errorf("Expected a partial update of a forked message to fetch it once, got %v fetches", controller.channelMessageCallCount)
+	}
+
+	//An edit of a message that isn't cached doesn't say how many reactions
+	//it has, so forks shouldn't be synced from it
+	bot.messageCache.clear()
+	edited := *original
+	edited.Content = "edited"
+	bot.messageUpdate(session, &discordgo.MessageUpdate{Message: &edited})
+	if controller.channelMessageCallCount != 2 {
+		t.Errorf("Expected an edit of an uncached forked message to fetch it, got %v fetches", controller.channelMessageCallCount)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//compactionResult describes what compactArchives did.
+type compactionResult struct {
+	ThreadsMoved        int
+	CategoriesDeleted   int
+	CategoriesRemaining int
+}
+
+func (c compactionResult) String() string {
+	return "moved " + strconv.Itoa(c.ThreadsMoved) + " threads, deleted " + strconv.Itoa(c.CategoriesDeleted) + " empty archive categories, " + strconv.Itoa(c.CategoriesRemaining) + " remain"
+}
+
+//archiveCategoriesOldestFirst returns the group's archive categories sorted by
+//their index, lowest first.
+func (g *threadGroupInfo) archiveCategoriesOldestFirst(session *discordgo.Session) ([]*discordgo.Channel, error) {
+	var result byArchiveIndex
+	for _, id := range g.archiveCategoryIDs {
+		category, err := session.State.Channel(id)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't fetch archive category %v: %w", id, err)
+		}
+		result = append(result, category)
+	}
+	//byArchiveIndex puts the highest index first
+	sort.Sort(sort.Reverse(result))
+	return result, nil
+}
+
+//compactArchives repacks the group's archived threads into as few archive
+//categories as possible, oldest threads in the lowest numbered categories, and
+//then deletes the archive categories that are left empty.
+func (g *threadGroupInfo) compactArchives(controller Controller, session *discordgo.Session) (compactionResult, error) {
+	var result compactionResult
+
+	categories, err := g.archiveCategoriesOldestFirst(session)
+	if err != nil {
+		return result, err
+	}
+	if len(categories) == 0 {
+		return result, nil
+	}
+
+	guild, err := session.State.Guild(categories[0].GuildID)
+	if err != nil {
+		return result, fmt.Errorf("couldn't fetch guild: %w", err)
+	}
+
+	isArchiveCategory := make(map[string]bool)
+	for _, category := range categories {
+		isArchiveCategory[category.ID] = true
+	}
+
+	var threads []*discordgo.Channel
+	for _, channel := range guild.Channels {
+		if isArchiveCategory[g.pendingMoves.parentID(channel)] {
+			threads = append(threads, channel)
+		}
+	}
+
+	sort.SliceStable(threads, func(i, j int) bool {
+		return g.archivedBefore(threads[i], threads[j])
+	})
+
+	categoriesNeeded := (len(threads) + MAX_CATEGORY_CHANNELS - 1) / MAX_CATEGORY_CHANNELS
+	if categoriesNeeded == 0 {
+		//Keep one around to archive into next time
+		categoriesNeeded = 1
+	}
+
+	for i, thread := range threads {
+		category := categories[i/MAX_CATEGORY_CHANNELS]
+		if g.pendingMoves.parentID(thread) == category.ID {
+			continue
+		}
+		_, err := controller.ChannelEditComplex(thread.ID, &discordgo.ChannelEdit{
+			ParentID: category.ID,
+			// Set the same permission overwrites so it will be synced
+			PermissionOverwrites: category.PermissionOverwrites,
+		})
+		if err != nil {
+			//Bail before deleting anything, since a category we were going to
+			//delete might still have threads in it.
+			return result, fmt.Errorf("couldn't move %v: %w", nameForThread(thread), err)
+		}
+		thread.ParentID = category.ID
+		g.pendingMoves.noteMoved(thread.ID, category.ID)
+		result.ThreadsMoved++
+	}
+
+	for _, category := range categories[:categoriesNeeded] {
+		if err := g.sortArchiveCategory(controller, session, category.ID); err != nil {
+			return result, fmt.Errorf("couldn't sort %v: %w", category.Name, err)
+		}
+	}
+
+	//Delete the highest numbered first, so that whatever happens the
+	//remaining categories are the lowest numbered ones.
+	remaining := categories
+	for len(remaining) > categoriesNeeded {
+		category := remaining[len(remaining)-1]
+		if _, err := controller.ChannelDelete(category.ID); err != nil {
+			g.useArchiveCategories(guild, remaining)
+			return result, fmt.Errorf("couldn't delete empty archive category %v: %w", category.Name, err)
+		}
+		remaining = remaining[:len(remaining)-1]
+		result.CategoriesDeleted++
+	}
+	g.useArchiveCategories(guild, remaining)
+
+	result.CategoriesRemaining = categoriesNeeded
+
+	return result, nil
+}
+
+//useArchiveCategories makes the group archive into categories, which are
+//sorted lowest index first, instead of the ones it had, working out which is
+//active the same way as when the group was first found.
+func (g *threadGroupInfo) useArchiveCategories(guild *discordgo.Guild, categories []*discordgo.Channel) {
+	g.archiveCategoryIDs = nil
+	for _, category := range categories {
+		g.archiveCategoryIDs = append([]string{category.ID}, g.archiveCategoryIDs...)
+	}
+	g.activeArchiveCategoryID = ""
+	if len(categories) == 0 {
+		return
+	}
+	newest := categories[len(categories)-1]
+	g.nextArchiveCategoryIndex = indexForThreadArchive(newest) + 1
+	if g.numThreadsInArchiveCategory(guild, newest.ID) < MAX_CATEGORY_CHANNELS {
+		g.activeArchiveCategoryID = newest.ID
+	}
+}
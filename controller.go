@@ -1,6 +1,11 @@
 package main
 
-import "github.com/bwmarrin/discordgo"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
 
 type Controller interface {
 	ChannelMessage(channelID, messageID string) (st *discordgo.Message, err error)
@@ -8,6 +13,21 @@ type Controller interface {
 	GuildChannelCreateComplex(guildID string, data discordgo.GuildChannelCreateData) (st *discordgo.Channel, err error)
 	ChannelEditComplex(channelID string, data *discordgo.ChannelEdit) (st *discordgo.Channel, err error)
 	GuildChannelsReorder(guildID string, channels []*discordgo.Channel) error
+	ChannelDelete(channelID string) (st *discordgo.Channel, err error)
+	ChannelMessageEditEmbeds(channelID, messageID string, embeds []*discordgo.MessageEmbed) (st *discordgo.Message, err error)
+	ChannelMessageDelete(channelID, messageID string) error
+	ChannelMessageEditComplex(m *discordgo.MessageEdit) (st *discordgo.Message, err error)
+	MessageReactions(channelID, messageID, emojiID string, limit int, beforeID, afterID string) (st []*discordgo.User, err error)
+	GuildMember(guildID, userID string) (st *discordgo.Member, err error)
+	ChannelWebhooks(channelID string) (st []*discordgo.Webhook, err error)
+	WebhookCreate(channelID, name, avatar string) (st *discordgo.Webhook, err error)
+	Webhook(webhookID string) (st *discordgo.Webhook, err error)
+	WebhookExecute(webhookID, token string, wait bool, data *discordgo.WebhookParams) (st *discordgo.Message, err error)
+	//Unlike session.WebhookMessageEdit, a non-nil but empty data.Embeds
+	//removes the message's embeds.
+	WebhookMessageEdit(webhookID, token, messageID string, data *discordgo.WebhookEdit) (st *discordgo.Message, err error)
+	//Now is the current time, overridable so tests can control the clock.
+	Now() time.Time
 }
 
 type DiscordController struct {
@@ -33,3 +53,75 @@ func (dc *DiscordController) ChannelEditComplex(channelID string, data *discordg
 func (dc *DiscordController) GuildChannelsReorder(guildID string, channels []*discordgo.Channel) error {
 	return dc.session.GuildChannelsReorder(guildID, channels)
 }
+
+func (dc *DiscordController) ChannelDelete(channelID string) (st *discordgo.Channel, err error) {
+	return dc.session.ChannelDelete(channelID)
+}
+
+func (dc *DiscordController) ChannelMessageEditEmbeds(channelID, messageID string, embeds []*discordgo.MessageEmbed) (st *discordgo.Message, err error) {
+	return dc.session.ChannelMessageEditEmbeds(channelID, messageID, embeds)
+}
+
+func (dc *DiscordController) ChannelMessageDelete(channelID, messageID string) error {
+	return dc.session.ChannelMessageDelete(channelID, messageID)
+}
+
+func (dc *DiscordController) ChannelMessageEditComplex(m *discordgo.MessageEdit) (st *discordgo.Message, err error) {
+	return dc.session.ChannelMessageEditComplex(m)
+}
+
+func (dc *DiscordController) MessageReactions(channelID, messageID, emojiID string, limit int, beforeID, afterID string) (st []*discordgo.User, err error) {
+	return dc.session.MessageReactions(channelID, messageID, emojiID, limit, beforeID, afterID)
+}
+
+func (dc *DiscordController) GuildMember(guildID, userID string) (st *discordgo.Member, err error) {
+	if member, err := dc.session.State.Member(guildID, userID); err == nil {
+		return member, nil
+	}
+	return dc.session.GuildMember(guildID, userID)
+}
+
+func (dc *DiscordController) ChannelWebhooks(channelID string) (st []*discordgo.Webhook, err error) {
+	return dc.session.ChannelWebhooks(channelID)
+}
+
+func (dc *DiscordController) WebhookCreate(channelID, name, avatar string) (st *discordgo.Webhook, err error) {
+	return dc.session.WebhookCreate(channelID, name, avatar)
+}
+
+func (dc *DiscordController) Webhook(webhookID string) (st *discordgo.Webhook, err error) {
+	return dc.session.Webhook(webhookID)
+}
+
+func (dc *DiscordController) WebhookExecute(webhookID, token string, wait bool, data *discordgo.WebhookParams) (st *discordgo.Message, err error) {
+	return dc.session.WebhookExecute(webhookID, token, wait, data)
+}
+
+func (dc *DiscordController) WebhookMessageEdit(webhookID, token, messageID string, data *discordgo.WebhookEdit) (st *discordgo.Message, err error) {
+	if data.Embeds == nil || len(data.Embeds) > 0 || len(data.Files) > 0 {
+		return dc.session.WebhookMessageEdit(webhookID, token, messageID, data)
+	}
+	//WebhookEdit omits empty Embeds, which Discord takes to mean leave them
+	//as they are, so send the request ourselves.
+	body := struct {
+		Content         string                            `json:"content,omitempty"`
+		Embeds          []*discordgo.MessageEmbed         `json:"embeds"`
+		AllowedMentions *discordgo.MessageAllowedMentions `json:"allowed_mentions,omitempty"`
+	}{
+		Content:         data.Content,
+		Embeds:          data.Embeds,
+		AllowedMentions: data.AllowedMentions,
+	}
+	response, err := dc.session.RequestWithBucketID("PATCH", discordgo.EndpointWebhookMessage(webhookID, token, messageID), body, discordgo.EndpointWebhookToken("", ""))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(response, &st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (dc *DiscordController) Now() time.Time {
+	return time.Now()
+}
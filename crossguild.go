@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//forkInbox returns the guild that sourceGuildID's admins chose to fork
+//messages to, and the thread group that that guild's admins chose to put them
+//in. Both have to agree, so that no guild can take another's messages. The
+//guild is "" if sourceGuildID didn't choose one, and the group is nil if that
+//guild doesn't accept forks from sourceGuildID.
+func (b *bot) forkInbox(sourceGuildID string) (string, *threadGroupInfo) {
+	targetGuildID := b.getGuildSettings(sourceGuildID).ForkDestinationGuild()
+	if targetGuildID == "" || targetGuildID == sourceGuildID {
+		return "", nil
+	}
+	//Only look at the settings of guilds the bot is in
+	groups := b.sortedThreadGroups(targetGuildID)
+	if len(groups) == 0 {
+		return targetGuildID, nil
+	}
+	name, ok := b.getGuildSettings(targetGuildID).ForkInboxGroup(sourceGuildID)
+	if !ok {
+		return targetGuildID, nil
+	}
+	for _, group := range groups {
+		if group.name == name {
+			return targetGuildID, group
+		}
+	}
+	return targetGuildID, nil
+}
+
+//forkToOtherGuild forks msg, plus the messages before it back to a
+//START_FORK_THREAD_EMOJI if there is one, into a new thread in the thread
+//group of the guild that this one forks to, if that guild accepts them.
+func (b *bot) forkToOtherGuild(ref *discordgo.MessageReference, userID string) (*discordgo.Channel, error) {
+	targetGuildID, group := b.forkInbox(ref.GuildID)
+	if targetGuildID == "" {
+		return nil, fmt.Errorf("this server doesn't fork to any other server. An admin can choose one with /%v %v:<server ID>", FORK_CONFIG_COMMAND_NAME, FORKS_TO_SERVER_OPTION_NAME)
+	}
+	if group == nil {
+		return nil, fmt.Errorf("the server %v doesn't accept forks from this one. An admin there can allow them with /%v %v:%v", targetGuildID, THREAD_GROUP_CONFIG_COMMAND_NAME, ACCEPT_FORKS_FROM_OPTION_NAME, ref.GuildID)
+	}
+
+	msg, err := b.channelMessage(ref)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch full message to fork: %v", err)
+	}
+
+	filteredMessages, limitReached, err := b.messagesToFork(msg, "")
+	if err != nil {
+		return nil, err
+	}
+
+	//Channel mentions don't work across servers, so link to it instead
+	intro := "Forking messages from https://discord.com/channels/" + ref.GuildID + "/" + ref.ChannelID + " in another server at the request of <@" + userID + ">. If you don't like the auto-generated title, you can change it." + forkLimitNote(limitReached)
+
+	fork, err := b.newPendingFork(ref.GuildID, userID, intro, filteredMessages, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	thread, err := b.createNewThread(targetGuildID, group, fork.title)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create thread: %v", err)
+	}
+
+	undo := newForkUndo(fork.guildID, fork.userID, nil)
+	undo.threadID = thread.ID
+	if err := b.forkMessage(thread.ID, fork.intro, undo, fork.messages...); err != nil {
+		return thread, fmt.Errorf("couldn't fork message: %v", err)
+	}
+	return thread, nil
+}
+
+func (b *bot) forkToOtherGuildInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	//Fetching and posting the messages can take longer than the 3 seconds we
+	//have to respond.
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: uint64(discordgo.MessageFlagsEphemeral),
+		},
+	})
+
+	var userID string
+	if event.Member != nil && event.Member.User != nil {
+		userID = event.Member.User.ID
+	}
+	ref := messageReference(event.GuildID, event.ChannelID, event.ApplicationCommandData().TargetID)
+
+	message := "Couldn't fork: "
+	if thread, err := b.forkToOtherGuild(ref, userID); err != nil {
+		message += err.Error()
+		fmt.Println(message)
+	} else {
+		message = "Forked to https://discord.com/channels/" + thread.GuildID + "/" + thread.ID
+	}
+
+	s.InteractionResponseEdit(s.State.User.ID, event.Interaction, &discordgo.WebhookEdit{
+		Content: message,
+	})
+}
+
+//otherLiveIDFIndexes returns the IDF indexes that are loaded for guilds other
+//than guildID.
+func (b *bot) otherLiveIDFIndexes(guildID string) []*IDFIndex {
+	var result []*IDFIndex
+	for otherGuildID, idf := range b.indexes {
+		if otherGuildID != guildID && idf != nil {
+			result = append(result, idf)
+		}
+	}
+	return result
+}
+
+//noteCrossGuildFork indexes msg, a fork, in the guild of the message it's a
+//fork of too, if that's a different guild, so that edits and reactions there
+//are synced to it.
+func (b *bot) noteCrossGuildFork(msg *discordgo.Message) error {
+	source := messageIsForkOf(msg)
+	if source == nil || source.GuildID == "" || source.GuildID == msg.GuildID {
+		return nil
+	}
+	idf, err := b.getLiveIDFIndex(source.GuildID)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch idf for the source's guild: %v", err)
+	}
+	idf.NoteForkMessage(msg)
+	idf.RequestPeristence()
+	return nil
+}
+
+//shareCrossGuildForks copies the forks of messages in guildID that other
+//guilds' indexes know about into guildID's index. Rebuilding an index only
+//finds forks in its own guild, so this finds the rest.
+func (b *bot) shareCrossGuildForks(guildID string) {
+	idf := b.indexes[guildID]
+	if idf == nil {
+		return
+	}
+	for _, other := range b.otherLiveIDFIndexes(guildID) {
+		idf.CopyForksFromGuild(other, guildID)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	TEST_SOURCE_GUILD_ID string = "100000000000000002"
+	TEST_TARGET_GUILD_ID string = "100000000000000003"
+)
+
+func TestCrossGuildForks(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	controller := &TestController{}
+	bot := newBot(session, controller)
+	sourceIDF := newIDFIndex(TEST_SOURCE_GUILD_ID)
+	targetIDF := newIDFIndex(TEST_TARGET_GUILD_ID)
+	bot.indexes[TEST_SOURCE_GUILD_ID] = sourceIDF
+	bot.indexes[TEST_TARGET_GUILD_ID] = targetIDF
+	bot.settings[TEST_SOURCE_GUILD_ID] = newGuildSettings(TEST_SOURCE_GUILD_ID)
+	bot.settings[TEST_TARGET_GUILD_ID] = newGuildSettings(TEST_TARGET_GUILD_ID)
+
+	source := &discordgo.Message{
+		ID:        "300000000000000001",
+		ChannelID: "200000000000000001",
+		GuildID:   TEST_SOURCE_GUILD_ID,
+		Content:   "hello",
+	}
+	fork := &discordgo.Message{
+		ID:        "500000000000000001",
+		ChannelID: "400000000000000001",
+		GuildID:   TEST_TARGET_GUILD_ID,
+		Embeds:    createForkMessageEmbeds(source),
+	}
+	//Discord would tell us about this via messageCreate in the target guild
+	if err := bot.noteMessageIfFork(fork); err != nil {
+		t.Fatalf("noteMessageIfFork returned an error: %v", err)
+	}
+	if forks := targetIDF.MessageForks("400000000000000001", "500000000000000001"); len(forks) != 0 {
+		t.Errorf("The fork shouldn't have forks, got %v", forks)
+	}
+	if ref := targetIDF.ForkSource("400000000000000001", "500000000000000001"); ref == nil || ref.GuildID != TEST_SOURCE_GUILD_ID {
+		t.Errorf("Expected the target guild to know where the fork came from, got %v", ref)
+	}
+	forks := sourceIDF.MessageForks("200000000000000001", "300000000000000001")
+	if len(forks) != 1 || forks[0].GuildID != TEST_TARGET_GUILD_ID {
+		t.Fatalf("Expected the source guild to know about the fork, got %v", forks)
+	}
+
+	source.Content = "hello again"
+	if err := bot.updateForkedMessages(source); err != nil {
+		t.Fatalf("updateForkedMessages returned an error: %v", err)
+	}
+	embeds := controller.editedMessageEmbeds["400000000000000001+500000000000000001"]
+	if len(embeds) == 0 || embeds[0].Description != "hello again" {
+		t.Errorf("Expected the fork in the other guild to be updated, got %v", embeds)
+	}
+
+	//As if the source guild's index was just rebuilt, which only finds forks
+	//in its own guild
+	sourceIDF = newIDFIndex(TEST_SOURCE_GUILD_ID)
+	bot.indexes[TEST_SOURCE_GUILD_ID] = sourceIDF
+	bot.shareCrossGuildForks(TEST_SOURCE_GUILD_ID)
+	if forks := sourceIDF.MessageForks("200000000000000001", "300000000000000001"); len(forks) != 1 || forks[0].MessageID != "500000000000000001" {
+		t.Errorf("Expected the rebuilt index to learn about the fork from the other guild, got %v", forks)
+	}
+
+	bot.messageDelete(session, &discordgo.MessageDelete{
+		Message: &discordgo.Message{
+			ID:        "500000000000000001",
+			ChannelID: "400000000000000001",
+			GuildID:   TEST_TARGET_GUILD_ID,
+		},
+	})
+	if forks := sourceIDF.MessageForks("200000000000000001", "300000000000000001"); len(forks) != 0 {
+		t.Errorf("Expected the deleted fork to be forgotten in the source guild too, got %v", forks)
+	}
+	if len(controller.editedMessageEmbeds) != 1 || strings.Join(controller.deletedMessageIDs, ",") != "" {
+		t.Errorf("Deleting a fork shouldn't change anything else")
+	}
+}
+
+func TestForkInbox(t *testing.T) {
+	const otherGuildID = "100000000000000004"
+	tests := []struct {
+		Description         string
+		DestinationGuildID  string
+		AcceptingGuildID    string
+		AcceptingGroup      string
+		ExpectedGuildID     string
+		ExpectedGroupName   string
+		ExpectedGroupExists bool
+	}{
+		{
+			"Neither side agreed",
+			"",
+			"",
+			"",
+			"",
+			"",
+			false,
+		},
+		{
+			"Only the target accepts",
+			"",
+			TEST_TARGET_GUILD_ID,
+			"inbox",
+			"",
+			"",
+			false,
+		},
+		{
+			"Only the source chose the target",
+			TEST_TARGET_GUILD_ID,
+			"",
+			"",
+			TEST_TARGET_GUILD_ID,
+			"",
+			false,
+		},
+		{
+			"Both agree",
+			TEST_TARGET_GUILD_ID,
+			TEST_TARGET_GUILD_ID,
+			"inbox",
+			TEST_TARGET_GUILD_ID,
+			"inbox",
+			true,
+		},
+		{
+			"A different guild accepts",
+			TEST_TARGET_GUILD_ID,
+			otherGuildID,
+			"inbox",
+			TEST_TARGET_GUILD_ID,
+			"",
+			false,
+		},
+		{
+			"The accepting group is gone",
+			TEST_TARGET_GUILD_ID,
+			TEST_TARGET_GUILD_ID,
+			"missing",
+			TEST_TARGET_GUILD_ID,
+			"",
+			false,
+		},
+		{
+			"The bot isn't in the chosen guild",
+			"100000000000000005",
+			TEST_TARGET_GUILD_ID,
+			"inbox",
+			"100000000000000005",
+			"",
+			false,
+		},
+	}
+	for i, test := range tests {
+		session, _ := discordgo.New(TEST_TOKEN)
+		bot := newBot(session, &TestController{})
+		for _, guildID := range []string{TEST_SOURCE_GUILD_ID, TEST_TARGET_GUILD_ID, otherGuildID} {
+			bot.settings[guildID] = newGuildSettings(guildID)
+			bot.infos[guildID] = categoryMap{
+				"category-" + guildID: {
+					name:             "inbox",
+					threadCategoryID: "category-" + guildID,
+				},
+			}
+		}
+		bot.settings[TEST_SOURCE_GUILD_ID].SetForkDestinationGuild(test.DestinationGuildID)
+		if test.AcceptingGuildID != "" {
+			bot.settings[test.AcceptingGuildID].SetForkInboxGroup(TEST_SOURCE_GUILD_ID, test.AcceptingGroup)
+		}
+		guildID, group := bot.forkInbox(TEST_SOURCE_GUILD_ID)
+		if guildID != test.ExpectedGuildID {
+			t.Errorf("Test %v (%v) expected guild %v, got %v", i, test.Description, test.ExpectedGuildID, guildID)
+		}
+		if (group != nil) != test.ExpectedGroupExists {
+			t.Errorf("Test %v (%v) expected a group to be %v, got %v", i, test.Description, test.ExpectedGroupExists, group)
+			continue
+		}
+		if group != nil && group.name != test.ExpectedGroupName {
+			t.Errorf("Test %v (%v) expected group %v, got %v", i, test.Description, test.ExpectedGroupName, group.name)
+		}
+	}
+}
+
+func TestForkToOtherGuild(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	controller := &TestController{
+		messages: []*discordgo.Message{
+			{
+				ID:        "300000000000000001",
+				ChannelID: "200000000000000001",
+				GuildID:   TEST_SOURCE_GUILD_ID,
+				Content:   "private",
+			},
+		},
+	}
+	bot := newBot(session, controller)
+	bot.settings[TEST_SOURCE_GUILD_ID] = newGuildSettings(TEST_SOURCE_GUILD_ID)
+	bot.settings[TEST_TARGET_GUILD_ID] = newGuildSettings(TEST_TARGET_GUILD_ID)
+	bot.infos[TEST_TARGET_GUILD_ID] = categoryMap{
+		"category-1": {
+			name:             "inbox",
+			threadCategoryID: "category-1",
+		},
+	}
+	ref := messageReference(TEST_SOURCE_GUILD_ID, "200000000000000001", "300000000000000001")
+
+	//A guild accepting forks isn't enough to get them
+	bot.settings[TEST_TARGET_GUILD_ID].SetForkInboxGroup(TEST_SOURCE_GUILD_ID, "inbox")
+	if _, err := bot.forkToOtherGuild(ref, "user-1"); err == nil || !strings.Contains(err.Error(), FORKS_TO_SERVER_OPTION_NAME) {
+		t.Errorf("Expected forking without choosing a server to explain how to choose one, got %v", err)
+	}
+
+	bot.settings[TEST_TARGET_GUILD_ID].RemoveForkInboxGroup(TEST_SOURCE_GUILD_ID)
+	bot.settings[TEST_SOURCE_GUILD_ID].SetForkDestinationGuild(TEST_TARGET_GUILD_ID)
+	if _, err := bot.forkToOtherGuild(ref, "user-1"); err == nil || !strings.Contains(err.Error(), ACCEPT_FORKS_FROM_OPTION_NAME) {
+		t.Errorf("Expected forking to a server that doesn't accept forks to explain how it can, got %v", err)
+	}
+
+	if controller.guildChannelCreateComplexCallCount != 0 || controller.channelMessageCallCount != 0 {
+		t.Errorf("Expected nothing to be fetched or created when a fork isn't allowed")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//These limits are all configured by discord.
+//https://discord.com/developers/docs/resources/channel#embed-object-embed-limits
+const MAX_EMBEDS_PER_MESSAGE = 10
+const MAX_EMBED_TITLE_LENGTH = 256
+const MAX_EMBED_DESCRIPTION_LENGTH = 4096
+const MAX_EMBED_FIELDS = 25
+const MAX_EMBED_FIELD_NAME_LENGTH = 256
+const MAX_EMBED_FIELD_VALUE_LENGTH = 1024
+const MAX_EMBED_FOOTER_LENGTH = 2048
+const MAX_EMBED_AUTHOR_NAME_LENGTH = 256
+
+//The max total characters across every embed in one message
+const MAX_EMBED_TOTAL_LENGTH = 6000
+
+//Room to leave in MAX_EMBED_TOTAL_LENGTH for the note about dropped embeds
+const DROPPED_EMBEDS_NOTE_LENGTH = 100
+
+//truncateString shortens s to at most max characters, ending in an ellipsis
+//if anything was cut.
+func truncateString(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+func isImageAttachment(attachment *discordgo.MessageAttachment) bool {
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		return true
+	}
+	if attachment.ContentType != "" {
+		return false
+	}
+	lowerName := strings.ToLower(attachment.Filename)
+	for _, extension := range []string{".png", ".jpg", ".jpeg", ".gif", ".webp"} {
+		if strings.HasSuffix(lowerName, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+//clampEmbed returns a copy of embed that a bot is allowed to send: within
+//Discord's limits and without the parts only Discord can set.
+func clampEmbed(embed *discordgo.MessageEmbed) *discordgo.MessageEmbed {
+	result := *embed
+	//Bots can only send rich embeds, and can't set videos or providers
+	result.Type = ""
+	result.Video = nil
+	result.Provider = nil
+	result.Title = truncateString(result.Title, MAX_EMBED_TITLE_LENGTH)
+	result.Description = truncateString(result.Description, MAX_EMBED_DESCRIPTION_LENGTH)
+	if result.Author != nil {
+		author := *result.Author
+		author.Name = truncateString(author.Name, MAX_EMBED_AUTHOR_NAME_LENGTH)
+		result.Author = &author
+	}
+	if result.Footer != nil {
+		footer := *result.Footer
+		footer.Text = truncateString(footer.Text, MAX_EMBED_FOOTER_LENGTH)
+		result.Footer = &footer
+	}
+	result.Fields = nil
+	for i, field := range embed.Fields {
+		if i >= MAX_EMBED_FIELDS {
+			break
+		}
+		result.Fields = append(result.Fields, &discordgo.MessageEmbedField{
+			Name:   truncateString(field.Name, MAX_EMBED_FIELD_NAME_LENGTH),
+			Value:  truncateString(field.Value, MAX_EMBED_FIELD_VALUE_LENGTH),
+			Inline: field.Inline,
+		})
+	}
+	return &result
+}
+
+//embedLength is how many characters of the embed count towards
+//MAX_EMBED_TOTAL_LENGTH.
+func embedLength(embed *discordgo.MessageEmbed) int {
+	result := len([]rune(embed.Title)) + len([]rune(embed.Description))
+	if embed.Author != nil {
+		result += len([]rune(embed.Author.Name))
+	}
+	if embed.Footer != nil {
+		result += len([]rune(embed.Footer.Text))
+	}
+	for _, field := range embed.Fields {
+		result += len([]rune(field.Name)) + len([]rune(field.Value))
+	}
+	return result
+}
+
+//limitEmbeds returns as many of the embeds, in order, as fit in one message,
+//and how many had to be left out. The first embed is always kept.
+func limitEmbeds(embeds []*discordgo.MessageEmbed, maxTotalLength int) ([]*discordgo.MessageEmbed, int) {
+	var result []*discordgo.MessageEmbed
+	total := 0
+	for i, embed := range embeds {
+		length := embedLength(embed)
+		if i > 0 && (len(result) >= MAX_EMBEDS_PER_MESSAGE || total+length > maxTotalLength) {
+			break
+		}
+		result = append(result, embed)
+		total += length
+	}
+	return result, len(embeds) - len(result)
+}
+
+//attachmentLinks returns a markdown list of links to the attachments, within
+//the length of one embed field.
+func attachmentLinks(attachments []*discordgo.MessageAttachment) string {
+	var links []string
+	for _, attachment := range attachments {
+		links = append(links, "["+attachment.Filename+"]("+attachment.URL+")")
+	}
+	return truncateString(strings.Join(links, "\n"), MAX_EMBED_FIELD_VALUE_LENGTH)
+}
+
+//createForkMessageEmbeds returns the embeds for a fork of msg: the main fork
+//embed from createForkMessageEmbed, followed by any other images and msg's own
+//embeds, as many as fit in one message. The first image is shown in the main
+//embed and files that aren't images are linked from it.
+func createForkMessageEmbeds(msg *discordgo.Message) []*discordgo.MessageEmbed {
+	main := createForkMessageEmbed(msg)
+	embeds := []*discordgo.MessageEmbed{main}
+
+	var files []*discordgo.MessageAttachment
+	for _, attachment := range msg.Attachments {
+		if !isImageAttachment(attachment) {
+			files = append(files, attachment)
+			continue
+		}
+		image := &discordgo.MessageEmbedImage{
+			URL: attachment.URL,
+		}
+		if main.Image == nil {
+			main.Image = image
+			continue
+		}
+		//Embeds with the same URL are shown by Discord as one gallery
+		embeds = append(embeds, &discordgo.MessageEmbed{
+			URL:   main.URL,
+			Image: image,
+		})
+	}
+	if len(files) > 0 && len(main.Fields) < MAX_EMBED_FIELDS {
+		main.Fields = append(main.Fields, &discordgo.MessageEmbedField{
+			Name:  "Attachments",
+			Value: attachmentLinks(files),
+		})
+	}
+
+	for _, embed := range msg.Embeds {
+		embeds = append(embeds, clampEmbed(embed))
+	}
+
+	embeds, dropped := limitEmbeds(embeds, MAX_EMBED_TOTAL_LENGTH-DROPPED_EMBEDS_NOTE_LENGTH)
+	if dropped > 0 && len(main.Fields) < MAX_EMBED_FIELDS {
+		main.Fields = append(main.Fields, &discordgo.MessageEmbedField{
+			Name:  "Not shown",
+			Value: strconv.Itoa(dropped) + " more images or embeds; see the original message",
+		})
+	}
+	return embeds
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		Description string
+		Input       string
+		Max         int
+		Expected    string
+	}{
+		{
+			"Short enough",
+			"hello",
+			5,
+			"hello",
+		},
+		{
+			"Too long",
+			"hello world",
+			5,
+			"hell…",
+		},
+		{
+			"Counts characters not bytes",
+			"héllo wörld",
+			7,
+			"héllo …",
+		},
+	}
+	for i, test := range tests {
+		result := truncateString(test.Input, test.Max)
+		if result != test.Expected {
+			t.Errorf("Test %v (%v) failed. Got %v, expected %v", i, test.Description, result, test.Expected)
+		}
+	}
+}
+
+func TestCreateForkMessageEmbeds(t *testing.T) {
+	manyEmbeds := make([]*discordgo.MessageEmbed, 12)
+	for i := range manyEmbeds {
+		manyEmbeds[i] = &discordgo.MessageEmbed{
+			Type:  discordgo.EmbedTypeLink,
+			Title: "Link preview",
+		}
+	}
+	tests := []struct {
+		Description         string
+		Message             *discordgo.Message
+		ExpectedEmbeds      int
+		ExpectedMainImage   string
+		ExpectedFields      []string
+		ExpectedDescription int
+	}{
+		{
+			"Text only",
+			&discordgo.Message{
+				Content: "hello",
+			},
+			1,
+			"",
+			nil,
+			5,
+		},
+		{
+			"Very long text",
+			&discordgo.Message{
+				Content: strings.Repeat("a", MAX_EMBED_DESCRIPTION_LENGTH+100),
+			},
+			1,
+			"",
+			nil,
+			MAX_EMBED_DESCRIPTION_LENGTH,
+		},
+		{
+			"Images and files",
+			&discordgo.Message{
+				Content: "look",
+				Attachments: []*discordgo.MessageAttachment{
+					{
+						Filename:    "notes.pdf",
+						URL:         "https://cdn.example.com/notes.pdf",
+						ContentType: "application/pdf",
+					},
+					{
+						Filename:    "first.png",
+						URL:         "https://cdn.example.com/first.png",
+						ContentType: "image/png",
+					},
+					{
+						Filename: "second.JPG",
+						URL:      "https://cdn.example.com/second.JPG",
+					},
+				},
+			},
+			2,
+			"https://cdn.example.com/first.png",
+			[]string{"Attachments"},
+			4,
+		},
+		{
+			"Reply",
+			&discordgo.Message{
+				Content: "agreed",
+				Type:    discordgo.MessageTypeReply,
+				MessageReference: &discordgo.MessageReference{
+					ChannelID: "200000000000000001",
+					MessageID: "300000000000000000",
+				},
+				ReferencedMessage: &discordgo.Message{
+					Content: "shall we?",
+				},
+			},
+			1,
+			"",
+			[]string{"In reply to"},
+			6,
+		},
+		{
+			"Too many original embeds",
+			&discordgo.Message{
+				Content: "links",
+				Embeds:  manyEmbeds,
+			},
+			MAX_EMBEDS_PER_MESSAGE,
+			"",
+			[]string{"Not shown"},
+			5,
+		},
+	}
+	for i, test := range tests {
+		test.Message.ID = "300000000000000001"
+		test.Message.ChannelID = "200000000000000001"
+		test.Message.GuildID = TEST_GUILD_ID
+		embeds := createForkMessageEmbeds(test.Message)
+		if len(embeds) != test.ExpectedEmbeds {
+			t.Errorf("Test %v (%v) expected %v embeds, got %v", i, test.Description, test.ExpectedEmbeds, len(embeds))
+			continue
+		}
+		main := embeds[0]
+		if messageIsForkOf(&discordgo.Message{Embeds: embeds}) == nil {
+			t.Errorf("Test %v (%v) should still be detected as a fork", i, test.Description)
+		}
+		var image string
+		if main.Image != nil {
+			image = main.Image.URL
+		}
+		if image != test.ExpectedMainImage {
+			t.Errorf("Test %v (%v) expected main image %v, got %v", i, test.Description, test.ExpectedMainImage, image)
+		}
+		var fields []string
+		for _, field := range main.Fields {
+			fields = append(fields, field.Name)
+		}
+		if strings.Join(fields, ",") != strings.Join(test.ExpectedFields, ",") {
+			t.Errorf("Test %v (%v) expected fields %v, got %v", i, test.Description, test.ExpectedFields, fields)
+		}
+		if length := len([]rune(main.Description)); length != test.ExpectedDescription {
+			t.Errorf("Test %v (%v) expected description of length %v, got %v", i, test.Description, test.ExpectedDescription, length)
+		}
+		for _, embed := range embeds[1:] {
+			if embed.Type != "" {
+				t.Errorf("Test %v (%v) copied an embed without making it rich", i, test.Description)
+			}
+		}
+	}
+}
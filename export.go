@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const EXPORTS_PATH = "exports"
+
+//This number should be incremented every time the format of the transcript
+//JSON changes.
+const TRANSCRIPT_JSON_FORMAT_VERSION = 1
+
+type transcriptForkJSON struct {
+	SourceURL  string `json:"sourceURL"`
+	AuthorName string `json:"authorName,omitempty"`
+	Content    string `json:"content"`
+}
+
+type transcriptMessageJSON struct {
+	ID          string    `json:"id"`
+	AuthorID    string    `json:"authorID,omitempty"`
+	AuthorName  string    `json:"authorName,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Content     string    `json:"content"`
+	Attachments []string  `json:"attachments,omitempty"`
+	//Set if the message is a fork of another message
+	Fork *transcriptForkJSON `json:"fork,omitempty"`
+}
+
+type transcriptJSON struct {
+	FormatVersion int       `json:"formatVersion"`
+	GuildID       string    `json:"guildID"`
+	ChannelID     string    `json:"channelID"`
+	ChannelName   string    `json:"channelName"`
+	ExportedAt    time.Time `json:"exportedAt"`
+	//Oldest first
+	Messages []*transcriptMessageJSON `json:"messages"`
+}
+
+//transcriptExporter writes transcripts of threads to disk. fetch is
+//overridable so tests don't hit the network.
+type transcriptExporter struct {
+	dir   string
+	fetch func(channel *discordgo.Channel) ([]*discordgo.Message, error)
+}
+
+func newTranscriptExporter(session *discordgo.Session, dir string) *transcriptExporter {
+	return &transcriptExporter{
+		dir: dir,
+		fetch: func(channel *discordgo.Channel) ([]*discordgo.Message, error) {
+			if channel.LastMessageID == "" {
+				//No messages in channel at all!
+				return nil, nil
+			}
+			return FetchAllMessagesForChannel(session, channel)
+		},
+	}
+}
+
+func newTranscript(channel *discordgo.Channel, messages []*discordgo.Message, exportedAt time.Time) *transcriptJSON {
+	result := &transcriptJSON{
+		FormatVersion: TRANSCRIPT_JSON_FORMAT_VERSION,
+		GuildID:       channel.GuildID,
+		ChannelID:     channel.ID,
+		ChannelName:   channel.Name,
+		ExportedAt:    exportedAt,
+	}
+	seen := make(map[string]bool)
+	for _, message := range messages {
+		//FetchAllMessagesForChannel might return the last message twice
+		if seen[message.ID] {
+			continue
+		}
+		seen[message.ID] = true
+		result.Messages = append(result.Messages, newTranscriptMessage(message))
+	}
+	sort.SliceStable(result.Messages, func(i, j int) bool {
+		return result.Messages[i].Timestamp.Before(result.Messages[j].Timestamp)
+	})
+	return result
+}
+
+func newTranscriptMessage(message *discordgo.Message) *transcriptMessageJSON {
+	result := &transcriptMessageJSON{
+		ID:      message.ID,
+		Content: message.Content,
+	}
+	if timestamp, err := discordgo.SnowflakeTimestamp(message.ID); err == nil {
+		result.Timestamp = timestamp.UTC()
+	}
+	if message.Author != nil {
+		result.AuthorID = message.Author.ID
+		result.AuthorName = message.Author.Username
+	}
+	for _, attachment := range message.Attachments {
+		result.Attachments = append(result.Attachments, attachment.URL)
+	}
+	if embed, _ := mainForkEmbed(message); embed != nil {
+		result.Fork = &transcriptForkJSON{
+			SourceURL: embed.URL,
+			Content:   embed.Description,
+		}
+		if embed.Author != nil {
+			result.Fork.AuthorName = embed.Author.Name
+		}
+	} else if ref := webhookForkSourceRef(message); ref != nil {
+		//A webhook fork's content is the original's, followed by the link back
+		//to it, and it's posted under the original author's name.
+		link := webhookForkLinkRegExp.FindStringIndex(message.Content)
+		result.Content = ""
+		result.Fork = &transcriptForkJSON{
+			SourceURL:  urlForMessageReference(ref),
+			Content:    strings.TrimSuffix(message.Content[:link[0]], "\n"),
+			AuthorName: result.AuthorName,
+		}
+	}
+	return result
+}
+
+//Markdown renders the transcript for humans to read. Forked messages are
+//rendered as quotes of the original.
+func (t *transcriptJSON) Markdown() string {
+	var builder strings.Builder
+	builder.WriteString("# #" + t.ChannelName + "\n\n")
+	builder.WriteString("Exported " + t.ExportedAt.Format(time.RFC3339) + " from channel " + t.ChannelID + "\n")
+	for _, message := range t.Messages {
+		author := message.AuthorName
+		if author == "" {
+			author = "Unknown"
+		}
+		builder.WriteString("\n**" + author + "** (" + message.Timestamp.Format(time.RFC3339) + ")\n")
+		if message.Content != "" {
+			builder.WriteString(message.Content + "\n")
+		}
+		if message.Fork != nil {
+			forkAuthor := message.Fork.AuthorName
+			if forkAuthor == "" {
+				forkAuthor = "Someone"
+			}
+			builder.WriteString("> [" + forkAuthor + " " + FORKED_MESSAGE_LINK_TEXT + "](" + message.Fork.SourceURL + ")\n")
+			for _, line := range strings.Split(message.Fork.Content, "\n") {
+				builder.WriteString("> " + line + "\n")
+			}
+		}
+		for _, attachment := range message.Attachments {
+			builder.WriteString("- " + attachment + "\n")
+		}
+	}
+	return builder.String()
+}
+
+func (e *transcriptExporter) pathForTranscript(guildID, channelID, extension string) string {
+	return filepath.Join(e.dir, guildID, channelID+extension)
+}
+
+//export fetches every message in the thread and writes Markdown and JSON
+//transcripts of it.
+func (e *transcriptExporter) export(thread *discordgo.Channel, exportedAt time.Time) (*transcriptJSON, error) {
+	messages, err := e.fetch(thread)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch messages: %w", err)
+	}
+	transcript := newTranscript(thread, messages, exportedAt)
+	if err := e.write(transcript); err != nil {
+		return nil, err
+	}
+	return transcript, nil
+}
+
+func (e *transcriptExporter) write(transcript *transcriptJSON) error {
+	folderPath := filepath.Join(e.dir, transcript.GuildID)
+	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(folderPath, 0700); err != nil {
+			return fmt.Errorf("couldn't create exports folder: %w", err)
+		}
+	}
+	blob, err := json.MarshalIndent(transcript, "", "\t")
+	if err != nil {
+		return fmt.Errorf("couldnt format json: %w", err)
+	}
+	if err := ioutil.WriteFile(e.pathForTranscript(transcript.GuildID, transcript.ChannelID, ".json"), blob, 0644); err != nil {
+		return fmt.Errorf("couldn't write json transcript: %w", err)
+	}
+	if err := ioutil.WriteFile(e.pathForTranscript(transcript.GuildID, transcript.ChannelID, ".md"), []byte(transcript.Markdown()), 0644); err != nil {
+		return fmt.Errorf("couldn't write markdown transcript: %w", err)
+	}
+	return nil
+}
+
+//verify reads the transcripts back from disk and returns an error if they
+//don't contain every message in the expected transcript.
+func (e *transcriptExporter) verify(expected *transcriptJSON) error {
+	blob, err := ioutil.ReadFile(e.pathForTranscript(expected.GuildID, expected.ChannelID, ".json"))
+	if err != nil {
+		return fmt.Errorf("couldn't read json transcript: %w", err)
+	}
+	var onDisk transcriptJSON
+	if err := json.Unmarshal(blob, &onDisk); err != nil {
+		return fmt.Errorf("couldn't parse json transcript: %w", err)
+	}
+	if onDisk.ChannelID != expected.ChannelID {
+		return fmt.Errorf("json transcript was for channel %v, expected %v", onDisk.ChannelID, expected.ChannelID)
+	}
+	if len(onDisk.Messages) != len(expected.Messages) {
+		return fmt.Errorf("json transcript had %v messages, expected %v", len(onDisk.Messages), len(expected.Messages))
+	}
+	for i, message := range expected.Messages {
+		if onDisk.Messages[i].ID != message.ID || onDisk.Messages[i].Content != message.Content {
+			return fmt.Errorf("json transcript message %v didn't match %v", i, message.ID)
+		}
+	}
+	markdown, err := ioutil.ReadFile(e.pathForTranscript(expected.GuildID, expected.ChannelID, ".md"))
+	if err != nil {
+		return fmt.Errorf("couldn't read markdown transcript: %w", err)
+	}
+	if string(markdown) != expected.Markdown() {
+		return fmt.Errorf("markdown transcript didn't match")
+	}
+	return nil
+}
+
+//verifyTranscriptIsComplete fetches the thread's messages again and returns an
+//error unless the transcript has as many messages and ends with the newest
+//one. The export relies on the thread's LastMessageID, which might be stale,
+//so this checks it didn't miss any before the thread is deleted. An empty
+//transcript is never considered complete.
+func verifyTranscriptIsComplete(controller Controller, thread *discordgo.Channel, transcript *transcriptJSON) error {
+	if len(transcript.Messages) == 0 {
+		return fmt.Errorf("transcript has no messages")
+	}
+	count := 0
+	newestID := ""
+	beforeID := ""
+	for {
+		//Most recent first
+		batch, err := controller.ChannelMessages(thread.ID, MESSAGES_TO_FETCH, beforeID, "", "")
+		if err != nil {
+			return fmt.Errorf("couldn't fetch messages: %w", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if newestID == "" {
+			newestID = batch[0].ID
+		}
+		count += len(batch)
+		beforeID = batch[len(batch)-1].ID
+		if len(batch) < MESSAGES_TO_FETCH {
+			break
+		}
+	}
+	if count != len(transcript.Messages) {
+		return fmt.Errorf("transcript had %v messages but the thread has %v", len(transcript.Messages), count)
+	}
+	if transcriptNewestID := transcript.Messages[len(transcript.Messages)-1].ID; transcriptNewestID != newestID {
+		return fmt.Errorf("transcript ends with %v but the thread's newest message is %v", transcriptNewestID, newestID)
+	}
+	return nil
+}
+
+//exportArchives writes transcripts of every archived thread in the group.
+func (g *threadGroupInfo) exportArchives(controller Controller, session *discordgo.Session, exporter *transcriptExporter) (int, error) {
+	threads, err := g.archivedThreadsNewestFirst(session)
+	if err != nil {
+		return 0, err
+	}
+	for i, thread := range threads {
+		if _, err := exporter.export(thread, controller.Now()); err != nil {
+			return i, fmt.Errorf("couldn't export %v: %w", nameForThread(thread), err)
+		}
+	}
+	return len(threads), nil
+}
+
+//archivedThreadsNewestFirst returns every thread in the group's archive
+//categories, most recently archived first.
+func (g *threadGroupInfo) archivedThreadsNewestFirst(session *discordgo.Session) ([]*discordgo.Channel, error) {
+	if len(g.archiveCategoryIDs) == 0 {
+		return nil, nil
+	}
+	category, err := session.State.Channel(g.archiveCategoryIDs[0])
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch archive category: %w", err)
+	}
+	guild, err := session.State.Guild(category.GuildID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch guild: %w", err)
+	}
+	isArchiveCategory := make(map[string]bool)
+	for _, id := range g.archiveCategoryIDs {
+		isArchiveCategory[id] = true
+	}
+	var threads []*discordgo.Channel
+	for _, channel := range guild.Channels {
+		if isArchiveCategory[g.pendingMoves.parentID(channel)] {
+			threads = append(threads, channel)
+		}
+	}
+	sort.SliceStable(threads, func(i, j int) bool {
+		return g.archivedBefore(threads[j], threads[i])
+	})
+	return threads, nil
+}
+
+//pruneArchives exports and then deletes the oldest archived threads beyond the
+//group's maxArchivedThreads. A thread is only deleted once its transcript has
+//been read back and verified, and checked against the messages in Discord.
+//Returns the number of threads deleted.
+func (g *threadGroupInfo) pruneArchives(controller Controller, session *discordgo.Session, exporter *transcriptExporter) (int, error) {
+	if g.maxArchivedThreads <= 0 {
+		return 0, nil
+	}
+	threads, err := g.archivedThreadsNewestFirst(session)
+	if err != nil {
+		return 0, err
+	}
+	if len(threads) <= g.maxArchivedThreads {
+		return 0, nil
+	}
+	deleted := 0
+	for _, thread := range threads[g.maxArchivedThreads:] {
+		transcript, err := exporter.export(thread, controller.Now())
+		if err != nil {
+			return deleted, fmt.Errorf("couldn't export %v: %w", nameForThread(thread), err)
+		}
+		if err := exporter.verify(transcript); err != nil {
+			return deleted, fmt.Errorf("not deleting %v since its export couldn't be verified: %w", nameForThread(thread), err)
+		}
+		if err := verifyTranscriptIsComplete(controller, thread, transcript); err != nil {
+			return deleted, fmt.Errorf("not deleting %v since its export might be missing messages: %w", nameForThread(thread), err)
+		}
+		fmt.Printf("Deleting archived thread %v since its group keeps only %v archived threads and its transcript was exported\n", nameForThread(thread), g.maxArchivedThreads)
+		g.pendingMoves.noteDeleting(thread.ID)
+		if _, err := controller.ChannelDelete(thread.ID); err != nil {
+			g.pendingMoves.takeDeleting(thread.ID)
+			return deleted, fmt.Errorf("couldn't delete %v: %w", nameForThread(thread), err)
+		}
+		g.settings.SetThreadArchiveTime(thread.ID, time.Time{})
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestTranscriptMarkdown(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	channel := &discordgo.Channel{
+		ID:      "200000000000000001",
+		Name:    "200000000000000001",
+		GuildID: TEST_GUILD_ID,
+	}
+	messages := []*discordgo.Message{
+		{
+			ID:      snowflakeForTime(now.Add(-time.Minute)),
+			Content: "Second",
+			Author: &discordgo.User{
+				ID:       "user-2",
+				Username: "bob",
+			},
+		},
+		{
+			ID:        snowflakeForTime(now.Add(-time.Minute * 30)),
+			WebhookID: "webhook",
+			Content:   "Third line\n[" + WEBHOOK_FORK_LINK_TEXT + "](<https://discord.com/channels/100000000000000001/200000000000000000/300000000000000001>)",
+			Author: &discordgo.User{
+				ID:       "webhook",
+				Username: "carol",
+			},
+		},
+		{
+			ID: snowflakeForTime(now.Add(-time.Hour)),
+			Author: &discordgo.User{
+				ID:       "bot",
+				Username: "flux-bot",
+			},
+			Embeds: []*discordgo.MessageEmbed{
+				{
+					Title:       FORKED_MESSAGE_LINK_TEXT,
+					Description: "First line\nSecond line",
+					URL:         "https://discord.com/channels/100000000000000001/200000000000000000/300000000000000000",
+					Author: &discordgo.MessageEmbedAuthor{
+						Name: "alice",
+					},
+				},
+			},
+		},
+	}
+	transcript := newTranscript(channel, messages, now)
+	if len(transcript.Messages) != 3 || transcript.Messages[0].Fork == nil || transcript.Messages[1].Fork == nil {
+		t.Fatalf("Expected the forked messages to come first, got %v", transcript.Messages)
+	}
+	markdown := transcript.Markdown()
+	expectedQuote := "> [alice " + FORKED_MESSAGE_LINK_TEXT + "](https://discord.com/channels/100000000000000001/200000000000000000/300000000000000000)\n> First line\n> Second line\n"
+	if !strings.Contains(markdown, expectedQuote) {
+		t.Errorf("Forked message should have been rendered as a quote, got:\n%v", markdown)
+	}
+	expectedWebhookQuote := "**carol** (" + transcript.Messages[1].Timestamp.Format(time.RFC3339) + ")\n> [carol " + FORKED_MESSAGE_LINK_TEXT + "](https://discord.com/channels/100000000000000001/200000000000000000/300000000000000001)\n> Third line\n"
+	if !strings.Contains(markdown, expectedWebhookQuote) {
+		t.Errorf("Webhook fork should have been rendered as a quote, got:\n%v", markdown)
+	}
+	if strings.Index(markdown, "First line") > strings.Index(markdown, "Second\n") {
+		t.Errorf("Messages should be oldest first, got:\n%v", markdown)
+	}
+}
+
+func TestPruneArchives(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	maxActiveThreads = 5
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	controller := &TestController{
+		now: now,
+	}
+	controller.session = session
+	bot := newBot(session, controller)
+	bot.exporter.dir = t.TempDir()
+	messageID := snowflakeForTime(now.Add(-time.Hour * 48))
+	//What Discord has in every thread
+	controller.messages = []*discordgo.Message{
+		{
+			ID: messageID,
+		},
+	}
+	failChannelID := ""
+	emptyChannelID := ""
+	bot.exporter.fetch = func(channel *discordgo.Channel) ([]*discordgo.Message, error) {
+		if channel.ID == failChannelID {
+			return nil, fmt.Errorf("fetch failed")
+		}
+		if channel.ID == emptyChannelID {
+			//As if its LastMessageID were missing
+			return nil, nil
+		}
+		return []*discordgo.Message{
+			{
+				ID:      messageID,
+				Content: "Hello from " + channel.Name,
+			},
+		}, nil
+	}
+	settings := newGuildSettings(TEST_GUILD_ID)
+	for i, id := range []string{"archived-1", "archived-2", "archived-3", "archived-4"} {
+		settings.SetThreadArchiveTime(id, now.Add(time.Hour*time.Duration(i-10)))
+	}
+	settings.SetMaxArchivedThreadsForGroup("", 1)
+	bot.settings[TEST_GUILD_ID] = settings
+	guild := &discordgo.Guild{
+		ID: TEST_GUILD_ID,
+	}
+	guild.Channels = []*discordgo.Channel{
+		{
+			ID:      "thread-archive-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_ARCHIVE_CATEGORY_NAME + " 1",
+			GuildID: TEST_GUILD_ID,
+		},
+		{
+			ID:      "thread-category",
+			Type:    discordgo.ChannelTypeGuildCategory,
+			Name:    THREAD_CATEGORY_NAME,
+			GuildID: TEST_GUILD_ID,
+		},
+	}
+	for _, id := range []string{"archived-1", "archived-2", "archived-3", "archived-4"} {
+		guild.Channels = append(guild.Channels, &discordgo.Channel{
+			ID:       id,
+			Type:     discordgo.ChannelTypeGuildText,
+			Name:     id,
+			GuildID:  TEST_GUILD_ID,
+			ParentID: "thread-archive-category",
+		})
+	}
+	session.State.GuildAdd(guild)
+	bot.guildCreate(session, &discordgo.GuildCreate{
+		Guild: guild,
+	})
+	group := bot.getInfos(TEST_GUILD_ID)["thread-category"]
+
+	//archived-2 is older than archived-3, so it is exported and deleted after it
+	failChannelID = "archived-2"
+	deleted, err := group.pruneArchives(controller, session, bot.exporter)
+	if err == nil {
+		t.Errorf("Expected an error when an export fails")
+	}
+	if deleted != 1 || len(controller.deletedChannelIDs) != 1 || controller.deletedChannelIDs[0] != "archived-3" {
+		t.Errorf("Only archived-3 should have been deleted before the failed export, got %v", controller.deletedChannelIDs)
+	}
+	if _, err := os.Stat(bot.exporter.pathForTranscript(TEST_GUILD_ID, "archived-2", ".json")); !os.IsNotExist(err) {
+		t.Errorf("No transcript should have been written for the failed export")
+	}
+
+	//Pretend Discord told us about the deletion
+	channel, _ := session.State.Channel("archived-3")
+	session.State.ChannelRemove(channel)
+	failChannelID = ""
+
+	emptyChannelID = "archived-2"
+	if _, err := group.pruneArchives(controller, session, bot.exporter); err == nil {
+		t.Errorf("Expected an error when an export is empty")
+	}
+	emptyChannelID = ""
+
+	//A message the export missed, as if it started at a stale LastMessageID
+	controller.messages = append([]*discordgo.Message{{ID: snowflakeForTime(now.Add(-time.Hour))}}, controller.messages...)
+	if _, err := group.pruneArchives(controller, session, bot.exporter); err == nil {
+		t.Errorf("Expected an error when an export is missing messages")
+	}
+	if len(controller.deletedChannelIDs) != 1 {
+		t.Errorf("Incomplete exports shouldn't be deleted, got %v", controller.deletedChannelIDs)
+	}
+	controller.messages = controller.messages[1:]
+
+	if _, err := group.pruneArchives(controller, session, bot.exporter); err != nil {
+		t.Errorf("pruneArchives returned an error: %v", err)
+	}
+	expectedDeleted := []string{"archived-3", "archived-2", "archived-1"}
+	if strings.Join(controller.deletedChannelIDs, ",") != strings.Join(expectedDeleted, ",") {
+		t.Errorf("Expected %v to be deleted, got %v", expectedDeleted, controller.deletedChannelIDs)
+	}
+	for _, id := range expectedDeleted {
+		for _, extension := range []string{".json", ".md"} {
+			if _, err := os.Stat(bot.exporter.pathForTranscript(TEST_GUILD_ID, id, extension)); err != nil {
+				t.Errorf("Expected a %v transcript for %v: %v", extension, id, err)
+			}
+		}
+	}
+	if _, ok := settings.ThreadArchiveTime("archived-1"); ok {
+		t.Errorf("Deleted threads shouldn't have an archive time anymore")
+	}
+}
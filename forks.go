@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//The most messages a fork lineage lists. Each is a line with a jump link, so
+//more than this wouldn't fit in a message anyway.
+const MAX_FORK_LINEAGE_MESSAGES = 20
+
+//Matches an ID, like a guild's
+var snowflakeRegExp = regexp.MustCompile(`^\d+$`)
+
+//Matches a link to a message, capturing the guild, channel and message IDs.
+var messageURLRegExp = regexp.MustCompile(`^<?https://(?:(?:ptb|canary)\.)?discord(?:app)?\.com/channels/(\d+)/(\d+)/(\d+)>?$`)
+
+//parseMessageURL returns the message that url links to, or nil if it isn't a
+//link to a message.
+func parseMessageURL(url string) *discordgo.MessageReference {
+	match := messageURLRegExp.FindStringSubmatch(strings.TrimSpace(url))
+	if match == nil {
+		return nil
+	}
+	return &discordgo.MessageReference{
+		GuildID:   match[1],
+		ChannelID: match[2],
+		MessageID: match[3],
+	}
+}
+
+//forkLineage is a message and everywhere it was forked to, including forks of
+//those forks.
+type forkLineage struct {
+	ref   *discordgo.MessageReference
+	forks []*forkLineage
+}
+
+//forkLineageForMessage returns the lineage of the original message that ref
+//was forked from, following forks of forks, or nil if ref isn't a fork and
+//hasn't been forked. It lists at most MAX_FORK_LINEAGE_MESSAGES messages.
+func forkLineageForMessage(idf *IDFIndex, ref *discordgo.MessageReference) *forkLineage {
+	root := ref
+	seen := map[string]bool{ref.MessageID: true}
+	for {
+		source := idf.referenceForkSource(root)
+		//The index shouldn't have cycles, but a corrupt cache could
+		if source == nil || seen[source.MessageID] {
+			break
+		}
+		seen[source.MessageID] = true
+		root = source
+	}
+	if root == ref && len(idf.referenceForks(ref)) == 0 {
+		return nil
+	}
+
+	count := 1
+	visited := map[string]bool{root.MessageID: true}
+	result := &forkLineage{ref: root}
+	queue := []*forkLineage{result}
+	//Breadth first so that if the lineage is too long it's the most distant
+	//forks that are left out.
+	for len(queue) > 0 && count < MAX_FORK_LINEAGE_MESSAGES {
+		node := queue[0]
+		queue = queue[1:]
+		for _, fork := range idf.referenceForks(node.ref) {
+			if visited[fork.MessageID] || count >= MAX_FORK_LINEAGE_MESSAGES {
+				continue
+			}
+			visited[fork.MessageID] = true
+			count++
+			child := &forkLineage{ref: fork}
+			node.forks = append(node.forks, child)
+			queue = append(queue, child)
+		}
+	}
+	return result
+}
+
+//describeForkLineage lists the lineage as a tree of jump links, one per line,
+//marking the one for ref.
+func describeForkLineage(lineage *forkLineage, ref *discordgo.MessageReference) []string {
+	var lines []string
+	var describe func(node *forkLineage, depth int)
+	describe = func(node *forkLineage, depth int) {
+		line := strings.Repeat("  ", depth) + "- " + urlForMessageReference(node.ref)
+		if depth == 0 {
+			line += " (original)"
+		}
+		if node.ref.MessageID == ref.MessageID {
+			line += " **← this message**"
+		}
+		lines = append(lines, line)
+		for _, fork := range node.forks {
+			describe(fork, depth+1)
+		}
+	}
+	describe(lineage, 0)
+	return lines
+}
+
+//joinLinesWithinLimit joins as many whole lines as fit in limit characters,
+//noting how many were left out, so that no jump link is cut in half.
+func joinLinesWithinLimit(lines []string, limit int) string {
+	result := strings.Join(lines, "\n")
+	if utf8.RuneCountInString(result) <= limit {
+		return result
+	}
+	for kept := len(lines) - 1; kept > 0; kept-- {
+		result = strings.Join(lines[:kept], "\n") + "\n…and " + strconv.Itoa(len(lines)-kept) + " more"
+		if utf8.RuneCountInString(result) <= limit {
+			return result
+		}
+	}
+	return truncateString(lines[0], limit)
+}
+
+//forksMessage describes where the message was forked to and what it's a fork
+//of.
+func forksMessage(idf *IDFIndex, ref *discordgo.MessageReference) string {
+	lineage := forkLineageForMessage(idf, ref)
+	if lineage == nil {
+		return "That message hasn't been forked, and isn't a fork"
+	}
+	forks := idf.referenceForks(ref)
+	noun := "times"
+	if len(forks) == 1 {
+		noun = "time"
+	}
+	var summary string
+	if source := idf.referenceForkSource(ref); source != nil {
+		summary = "That message is a fork of " + urlForMessageReference(source)
+		if len(forks) > 0 {
+			summary += ", and was forked " + strconv.Itoa(len(forks)) + " " + noun
+		}
+	} else {
+		summary = "That message was forked " + strconv.Itoa(len(forks)) + " " + noun
+	}
+	lines := append([]string{summary}, describeForkLineage(lineage, ref)...)
+	return joinLinesWithinLimit(lines, MAX_MESSAGE_CONTENT_LENGTH)
+}
+
+//forksInteraction handles both FORKS_COMMAND_NAME, which is given a link to
+//the message, and FORKS_CONTEXT_COMMAND_NAME, which is run on it.
+func (b *bot) forksInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	var ref *discordgo.MessageReference
+	message := "Couldn't list forks: "
+	if event.ApplicationCommandData().Name == FORKS_CONTEXT_COMMAND_NAME {
+		ref = messageReference(event.GuildID, event.ChannelID, event.ApplicationCommandData().TargetID)
+	} else if option := interactionOption(event, MESSAGE_OPTION_NAME); option != nil {
+		ref = parseMessageURL(option.StringValue())
+	}
+	switch {
+	case ref == nil:
+		message += "That isn't a link to a message. Right click a message and choose Copy Message Link to get one"
+	case ref.GuildID != event.GuildID:
+		message += "That message is in another server"
+	default:
+		if idf, err := b.getLiveIDFIndex(event.GuildID); err != nil {
+			message += err.Error()
+			fmt.Println(message)
+		} else {
+			message = forksMessage(idf, ref)
+		}
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+			Flags:   uint64(discordgo.MessageFlagsEphemeral),
+		},
+	})
+}
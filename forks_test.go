@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseMessageURL(t *testing.T) {
+	tests := []struct {
+		Description string
+		Input       string
+		Expected    *discordgo.MessageReference
+	}{
+		{
+			"Message link",
+			"https://discord.com/channels/1/2/3",
+			&discordgo.MessageReference{GuildID: "1", ChannelID: "2", MessageID: "3"},
+		},
+		{
+			"Without preview and with spaces",
+			" <https://ptb.discord.com/channels/1/2/3> ",
+			&discordgo.MessageReference{GuildID: "1", ChannelID: "2", MessageID: "3"},
+		},
+		{
+			"IDs that aren't snowflakes",
+			"https://discord.com/channels/guild-1/channel-1/message-1",
+			nil,
+		},
+		{
+			"Channel link",
+			"https://discord.com/channels/1/2",
+			nil,
+		},
+		{
+			"Other site",
+			"https://example.com/channels/1/2/3",
+			nil,
+		},
+		{
+			"Not a link",
+			"3",
+			nil,
+		},
+	}
+	for i, test := range tests {
+		result := parseMessageURL(test.Input)
+		if test.Expected == nil {
+			if result != nil {
+				t.Errorf("Test %v (%v) expected nil, got %v", i, test.Description, result)
+			}
+			continue
+		}
+		if result == nil || *result != *test.Expected {
+			t.Errorf("Test %v (%v) expected %v, got %v", i, test.Description, test.Expected, result)
+		}
+	}
+}
+
+func TestForkLineage(t *testing.T) {
+	idf := newIDFIndex(TEST_GUILD_ID)
+	original := messageReference(TEST_GUILD_ID, "200000000000000001", "300000000000000001")
+	fork := messageReference(TEST_GUILD_ID, "400000000000000001", "500000000000000001")
+	otherFork := messageReference(TEST_GUILD_ID, "400000000000000002", "500000000000000002")
+	forkOfFork := messageReference(TEST_GUILD_ID, "400000000000000003", "500000000000000003")
+	idf.NoteForkedMessage(original, fork)
+	idf.NoteForkedMessage(original, otherFork)
+	idf.NoteForkedMessage(fork, forkOfFork)
+
+	if source := idf.ForkSource("400000000000000003", "500000000000000003"); source == nil || source.MessageID != "500000000000000001" {
+		t.Errorf("Expected fork-3 to be a fork of fork-1, got %v", source)
+	}
+	if source := idf.ForkSource("200000000000000001", "300000000000000001"); source != nil {
+		t.Errorf("Expected the original not to be a fork, got %v", source)
+	}
+
+	expected := "That message is a fork of https://discord.com/channels/" + TEST_GUILD_ID + "/200000000000000001/300000000000000001, and was forked 1 time\n" +
+		"- https://discord.com/channels/" + TEST_GUILD_ID + "/200000000000000001/300000000000000001 (original)\n" +
+		"  - https://discord.com/channels/" + TEST_GUILD_ID + "/400000000000000001/500000000000000001 **← this message**\n" +
+		"    - https://discord.com/channels/" + TEST_GUILD_ID + "/400000000000000003/500000000000000003\n" +
+		"  - https://discord.com/channels/" + TEST_GUILD_ID + "/400000000000000002/500000000000000002"
+	if result := forksMessage(idf, fork); result != expected {
+		t.Errorf("Expected\n%v\ngot\n%v", expected, result)
+	}
+
+	if result := forksMessage(idf, messageReference(TEST_GUILD_ID, "200000000000000001", "300000000000000002")); result != "That message hasn't been forked, and isn't a fork" {
+		t.Errorf("Unexpected description of a message without forks: %v", result)
+	}
+
+	//A long chain of forks of forks is indented too deeply to fit in a message
+	var previous *discordgo.MessageReference
+	for i := 0; i < MAX_FORK_LINEAGE_MESSAGES; i++ {
+		id := strconv.Itoa(900000000000000000 + i)
+		next := messageReference("800000000000000000", id, id)
+		if previous != nil {
+			idf.NoteForkedMessage(previous, next)
+		}
+		previous = next
+	}
+	result := forksMessage(idf, previous)
+	if utf8.RuneCountInString(result) > MAX_MESSAGE_CONTENT_LENGTH {
+		t.Errorf("Expected a long lineage to fit in a message, got %v characters", utf8.RuneCountInString(result))
+	}
+	lines := strings.Split(result, "\n")
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, "…and ") || !strings.HasSuffix(last, " more") {
+		t.Errorf("Expected a long lineage to note how many forks were left out, got %v", last)
+	}
+	for _, line := range lines[1 : len(lines)-1] {
+		if parseMessageURL(strings.TrimSuffix(strings.TrimLeft(line, " -"), " (original)")) == nil {
+			t.Errorf("Expected only whole links, got %v", line)
+		}
+	}
+
+	//A cycle shouldn't be possible, but shouldn't hang if it happens
+	idf.NoteForkedMessage(forkOfFork, original)
+	if lineage := forkLineageForMessage(idf, fork); lineage == nil {
+		t.Errorf("Expected a lineage even with a cycle")
+	}
+
+	idf.NoteMessageDeleted("500000000000000001")
+	if source := idf.ForkSource("400000000000000003", "500000000000000003"); source != nil {
+		t.Errorf("Expected forks of a deleted message to forget their source, got %v", source)
+	}
+	if source := idf.ForkSource("400000000000000001", "500000000000000001"); source != nil {
+		t.Errorf("Expected a deleted fork to forget its source, got %v", source)
+	}
+
+	idf.NoteChannelDeleted("200000000000000001")
+	if source := idf.ForkSource("400000000000000002", "500000000000000002"); source != nil {
+		t.Errorf("Expected forks of a message in a deleted channel to forget their source, got %v", source)
+	}
+	if forks := idf.MessageForks("200000000000000001", "300000000000000001"); len(forks) != 0 {
+		t.Errorf("Expected the deleted channel's forks to be forgotten, got %v", forks)
+	}
+}
@@ -3,7 +3,7 @@ module github.com/jkomoros/flux-bot
 go 1.16
 
 require (
-	github.com/bwmarrin/discordgo v0.23.3-0.20210515023446-8dc42757bea5
+	github.com/bwmarrin/discordgo v0.24.0
 	github.com/dchest/stemmer v0.0.0-20161207102402-66719a20c4b5
 	github.com/kr/pretty v0.2.1 // indirect
 	github.com/workfit/tester v0.0.0-20190607030106-6b51fb84166e
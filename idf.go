@@ -36,33 +36,56 @@ const REBUILD_IDF_INTERVAL = time.Hour * 24
 
 //This number should be incremetned every time the format of the JSON cache
 //changes, so old caches will be discarded.
-const IDF_JSON_FORMAT_VERSION = 5
+const IDF_JSON_FORMAT_VERSION = 6
 
+//packedMessageReference is "guildID+channelID+messageID". Before version 6 of
+//the IDF JSON format it was "channelID+messageID".
 type packedMessageReference string
 
 const PACKED_MESSAGE_REFERENCE_DELIMITER = "+"
 
+//part returns the part of the reference that's fromEnd parts from the end, or
+//"" if it doesn't have that many.
+func (p packedMessageReference) part(fromEnd int) string {
+	parts := strings.Split(string(p), PACKED_MESSAGE_REFERENCE_DELIMITER)
+	if len(parts) <= fromEnd {
+		return ""
+	}
+	return parts[len(parts)-1-fromEnd]
+}
+
 func (p packedMessageReference) MessageID() string {
-	return strings.Split(string(p), PACKED_MESSAGE_REFERENCE_DELIMITER)[1]
+	return p.part(0)
 }
 
 func (p packedMessageReference) ChannelID() string {
-	return strings.Split(string(p), PACKED_MESSAGE_REFERENCE_DELIMITER)[0]
+	return p.part(1)
+}
+
+func (p packedMessageReference) GuildID() string {
+	return p.part(2)
 }
 
 func (p packedMessageReference) ToMessageReference() *discordgo.MessageReference {
 	parts := strings.Split(string(p), PACKED_MESSAGE_REFERENCE_DELIMITER)
-	if len(parts) != 2 {
+	if len(parts) != 3 {
 		return nil
 	}
 	return &discordgo.MessageReference{
-		ChannelID: parts[0],
-		MessageID: parts[1],
+		GuildID:   parts[0],
+		ChannelID: parts[1],
+		MessageID: parts[2],
 	}
 }
 
 func packMessageReference(ref *discordgo.MessageReference) packedMessageReference {
-	return packedMessageReference(ref.ChannelID + PACKED_MESSAGE_REFERENCE_DELIMITER + ref.MessageID)
+	return packedMessageReference(ref.GuildID + PACKED_MESSAGE_REFERENCE_DELIMITER + ref.ChannelID + PACKED_MESSAGE_REFERENCE_DELIMITER + ref.MessageID)
+}
+
+//upgradeVersion5PackedMessageReference adds the guild to a reference from
+//before version 6, when they didn't include one.
+func upgradeVersion5PackedMessageReference(guildID string, p packedMessageReference) packedMessageReference {
+	return packedMessageReference(guildID + PACKED_MESSAGE_REFERENCE_DELIMITER + string(p))
 }
 
 //STOP_WORDS are words that are so common that we should basically skip them. We
@@ -399,14 +422,20 @@ type idfIndexJSON struct {
 	FormatVersion      int                                                 `json:"formatVersion"`
 	ForkedMessageIndex map[packedMessageReference][]packedMessageReference `json:"forkedMessageIndex"`
 	GeneratedTimestamp time.Time                                           `json:"generatedTimestamp"`
+	//Fork --> ID of the webhook that posted it, for forks that were posted
+	//with a webhook instead of as an embed by the bot.
+	ForkWebhookIDs map[packedMessageReference]string `json:"forkWebhookIDs,omitempty"`
 }
 
 //IDFIndex stores information for calculating IDF of a thread. Get a new one
 //from NewIDFIndex.
 type IDFIndex struct {
-	data       *idfIndexJSON
-	guildID    string
-	futureSave *time.Timer
+	data    *idfIndexJSON
+	guildID string
+	//Fork --> the message it's a fork of. The reverse of
+	//data.ForkedMessageIndex, which is what's persisted.
+	forkSources map[packedMessageReference]packedMessageReference
+	futureSave  *time.Timer
 }
 
 //IDFIndexForGuild returns either a preexisting IDF index from disk cache or a
@@ -468,15 +497,56 @@ func LoadIDFIndex(guildID string) *IDFIndex {
 
 	result := fetchIDFBlob(guildID)
 
+	if result.FormatVersion == 5 {
+		upgradeIDFFromVersion5(guildID, result)
+	}
+
 	if result.FormatVersion != IDF_JSON_FORMAT_VERSION {
 		fmt.Printf("%v IDF cache file had old version %v, expected %v, discarding\n", guildID, result.FormatVersion, IDF_JSON_FORMAT_VERSION)
 		return nil
 	}
+	if result.ForkWebhookIDs == nil {
+		//Caches from before webhook forks existed won't have this
+		result.ForkWebhookIDs = make(map[packedMessageReference]string)
+	}
 	fmt.Printf("Reloading guild IDF cachce for %v\n", guildID)
 	return &IDFIndex{
-		data:    result,
-		guildID: guildID,
+		data:        result,
+		guildID:     guildID,
+		forkSources: forkSourcesForIndex(result.ForkedMessageIndex),
+	}
+}
+
+//upgradeIDFFromVersion5 adds the guild to every packedMessageReference in
+//data. Version 5 only indexed forks within a guild, so it's always guildID.
+func upgradeIDFFromVersion5(guildID string, data *idfIndexJSON) {
+	forkedMessageIndex := make(map[packedMessageReference][]packedMessageReference)
+	for from, tos := range data.ForkedMessageIndex {
+		var upgradedTos []packedMessageReference
+		for _, to := range tos {
+			upgradedTos = append(upgradedTos, upgradeVersion5PackedMessageReference(guildID, to))
+		}
+		forkedMessageIndex[upgradeVersion5PackedMessageReference(guildID, from)] = upgradedTos
+	}
+	data.ForkedMessageIndex = forkedMessageIndex
+	forkWebhookIDs := make(map[packedMessageReference]string)
+	for fork, webhookID := range data.ForkWebhookIDs {
+		forkWebhookIDs[upgradeVersion5PackedMessageReference(guildID, fork)] = webhookID
+	}
+	data.ForkWebhookIDs = forkWebhookIDs
+	data.FormatVersion = 6
+	fmt.Printf("Upgraded %v IDF cache from version 5\n", guildID)
+}
+
+//forkSourcesForIndex returns the reverse of forkedMessageIndex.
+func forkSourcesForIndex(forkedMessageIndex map[packedMessageReference][]packedMessageReference) map[packedMessageReference]packedMessageReference {
+	result := make(map[packedMessageReference]packedMessageReference)
+	for from, tos := range forkedMessageIndex {
+		for _, to := range tos {
+			result[to] = from
+		}
 	}
+	return result
 }
 
 //This is the max limit in the discord API. Otherwise it defaults to 0
@@ -522,11 +592,13 @@ func newIDFIndex(guildID string) *IDFIndex {
 		DocumentCount:      0,
 		DocumentWordCounts: make(map[string]int),
 		ForkedMessageIndex: make(map[packedMessageReference][]packedMessageReference),
+		ForkWebhookIDs:     make(map[packedMessageReference]string),
 		FormatVersion:      IDF_JSON_FORMAT_VERSION,
 	}
 	return &IDFIndex{
-		data:    data,
-		guildID: guildID,
+		data:        data,
+		guildID:     guildID,
+		forkSources: make(map[packedMessageReference]packedMessageReference),
 	}
 }
 
@@ -617,6 +689,16 @@ func (i *IDFIndex) DocumentCount() int {
 
 func (i *IDFIndex) NoteMessageDeleted(messageID string) {
 	//Very similar implementation in NoteChannelDeleted
+	for fork := range i.data.ForkWebhookIDs {
+		if fork.MessageID() == messageID {
+			delete(i.data.ForkWebhookIDs, fork)
+		}
+	}
+	for fork, source := range i.forkSources {
+		if fork.MessageID() == messageID || source.MessageID() == messageID {
+			delete(i.forkSources, fork)
+		}
+	}
 	for from, tos := range i.data.ForkedMessageIndex {
 		if from.MessageID() == messageID {
 			delete(i.data.ForkedMessageIndex, from)
@@ -645,6 +727,16 @@ func (i *IDFIndex) NoteMessageDeleted(messageID string) {
 
 func (i *IDFIndex) NoteChannelDeleted(channelID string) {
 	//Very similar implementation in NoteMessageDeleted
+	for fork := range i.data.ForkWebhookIDs {
+		if fork.ChannelID() == channelID {
+			delete(i.data.ForkWebhookIDs, fork)
+		}
+	}
+	for fork, source := range i.forkSources {
+		if fork.ChannelID() == channelID || source.ChannelID() == channelID {
+			delete(i.forkSources, fork)
+		}
+	}
 	for from, tos := range i.data.ForkedMessageIndex {
 		if from.ChannelID() == channelID {
 			delete(i.data.ForkedMessageIndex, from)
@@ -671,18 +763,108 @@ func (i *IDFIndex) NoteChannelDeleted(channelID string) {
 	}
 }
 
+//pack packs ref, which is assumed to be in this index's guild if it doesn't
+//say.
+func (i *IDFIndex) pack(ref *discordgo.MessageReference) packedMessageReference {
+	if ref.GuildID != "" {
+		return packMessageReference(ref)
+	}
+	return packMessageReference(messageReference(i.guildID, ref.ChannelID, ref.MessageID))
+}
+
 func (i *IDFIndex) NoteForkedMessage(from, to *discordgo.MessageReference) {
-	packedRef := packMessageReference(from)
-	i.data.ForkedMessageIndex[packedRef] = append(i.data.ForkedMessageIndex[packedRef], packMessageReference(to))
+	i.noteForkedMessage(i.pack(from), i.pack(to))
 }
 
-func (i *IDFIndex) MessageForks(channelID, messageID string) []*discordgo.MessageReference {
-	ref := &discordgo.MessageReference{
-		ChannelID: channelID,
-		MessageID: messageID,
+func (i *IDFIndex) noteForkedMessage(from, to packedMessageReference) {
+	for _, existing := range i.data.ForkedMessageIndex[from] {
+		if existing == to {
+			return
+		}
+	}
+	i.data.ForkedMessageIndex[from] = append(i.data.ForkedMessageIndex[from], to)
+	i.forkSources[to] = from
+}
+
+//ForkSource returns the message that the given message in this guild is a
+//fork of, or nil if it isn't a fork we know about.
+func (i *IDFIndex) ForkSource(channelID, messageID string) *discordgo.MessageReference {
+	return i.referenceForkSource(messageReference(i.guildID, channelID, messageID))
+}
+
+//referenceForkSource is ForkSource for a message that might be in another
+//guild.
+func (i *IDFIndex) referenceForkSource(ref *discordgo.MessageReference) *discordgo.MessageReference {
+	source, ok := i.forkSources[i.pack(ref)]
+	if !ok {
+		return nil
+	}
+	return source.ToMessageReference()
+}
+
+//CopyForksFromGuild notes the forks that other knows about of messages in
+//sourceGuildID. Forks are indexed by the guild they're in, so this is how the
+//guild a message is in learns about its forks in other guilds.
+func (i *IDFIndex) CopyForksFromGuild(other *IDFIndex, sourceGuildID string) {
+	for from, tos := range other.data.ForkedMessageIndex {
+		if from.GuildID() != sourceGuildID {
+			continue
+		}
+		for _, to := range tos {
+			i.noteForkedMessage(from, to)
+			if webhookID, ok := other.data.ForkWebhookIDs[to]; ok {
+				i.data.ForkWebhookIDs[to] = webhookID
+			}
+		}
+	}
+}
+
+//NoteForkMessage indexes message if it is a fork, returning true if it was.
+func (i *IDFIndex) NoteForkMessage(message *discordgo.Message) bool {
+	forkedFrom := messageIsForkOf(message)
+	if forkedFrom == nil {
+		return false
+	}
+	i.NoteForkedMessage(forkedFrom, message.Reference())
+	if message.WebhookID != "" {
+		i.data.ForkWebhookIDs[i.pack(message.Reference())] = message.WebhookID
+	}
+	return true
+}
+
+//ForkWebhookID returns the ID of the webhook that posted the fork, or "" if
+//the bot posted it itself.
+func (i *IDFIndex) ForkWebhookID(fork *discordgo.MessageReference) string {
+	return i.data.ForkWebhookIDs[i.pack(fork)]
+}
+
+//ChannelForks returns the forks of every message in the channel, other than
+//forks that are in the channel too.
+func (i *IDFIndex) ChannelForks(channelID string) []*discordgo.MessageReference {
+	var result []*discordgo.MessageReference
+	for from, tos := range i.data.ForkedMessageIndex {
+		if from.ChannelID() != channelID {
+			continue
+		}
+		for _, to := range tos {
+			if to.ChannelID() == channelID {
+				continue
+			}
+			result = append(result, to.ToMessageReference())
+		}
 	}
-	packedRef := packMessageReference(ref)
-	forks := i.data.ForkedMessageIndex[packedRef]
+	return result
+}
+
+//MessageForks returns the forks of the message in this guild, wherever they
+//are.
+func (i *IDFIndex) MessageForks(channelID, messageID string) []*discordgo.MessageReference {
+	return i.referenceForks(messageReference(i.guildID, channelID, messageID))
+}
+
+//referenceForks is MessageForks for a message that might be in another guild.
+func (i *IDFIndex) referenceForks(ref *discordgo.MessageReference) []*discordgo.MessageReference {
+	forks := i.data.ForkedMessageIndex[i.pack(ref)]
 	if len(forks) == 0 {
 		return nil
 	}
@@ -693,6 +875,37 @@ func (i *IDFIndex) MessageForks(channelID, messageID string) []*discordgo.Messag
 	return result
 }
 
+//MessageForkDescendants returns the forks of the message, the forks of those
+//forks, and so on, nearest first.
+func (i *IDFIndex) MessageForkDescendants(channelID, messageID string) []*discordgo.MessageReference {
+	return i.forkDescendants(i.MessageForks(channelID, messageID), map[string]bool{messageID: true})
+}
+
+//ChannelForkDescendants is ChannelForks plus the forks of those forks, and so
+//on, nearest first.
+func (i *IDFIndex) ChannelForkDescendants(channelID string) []*discordgo.MessageReference {
+	return i.forkDescendants(i.ChannelForks(channelID), make(map[string]bool))
+}
+
+//forkDescendants returns forks and their descendants, skipping visited
+//messages.
+func (i *IDFIndex) forkDescendants(forks []*discordgo.MessageReference, visited map[string]bool) []*discordgo.MessageReference {
+	var result []*discordgo.MessageReference
+	queue := forks
+	for len(queue) > 0 {
+		fork := queue[0]
+		queue = queue[1:]
+		//The index shouldn't have cycles, but a corrupt cache could
+		if visited[fork.MessageID] {
+			continue
+		}
+		visited[fork.MessageID] = true
+		result = append(result, fork)
+		queue = append(queue, i.referenceForks(fork)...)
+	}
+	return result
+}
+
 //ProcessMessage will process a given message and update the index.
 func (i *IDFIndex) ProcessMessage(message *discordgo.Message) {
 	if message == nil {
@@ -703,9 +916,7 @@ func (i *IDFIndex) ProcessMessage(message *discordgo.Message) {
 		return
 	}
 
-	if forkedFromMessageRef := messageIsForkOf(message); forkedFromMessageRef != nil {
-		i.NoteForkedMessage(forkedFromMessageRef, message.Reference())
-	}
+	i.NoteForkMessage(message)
 
 	words := extractWordsFromContent(message.Content)
 
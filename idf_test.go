@@ -100,6 +100,7 @@ func TestProcessMessage(t *testing.T) {
 			"rare":       1,
 		},
 		ForkedMessageIndex: map[packedMessageReference][]packedMessageReference{},
+		ForkWebhookIDs:     map[packedMessageReference]string{},
 		FormatVersion:      IDF_JSON_FORMAT_VERSION,
 	}
 	var messages []*discordgo.Message
@@ -180,3 +181,92 @@ func TestTFIDFTopWords(t *testing.T) {
 	assert.For(t).ThatActual(tfidf.TopWords(4)).Equals([]string{"two", "one", "three"})
 
 }
+
+func TestPackedMessageReference(t *testing.T) {
+	tests := []struct {
+		Description string
+		Packed      packedMessageReference
+		GuildID     string
+		ChannelID   string
+		MessageID   string
+	}{
+		{
+			"Guild qualified",
+			"guild-1+channel-1+message-1",
+			"guild-1",
+			"channel-1",
+			"message-1",
+		},
+		{
+			"From before version 6",
+			"channel-1+message-1",
+			"",
+			"channel-1",
+			"message-1",
+		},
+		{
+			"Malformed",
+			"message-1",
+			"",
+			"",
+			"message-1",
+		},
+		{
+			"Empty",
+			"",
+			"",
+			"",
+			"",
+		},
+	}
+	for i, test := range tests {
+		if test.Packed.GuildID() != test.GuildID || test.Packed.ChannelID() != test.ChannelID || test.Packed.MessageID() != test.MessageID {
+			t.Errorf("Test %v (%v) failed. Got %v/%v/%v", i, test.Description, test.Packed.GuildID(), test.Packed.ChannelID(), test.Packed.MessageID())
+		}
+	}
+
+	ref := messageReference("guild-1", "channel-1", "message-1")
+	assert.For(t).ThatActual(packMessageReference(ref).ToMessageReference()).Equals(ref)
+	if packedMessageReference("channel-1+message-1").ToMessageReference() != nil {
+		t.Errorf("References without a guild shouldn't be unpacked")
+	}
+}
+
+func TestUpgradeIDFFromVersion5(t *testing.T) {
+	data := &idfIndexJSON{
+		FormatVersion: 5,
+		ForkedMessageIndex: map[packedMessageReference][]packedMessageReference{
+			"channel-1+message-1": {"thread-1+fork-1", "thread-2+fork-2"},
+		},
+		ForkWebhookIDs: map[packedMessageReference]string{
+			"thread-2+fork-2": "webhook-1",
+		},
+	}
+	upgradeIDFFromVersion5("guild-1", data)
+	expected := &idfIndexJSON{
+		FormatVersion: IDF_JSON_FORMAT_VERSION,
+		ForkedMessageIndex: map[packedMessageReference][]packedMessageReference{
+			"guild-1+channel-1+message-1": {"guild-1+thread-1+fork-1", "guild-1+thread-2+fork-2"},
+		},
+		ForkWebhookIDs: map[packedMessageReference]string{
+			"guild-1+thread-2+fork-2": "webhook-1",
+		},
+	}
+	assert.For(t).ThatActual(data).Equals(expected).ThenDiffOnFail()
+
+	idf := &IDFIndex{
+		data:        data,
+		guildID:     "guild-1",
+		forkSources: forkSourcesForIndex(data.ForkedMessageIndex),
+	}
+	forks := idf.MessageForks("channel-1", "message-1")
+	if len(forks) != 2 || forks[0].GuildID != "guild-1" || forks[1].MessageID != "fork-2" {
+		t.Errorf("Expected upgraded forks to be found, got %v", forks)
+	}
+	if idf.ForkWebhookID(forks[1]) != "webhook-1" {
+		t.Errorf("Expected upgraded webhook fork to be found")
+	}
+	if source := idf.ForkSource("thread-1", "fork-1"); source == nil || source.MessageID != "message-1" {
+		t.Errorf("Expected upgraded fork's source to be found, got %v", source)
+	}
+}
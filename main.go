@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"syscall"
 
@@ -24,8 +25,17 @@ const THREAD_ARCHIVE_CATEGORY_NAME = THREAD_CATEGORY_NAME + " Archive"
 //Should be at least one smaller than MAX_CATEGORY_CHANNELS.
 const DEFAULT_MAX_ACTIVE_THREADS = 5
 
+//How many messages to look back through for the start of a fork by default.
+const DEFAULT_MAX_FORK_MESSAGES = 500
+
 //The max number of channels that Discord allows to be in a category channel. This is configured by discord.
 const MAX_CATEGORY_CHANNELS = 50
+
+//The max number of channels, including categories, that Discord allows in a guild. This is configured by discord.
+const MAX_GUILD_CHANNELS = 500
+
+//Start warning when a guild is within this many channels of MAX_GUILD_CHANNELS.
+const GUILD_CHANNEL_WARNING_MARGIN = MAX_CATEGORY_CHANNELS
 const EVERYONE_ROLE_NAME = "@everyone"
 
 var token string
@@ -33,9 +43,74 @@ var maxActiveThreads int
 var debugGuildIDForCommand string
 var useDebugIDFCache bool
 var disableEmojiFork bool
+var exportDir string
+var maxForkMessages int
 
 const ARCHIVE_COMMAND_NAME = "archive"
+const UNARCHIVE_COMMAND_NAME = "unarchive"
+const PIN_THREAD_COMMAND_NAME = "pin-thread"
+const UNPIN_THREAD_COMMAND_NAME = "unpin-thread"
 const SUGGEST_THREAD_NAME_COMMAND_NAME = "suggest-thread-name"
+const THREAD_GROUP_CONFIG_COMMAND_NAME = "thread-group-config"
+const COMPACT_ARCHIVES_COMMAND_NAME = "compact-archives"
+const EXPORT_ARCHIVES_COMMAND_NAME = "export-archives"
+const NEW_THREAD_COMMAND_NAME = "new-thread"
+const MOVE_THREAD_COMMAND_NAME = "move-thread"
+const FORK_CONFIG_COMMAND_NAME = "fork-config"
+const FORKS_COMMAND_NAME = "forks"
+
+//Message context menu commands are shown to users as-is, so they are capitalized
+const FORK_TO_EXISTING_THREAD_COMMAND_NAME = "Fork to existing thread"
+const FORK_TO_NEW_THREAD_COMMAND_NAME = "Fork to new thread"
+const FORK_TO_LATEST_COMMAND_NAME = "Fork from here to latest"
+const FORKS_CONTEXT_COMMAND_NAME = "Show forks"
+const FORK_TO_OTHER_SERVER_COMMAND_NAME = "Fork to another server"
+
+//Prefix of the CustomID of the thread picker shown by FORK_TO_EXISTING_THREAD_COMMAND_NAME
+const FORK_TO_EXISTING_THREAD_SELECT_ID = "fork-to-existing-thread"
+
+//Prefix of the CustomIDs of the buttons that page through the thread picker
+const FORK_TO_EXISTING_THREAD_PAGE_ID = "fork-to-existing-thread-page"
+
+//Prefix of the CustomID of the undo button on a fork's read out
+const UNDO_FORK_BUTTON_ID = "undo-fork"
+
+//Prefix of the CustomIDs of a fork preview's buttons and title modal
+const FORK_PREVIEW_ID = "fork-preview"
+
+const THREAD_OPTION_NAME = "thread"
+const GROUP_OPTION_NAME = "group"
+const MAX_THREADS_OPTION_NAME = "max-threads"
+const IDLE_DAYS_OPTION_NAME = "idle-days"
+const MAX_ARCHIVED_OPTION_NAME = "max-archived"
+const DEFAULT_GROUP_OPTION_NAME = "default"
+const ACCEPT_FORKS_FROM_OPTION_NAME = "accept-forks-from"
+const STOP_FORKS_FROM_OPTION_NAME = "stop-forks-from"
+const TITLE_OPTION_NAME = "title"
+const WEBHOOK_OPTION_NAME = "webhook"
+const ON_DELETE_OPTION_NAME = "on-delete"
+const REPLY_DEPTH_OPTION_NAME = "reply-depth"
+const UNDO_MINUTES_OPTION_NAME = "undo-minutes"
+const PREVIEW_OPTION_NAME = "preview"
+const MESSAGE_OPTION_NAME = "message"
+const QUORUM_OPTION_NAME = "quorum"
+const ALLOW_ROLE_OPTION_NAME = "allow-role"
+const DISALLOW_ROLE_OPTION_NAME = "disallow-role"
+const FORKING_HERE_OPTION_NAME = "forking-here"
+const COOLDOWN_MINUTES_OPTION_NAME = "cooldown-minutes"
+const FORKS_TO_SERVER_OPTION_NAME = "forks-to-server"
+
+//The value of FORKS_TO_SERVER_OPTION_NAME that stops forks to other servers
+const FORKS_TO_NO_SERVER = "none"
+
+//The max number of characters Discord allows in a channel name. This is configured by discord.
+const MAX_CHANNEL_NAME_LENGTH = 100
+
+//The max number of choices Discord allows in an autocomplete response. This is configured by discord.
+const MAX_AUTOCOMPLETE_CHOICES = 25
+
+//The max number of options Discord allows in a select menu. This is configured by discord.
+const MAX_SELECT_MENU_OPTIONS = 25
 
 var (
 	//When creating a command also update bot.interactionCreate to dispatch to the handler for the interaction
@@ -44,10 +119,238 @@ var (
 			Name:        ARCHIVE_COMMAND_NAME,
 			Description: "Archive the current thread forcibly (not waiting for it to fall off the end)",
 		},
+		{
+			Name:        UNARCHIVE_COMMAND_NAME,
+			Description: "Move an archived thread back into its active thread category",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         THREAD_OPTION_NAME,
+					Description:  "The archived thread to revive",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Name:        PIN_THREAD_COMMAND_NAME,
+			Description: "Keep the current thread in place and never archive it",
+		},
+		{
+			Name:        UNPIN_THREAD_COMMAND_NAME,
+			Description: "Let the current thread be reordered and archived like any other",
+		},
+		{
+			Name:        NEW_THREAD_COMMAND_NAME,
+			Description: "Start a new thread",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        TITLE_OPTION_NAME,
+					Description: "The title of the thread",
+					Required:    true,
+				},
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         GROUP_OPTION_NAME,
+					Description:  "The thread group to create it in. Defaults to the guild's default group",
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Name:        MOVE_THREAD_COMMAND_NAME,
+			Description: "Move the current thread into another thread group",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         GROUP_OPTION_NAME,
+					Description:  "The thread group to move it to",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Name:        FORK_CONFIG_COMMAND_NAME,
+			Description: "Show or change how messages are forked (requires Manage Channels)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        WEBHOOK_OPTION_NAME,
+					Description: "Post forks with the original author's name and avatar instead of as an embed",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        ON_DELETE_OPTION_NAME,
+					Description: "What to do to forks of a message when it is deleted",
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{
+							Name:  "Replace them with " + FORK_TOMBSTONE_TEXT,
+							Value: DELETED_SOURCE_FORK_TOMBSTONE,
+						},
+						{
+							Name:  "Delete them",
+							Value: DELETED_SOURCE_FORK_DELETE,
+						},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        REPLY_DEPTH_OPTION_NAME,
+					Description: "Also fork the messages that forked replies replied to, up to this many back. 0 turns this off",
+					MaxValue:    MAX_REPLY_CONTEXT_DEPTH,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        UNDO_MINUTES_OPTION_NAME,
+					Description: "How many minutes a fork can be undone for. 0 resets to the default",
+					MaxValue:    MAX_FORK_UNDO_MINUTES,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        PREVIEW_OPTION_NAME,
+					Description: "Show what will be forked, and let the title be changed, before creating a new thread",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        QUORUM_OPTION_NAME,
+					Description: "How many people have to react with " + FORK_THREAD_EMOJI + " before a message is forked. 0 resets to 1",
+					MaxValue:    MAX_FORK_QUORUM,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionRole,
+					Name:        ALLOW_ROLE_OPTION_NAME,
+					Description: "Let people with this role fork with " + FORK_THREAD_EMOJI + ". Once any role is allowed, only those roles can",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionRole,
+					Name:        DISALLOW_ROLE_OPTION_NAME,
+					Description: "Stop letting people with this role fork with " + FORK_THREAD_EMOJI,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        FORKING_HERE_OPTION_NAME,
+					Description: "Whether messages in this channel can be forked with " + FORK_THREAD_EMOJI,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        COOLDOWN_MINUTES_OPTION_NAME,
+					Description: "How many minutes each person has to wait between forks with " + FORK_THREAD_EMOJI + ". 0 turns this off",
+					MaxValue:    MAX_FORK_COOLDOWN_MINUTES,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        FORKS_TO_SERVER_OPTION_NAME,
+					Description: "The ID of the server messages can be forked to, if it accepts them, or " + FORKS_TO_NO_SERVER + " to stop",
+				},
+			},
+		},
+		{
+			Name:        FORKS_COMMAND_NAME,
+			Description: "List everywhere a message was forked to, and what it was forked from",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        MESSAGE_OPTION_NAME,
+					Description: "A link to the message",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name: FORK_TO_NEW_THREAD_COMMAND_NAME,
+			Type: discordgo.MessageApplicationCommand,
+		},
+		{
+			Name: FORK_TO_LATEST_COMMAND_NAME,
+			Type: discordgo.MessageApplicationCommand,
+		},
+		{
+			Name: FORK_TO_EXISTING_THREAD_COMMAND_NAME,
+			Type: discordgo.MessageApplicationCommand,
+		},
+		{
+			Name: FORKS_CONTEXT_COMMAND_NAME,
+			Type: discordgo.MessageApplicationCommand,
+		},
+		{
+			Name: FORK_TO_OTHER_SERVER_COMMAND_NAME,
+			Type: discordgo.MessageApplicationCommand,
+		},
 		{
 			Name:        SUGGEST_THREAD_NAME_COMMAND_NAME,
 			Description: "Suggests a thread title for this thread based on distinctive words in this thread",
 		},
+		{
+			Name:        THREAD_GROUP_CONFIG_COMMAND_NAME,
+			Description: "Show or change the settings for a group of threads (requires Manage Channels)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         GROUP_OPTION_NAME,
+					Description:  "The thread group to configure",
+					Required:     true,
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        MAX_THREADS_OPTION_NAME,
+					Description: "Number of active threads before old ones are archived. 0 resets to the default",
+					MaxValue:    MAX_CATEGORY_CHANNELS - 1,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        IDLE_DAYS_OPTION_NAME,
+					Description: "Archive threads with no new messages for this many days. 0 turns this off",
+					MaxValue:    MAX_IDLE_ARCHIVE_DAYS,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        MAX_ARCHIVED_OPTION_NAME,
+					Description: "Export and then delete the oldest archived threads beyond this many. 0 keeps them all",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        DEFAULT_GROUP_OPTION_NAME,
+					Description: "Make this the group that new threads go in when no group is given",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        ACCEPT_FORKS_FROM_OPTION_NAME,
+					Description: "The ID of another server the bot is in whose messages can be forked into this group",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        STOP_FORKS_FROM_OPTION_NAME,
+					Description: "The ID of a server whose messages can no longer be forked into this server",
+				},
+			},
+		},
+		{
+			Name:        COMPACT_ARCHIVES_COMMAND_NAME,
+			Description: "Repack archived threads into as few categories as possible (requires Manage Channels)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         GROUP_OPTION_NAME,
+					Description:  "Only compact this thread group's archives. Defaults to all groups",
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Name:        EXPORT_ARCHIVES_COMMAND_NAME,
+			Description: "Save Markdown and JSON transcripts of archived threads on the bot's server (requires Manage Channels)",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         GROUP_OPTION_NAME,
+					Description:  "Only export this thread group's archives. Defaults to all groups",
+					Autocomplete: true,
+				},
+			},
+		},
 	}
 )
 
@@ -57,6 +360,8 @@ func main() {
 	flag.StringVar(&debugGuildIDForCommand, "debug-guild-id", "", "The guild ID to register commands with, useful during testing since global commands take an hour to roll out")
 	flag.BoolVar(&useDebugIDFCache, "debug-idf-cache", false, "If true, will use a large IDF cache from production instead of rebuilding one")
 	flag.BoolVar(&disableEmojiFork, "disable-emoji-fork", false, "If true, then even when a 🧵 is encountered it won't fork a thread")
+	flag.IntVar(&maxForkMessages, "max-fork-messages", DEFAULT_MAX_FORK_MESSAGES, "The most messages a single fork will look back through and copy")
+	flag.StringVar(&exportDir, "export-dir", "", "The directory to write archived thread transcripts to. Defaults to "+filepath.Join(CACHE_PATH, EXPORTS_PATH))
 	flag.Parse()
 
 	if token == "" {
@@ -93,6 +398,16 @@ func main() {
 		maxActiveThreads = DEFAULT_MAX_ACTIVE_THREADS
 	}
 
+	if err := validateMaxActiveThreads(maxActiveThreads); err != nil {
+		fmt.Printf("Invalid max_active_threads: %v\n", err)
+		return
+	}
+
+	if maxForkMessages < 1 || maxForkMessages > MAX_FORK_MESSAGES {
+		fmt.Printf("max-fork-messages must be between 1 and %v, got %v\n", MAX_FORK_MESSAGES, maxForkMessages)
+		return
+	}
+
 	if debugGuildIDForCommand == "" {
 		debugGuildIDForCommand = os.Getenv(DEBUG_GUILD_ID_ENV_NAME)
 		if debugGuildIDForCommand != "" {
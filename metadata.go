@@ -0,0 +1,101 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//The version of the metadata that embed forks record about their source.
+//Increment it if the format changes, and keep parseForkMetadataFooter able to
+//read the older versions.
+const FORK_METADATA_VERSION = 1
+
+//Embed forks record their metadata in their main embed's footer, starting
+//with this.
+const FORK_METADATA_PREFIX = "flux-bot fork"
+
+//Matches an embed fork's footer, capturing the version, and the guild, channel
+//and message IDs of the source.
+var forkMetadataFooterRegExp = regexp.MustCompile(`^` + regexp.QuoteMeta(FORK_METADATA_PREFIX) + ` v(\d+) (\d+)/(\d+)/(\d+)$`)
+
+//forkMetadata is what a fork records about the message it's a fork of.
+type forkMetadata struct {
+	//0 for embed forks from before they recorded metadata, which are only
+	//recognized by their title and URL
+	version   int
+	guildID   string
+	channelID string
+	messageID string
+}
+
+func newForkMetadata(source *discordgo.Message) *forkMetadata {
+	return &forkMetadata{
+		version:   FORK_METADATA_VERSION,
+		guildID:   source.GuildID,
+		channelID: source.ChannelID,
+		messageID: source.ID,
+	}
+}
+
+func (m *forkMetadata) footerText() string {
+	return FORK_METADATA_PREFIX + " v" + strconv.Itoa(m.version) + " " + m.guildID + "/" + m.channelID + "/" + m.messageID
+}
+
+func (m *forkMetadata) reference() *discordgo.MessageReference {
+	return messageReference(m.guildID, m.channelID, m.messageID)
+}
+
+//parseForkMetadataFooter returns the metadata recorded in an embed fork's
+//footer text, or nil if text isn't fork metadata in a version we understand.
+func parseForkMetadataFooter(text string) *forkMetadata {
+	match := forkMetadataFooterRegExp.FindStringSubmatch(text)
+	if match == nil {
+		return nil
+	}
+	version, err := strconv.Atoi(match[1])
+	if err != nil || version < 1 || version > FORK_METADATA_VERSION {
+		return nil
+	}
+	return &forkMetadata{
+		version:   version,
+		guildID:   match[2],
+		channelID: match[3],
+		messageID: match[4],
+	}
+}
+
+//embedForkMetadata returns the metadata of the fork that embed is the main
+//embed of, or nil if it isn't one. Embeds from before forks recorded metadata
+//are recognized by FORKED_MESSAGE_LINK_TEXT and a link to their source.
+func embedForkMetadata(embed *discordgo.MessageEmbed) *forkMetadata {
+	if embed.Footer != nil {
+		if metadata := parseForkMetadataFooter(embed.Footer.Text); metadata != nil {
+			return metadata
+		}
+	}
+	if embed.Title != FORKED_MESSAGE_LINK_TEXT {
+		return nil
+	}
+	ref := parseMessageURL(embed.URL)
+	if ref == nil {
+		return nil
+	}
+	return &forkMetadata{
+		guildID:   ref.GuildID,
+		channelID: ref.ChannelID,
+		messageID: ref.MessageID,
+	}
+}
+
+//mainForkEmbed returns the embed of an embed fork that quotes its source, and
+//its metadata, or nils if message isn't an embed fork.
+func mainForkEmbed(message *discordgo.Message) (*discordgo.MessageEmbed, *forkMetadata) {
+	for _, embed := range message.Embeds {
+		if metadata := embedForkMetadata(embed); metadata != nil {
+			return embed, metadata
+		}
+	}
+	return nil, nil
+}
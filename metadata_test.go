@@ -0,0 +1,178 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestMessageIsForkOf(t *testing.T) {
+	source := &discordgo.Message{
+		ID:        "300000000000000001",
+		ChannelID: "200000000000000001",
+		GuildID:   TEST_GUILD_ID,
+		Content:   "hello",
+	}
+	footer := func(text string) *discordgo.MessageEmbedFooter {
+		return &discordgo.MessageEmbedFooter{Text: text}
+	}
+	tests := []struct {
+		Description string
+		Embeds      []*discordgo.MessageEmbed
+		Expected    *discordgo.MessageReference
+	}{
+		{
+			"Current fork",
+			createForkMessageEmbeds(source),
+			messageReference(TEST_GUILD_ID, "200000000000000001", "300000000000000001"),
+		},
+		{
+			"Metadata with a different title",
+			[]*discordgo.MessageEmbed{
+				{
+					Title:  "said:",
+					Footer: footer(FORK_METADATA_PREFIX + " v1 100000000000000001/200000000000000001/300000000000000001"),
+				},
+			},
+			messageReference("100000000000000001", "200000000000000001", "300000000000000001"),
+		},
+		{
+			"Metadata from a newer version",
+			[]*discordgo.MessageEmbed{
+				{
+					Footer: footer(FORK_METADATA_PREFIX + " v2 100000000000000001/200000000000000001/300000000000000001"),
+				},
+			},
+			nil,
+		},
+		{
+			"Metadata with a version that isn't a number",
+			[]*discordgo.MessageEmbed{
+				{
+					Footer: footer(FORK_METADATA_PREFIX + " vx 100000000000000001/200000000000000001/300000000000000001"),
+				},
+			},
+			nil,
+		},
+		{
+			"Metadata with IDs that aren't snowflakes",
+			[]*discordgo.MessageEmbed{
+				{
+					Footer: footer(FORK_METADATA_PREFIX + " v1 guild-1/channel-1/message-1"),
+				},
+			},
+			nil,
+		},
+		{
+			"Metadata missing IDs",
+			[]*discordgo.MessageEmbed{
+				{
+					Footer: footer(FORK_METADATA_PREFIX + " v1 200000000000000001/300000000000000001"),
+				},
+			},
+			nil,
+		},
+		{
+			"Metadata with extra IDs",
+			[]*discordgo.MessageEmbed{
+				{
+					Footer: footer(FORK_METADATA_PREFIX + " v1 100000000000000001/200000000000000001/300000000000000001/extra"),
+				},
+			},
+			nil,
+		},
+		{
+			"Fork from before metadata",
+			[]*discordgo.MessageEmbed{
+				{
+					Title: FORKED_MESSAGE_LINK_TEXT,
+					URL:   "https://discord.com/channels/100000000000000001/200000000000000001/300000000000000001",
+				},
+			},
+			messageReference("100000000000000001", "200000000000000001", "300000000000000001"),
+		},
+		{
+			"Fork from before metadata after an unrelated embed",
+			[]*discordgo.MessageEmbed{
+				{
+					Title:  "Something else",
+					Footer: footer("Posted by someone"),
+				},
+				{
+					Title: FORKED_MESSAGE_LINK_TEXT,
+					URL:   "https://discord.com/channels/100000000000000001/200000000000000001/300000000000000001",
+				},
+			},
+			messageReference("100000000000000001", "200000000000000001", "300000000000000001"),
+		},
+		{
+			"Fork from before metadata with a short URL",
+			[]*discordgo.MessageEmbed{
+				{
+					Title: FORKED_MESSAGE_LINK_TEXT,
+					URL:   "300000000000000001",
+				},
+			},
+			nil,
+		},
+		{
+			"Fork from before metadata with no URL",
+			[]*discordgo.MessageEmbed{
+				{
+					Title: FORKED_MESSAGE_LINK_TEXT,
+				},
+			},
+			nil,
+		},
+		{
+			"Fork from before metadata with a link to a channel",
+			[]*discordgo.MessageEmbed{
+				{
+					Title: FORKED_MESSAGE_LINK_TEXT,
+					URL:   "https://discord.com/channels/100000000000000001/200000000000000001",
+				},
+			},
+			nil,
+		},
+		{
+			"Unrelated embed",
+			[]*discordgo.MessageEmbed{
+				{
+					Title: "A link",
+					URL:   "https://discord.com/channels/100000000000000001/200000000000000001/300000000000000001",
+				},
+			},
+			nil,
+		},
+		{
+			"No embeds",
+			nil,
+			nil,
+		},
+	}
+	for i, test := range tests {
+		result := messageIsForkOf(&discordgo.Message{Embeds: test.Embeds})
+		if test.Expected == nil {
+			if result != nil {
+				t.Errorf("Test %v (%v) expected nil, got %v", i, test.Description, result)
+			}
+			continue
+		}
+		if result == nil || *result != *test.Expected {
+			t.Errorf("Test %v (%v) expected %v, got %v", i, test.Description, test.Expected, result)
+		}
+	}
+}
+
+func TestForkMetadataFooterText(t *testing.T) {
+	source := &discordgo.Message{
+		ID:        "300000000000000001",
+		ChannelID: "200000000000000001",
+		GuildID:   "100000000000000001",
+	}
+	metadata := newForkMetadata(source)
+	parsed := parseForkMetadataFooter(metadata.footerText())
+	if parsed == nil || *parsed != *metadata {
+		t.Errorf("Expected footer text to round trip to %v, got %v", metadata, parsed)
+	}
+}
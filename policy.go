@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//The most FORK_THREAD_EMOJI reactions a guild can require before forking
+const MAX_FORK_QUORUM = 25
+
+//The most reactors Discord returns at once
+const MAX_FORK_REACTORS_TO_FETCH = 100
+
+//The longest a guild can make people wait between emoji forks
+const MAX_FORK_COOLDOWN_MINUTES = 24 * 60
+
+//emojiForkPolicy decides whether userID reacting to msg with
+//FORK_THREAD_EMOJI should fork it, according to the guild's fork policy.
+//reason explains the decision either way.
+func (b *bot) emojiForkPolicy(msg *discordgo.Message, userID string) (allowed bool, reason string, err error) {
+	settings := b.getGuildSettings(msg.GuildID)
+	if settings.ForkingDisabledInChannel(msg.ChannelID) {
+		return false, "forking is disabled in this channel", nil
+	}
+	forked, err := b.messageWasForked(msg)
+	if err != nil {
+		return false, "", err
+	}
+	if forked {
+		return false, "it was already forked", nil
+	}
+	roleIDs := settings.ForkRoleIDs()
+	if len(roleIDs) > 0 {
+		hasRole, err := b.memberHasAnyRole(msg.GuildID, userID, roleIDs)
+		if err != nil {
+			return false, "", err
+		}
+		if !hasRole {
+			return false, "they don't have a role that's allowed to fork", nil
+		}
+	}
+	if cooldown := settings.ForkCooldown(); cooldown > 0 {
+		if last, ok := b.lastEmojiFork(msg.GuildID, userID); ok && b.controller.Now().Sub(last) < cooldown {
+			return false, "they forked less than " + strconv.Itoa(int(cooldown/time.Minute)) + " minutes ago", nil
+		}
+	}
+
+	var count int
+	if len(roleIDs) > 0 {
+		if count, err = b.countAllowedForkReactors(msg, roleIDs); err != nil {
+			return false, "", err
+		}
+	} else {
+		for _, reaction := range msg.Reactions {
+			if reaction.Emoji != nil && reaction.Emoji.Name == FORK_THREAD_EMOJI {
+				count = reaction.Count
+			}
+		}
+	}
+	quorum := settings.ForkQuorum()
+	if count < quorum {
+		return false, "it has " + strconv.Itoa(count) + " of the " + strconv.Itoa(quorum) + " " + FORK_THREAD_EMOJI + " needed", nil
+	}
+	return true, "it reached " + strconv.Itoa(quorum) + " " + FORK_THREAD_EMOJI, nil
+}
+
+func (b *bot) memberHasAnyRole(guildID, userID string, roleIDs []string) (bool, error) {
+	member, err := b.controller.GuildMember(guildID, userID)
+	if err != nil {
+		return false, fmt.Errorf("couldn't fetch member: %w", err)
+	}
+	for _, memberRoleID := range member.Roles {
+		for _, roleID := range roleIDs {
+			if memberRoleID == roleID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+//countAllowedForkReactors returns how many people with one of the roles
+//reacted to msg with FORK_THREAD_EMOJI.
+func (b *bot) countAllowedForkReactors(msg *discordgo.Message, roleIDs []string) (int, error) {
+	reactors, err := b.controller.MessageReactions(msg.ChannelID, msg.ID, FORK_THREAD_EMOJI, MAX_FORK_REACTORS_TO_FETCH, "", "")
+	if err != nil {
+		return 0, fmt.Errorf("couldn't fetch reactions: %w", err)
+	}
+	count := 0
+	for _, reactor := range reactors {
+		if reactor.Bot {
+			continue
+		}
+		hasRole, err := b.memberHasAnyRole(msg.GuildID, reactor.ID, roleIDs)
+		if err != nil {
+			//Perhaps they left; just don't count them
+			fmt.Printf("couldn't check roles of %v: %v\n", reactor.ID, err)
+			continue
+		}
+		if hasRole {
+			count++
+		}
+	}
+	return count, nil
+}
+
+//messageWasForked returns true if msg was forked via emoji since the bot
+//started, or has forks in the index. Reaction counts can't tell, since they
+//can drop and then reach the quorum again.
+func (b *bot) messageWasForked(msg *discordgo.Message) (bool, error) {
+	b.emojiForkTimesMutex.Lock()
+	forked := b.emojiForkedMessages[msg.ID]
+	b.emojiForkTimesMutex.Unlock()
+	if forked {
+		//Its forks might not have been indexed yet
+		return true, nil
+	}
+	idf, err := b.getLiveIDFIndex(msg.GuildID)
+	if err != nil {
+		return false, fmt.Errorf("couldn't get idf: %w", err)
+	}
+	return len(idf.MessageForks(msg.ChannelID, msg.ID)) > 0, nil
+}
+
+//noteEmojiForkedMessage records whether the message was forked via emoji.
+//It's set as soon as the fork is allowed, and unset if the fork is undone or
+//its preview cancelled, so it can be forked again.
+func (b *bot) noteEmojiForkedMessage(messageID string, forked bool) {
+	b.emojiForkTimesMutex.Lock()
+	defer b.emojiForkTimesMutex.Unlock()
+	if forked {
+		b.emojiForkedMessages[messageID] = true
+	} else {
+		delete(b.emojiForkedMessages, messageID)
+	}
+}
+
+func emojiForkKey(guildID, userID string) string {
+	return guildID + "+" + userID
+}
+
+func (b *bot) lastEmojiFork(guildID, userID string) (time.Time, bool) {
+	b.emojiForkTimesMutex.Lock()
+	defer b.emojiForkTimesMutex.Unlock()
+	last, ok := b.emojiForkTimes[emojiForkKey(guildID, userID)]
+	return last, ok
+}
+
+//noteEmojiFork records that userID just forked, for the guild's cooldown.
+func (b *bot) noteEmojiFork(guildID, userID string) {
+	b.emojiForkTimesMutex.Lock()
+	defer b.emojiForkTimesMutex.Unlock()
+	b.emojiForkTimes[emojiForkKey(guildID, userID)] = b.controller.Now()
+}
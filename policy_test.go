@@ -0,0 +1,229 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestEmojiForkPolicy(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	members := map[string]*discordgo.Member{
+		"mod":      {Roles: []string{"role-mod"}},
+		"mod-2":    {Roles: []string{"role-other", "role-mod"}},
+		"everyone": {},
+	}
+	reactors := []*discordgo.User{
+		{ID: "mod"},
+		{ID: "everyone"},
+		{ID: "bot", Bot: true},
+		{ID: "mod-2"},
+	}
+	tests := []struct {
+		Description  string
+		Configure    func(settings *GuildSettings)
+		Count        int
+		UserID       string
+		LastForkedAt time.Duration
+		Forked       bool
+		Expected     bool
+	}{
+		{
+			"Default first reaction",
+			nil,
+			1,
+			"everyone",
+			0,
+			false,
+			true,
+		},
+		{
+			"Default second reaction",
+			nil,
+			2,
+			"everyone",
+			0,
+			true,
+			false,
+		},
+		{
+			"Disabled channel",
+			func(settings *GuildSettings) {
+				settings.SetForkingDisabledInChannel("channel-1", true)
+			},
+			1,
+			"everyone",
+			0,
+			false,
+			false,
+		},
+		{
+			"Different channel disabled",
+			func(settings *GuildSettings) {
+				settings.SetForkingDisabledInChannel("channel-2", true)
+			},
+			1,
+			"everyone",
+			0,
+			false,
+			true,
+		},
+		{
+			"Below quorum",
+			func(settings *GuildSettings) {
+				settings.SetForkQuorum(3)
+			},
+			2,
+			"everyone",
+			0,
+			false,
+			false,
+		},
+		{
+			"At quorum",
+			func(settings *GuildSettings) {
+				settings.SetForkQuorum(3)
+			},
+			3,
+			"everyone",
+			0,
+			false,
+			true,
+		},
+		{
+			"Above quorum",
+			func(settings *GuildSettings) {
+				settings.SetForkQuorum(3)
+			},
+			4,
+			"everyone",
+			0,
+			true,
+			false,
+		},
+		{
+			"Back at quorum after being forked",
+			func(settings *GuildSettings) {
+				settings.SetForkQuorum(3)
+			},
+			3,
+			"everyone",
+			0,
+			true,
+			false,
+		},
+		{
+			"Above quorum without being forked",
+			func(settings *GuildSettings) {
+				settings.SetForkQuorum(3)
+			},
+			4,
+			"everyone",
+			0,
+			false,
+			true,
+		},
+		{
+			"User without role",
+			func(settings *GuildSettings) {
+				settings.SetForkRoleAllowed("role-mod", true)
+			},
+			1,
+			"everyone",
+			0,
+			false,
+			false,
+		},
+		{
+			"User with role reaching quorum of role holders",
+			func(settings *GuildSettings) {
+				settings.SetForkRoleAllowed("role-mod", true)
+				settings.SetForkQuorum(2)
+			},
+			4,
+			"mod-2",
+			0,
+			false,
+			true,
+		},
+		{
+			"Role holders above quorum",
+			func(settings *GuildSettings) {
+				settings.SetForkRoleAllowed("role-mod", true)
+			},
+			4,
+			"mod-2",
+			0,
+			true,
+			false,
+		},
+		{
+			"Within cooldown",
+			func(settings *GuildSettings) {
+				settings.SetForkCooldownMinutes(10)
+			},
+			1,
+			"everyone",
+			time.Minute * 5,
+			false,
+			false,
+		},
+		{
+			"After cooldown",
+			func(settings *GuildSettings) {
+				settings.SetForkCooldownMinutes(10)
+			},
+			1,
+			"everyone",
+			time.Minute * 15,
+			false,
+			true,
+		},
+	}
+	for i, test := range tests {
+		session, _ := discordgo.New(TEST_TOKEN)
+		controller := &TestController{
+			now:      now,
+			reactors: reactors,
+			members:  members,
+		}
+		bot := newBot(session, controller)
+		settings := newGuildSettings(TEST_GUILD_ID)
+		bot.settings[TEST_GUILD_ID] = settings
+		bot.indexes[TEST_GUILD_ID] = newIDFIndex(TEST_GUILD_ID)
+		if test.Configure != nil {
+			test.Configure(settings)
+		}
+		if test.LastForkedAt != 0 {
+			controller.now = now.Add(-test.LastForkedAt)
+			bot.noteEmojiFork(TEST_GUILD_ID, test.UserID)
+			controller.now = now
+		}
+		if test.Forked {
+			bot.noteEmojiForkedMessage("message-1", true)
+		}
+		msg := &discordgo.Message{
+			ID:        "message-1",
+			ChannelID: "channel-1",
+			GuildID:   TEST_GUILD_ID,
+			Reactions: []*discordgo.MessageReactions{
+				{
+					Count: test.Count,
+					Emoji: &discordgo.Emoji{Name: FORK_THREAD_EMOJI},
+				},
+			},
+		}
+		allowed, reason, err := bot.emojiForkPolicy(msg, test.UserID)
+		if err != nil {
+			t.Errorf("Test %v (%v) got unexpected error: %v", i, test.Description, err)
+			continue
+		}
+		if allowed != test.Expected {
+			t.Errorf("Test %v (%v) expected allowed to be %v, got %v because %v", i, test.Description, test.Expected, allowed, reason)
+		}
+		if reason == "" {
+			t.Errorf("Test %v (%v) expected a reason", i, test.Description)
+		}
+	}
+}
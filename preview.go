@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//How long a fork preview can be confirmed for
+const FORK_PREVIEW_TIMEOUT = time.Minute * 15
+
+//How many of the messages to be forked a preview lists
+const MAX_FORK_PREVIEW_MESSAGES = 10
+
+//How much of each message a preview quotes
+const MAX_FORK_PREVIEW_SNIPPET_LENGTH = 80
+
+//The actions in the CustomIDs of a fork preview's buttons and modal
+const FORK_PREVIEW_CONFIRM_ACTION = "confirm"
+const FORK_PREVIEW_RENAME_ACTION = "rename"
+const FORK_PREVIEW_CANCEL_ACTION = "cancel"
+const FORK_PREVIEW_TITLE_ACTION = "title"
+
+//pendingFork is a fork into a new thread that is ready to go, possibly
+//waiting for the user to confirm it.
+type pendingFork struct {
+	//Set once it's waiting for confirmation
+	id       string
+	guildID  string
+	userID   string
+	title    string
+	intro    string
+	messages []*discordgo.Message
+	//The message reacted to with FORK_THREAD_EMOJI, if that's how it was forked
+	trigger *discordgo.MessageReference
+	expires time.Time
+	//The preview posted in the channel, if it wasn't shown in response to an
+	//interaction. Guarded by bot.pendingForksMutex.
+	preview *discordgo.MessageReference
+}
+
+//forkPreviewContent lists the messages the fork will copy and the title its
+//thread will get.
+func forkPreviewContent(fork *pendingFork) string {
+	var lines []string
+	noun := "messages"
+	if len(fork.messages) == 1 {
+		noun = "message"
+	}
+	lines = append(lines, "Fork "+strconv.Itoa(len(fork.messages))+" "+noun+" into a new thread called **"+fork.title+"**?")
+	for i, msg := range fork.messages {
+		if i >= MAX_FORK_PREVIEW_MESSAGES {
+			lines = append(lines, "…and "+strconv.Itoa(len(fork.messages)-i)+" more")
+			break
+		}
+		name := "Someone"
+		if msg.Author != nil {
+			name = msg.Author.Username
+		}
+		lines = append(lines, "> **"+name+"**: "+truncateString(strings.Join(strings.Fields(msg.Content), " "), MAX_FORK_PREVIEW_SNIPPET_LENGTH))
+	}
+	return truncateString(strings.Join(lines, "\n"), MAX_MESSAGE_CONTENT_LENGTH)
+}
+
+func forkPreviewComponents(id string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Fork",
+					Style:    discordgo.PrimaryButton,
+					CustomID: FORK_PREVIEW_ID + ":" + FORK_PREVIEW_CONFIRM_ACTION + ":" + id,
+				},
+				discordgo.Button{
+					Label:    "Fork with another title",
+					Style:    discordgo.SecondaryButton,
+					CustomID: FORK_PREVIEW_ID + ":" + FORK_PREVIEW_RENAME_ACTION + ":" + id,
+				},
+				discordgo.Button{
+					Label:    "Cancel",
+					Style:    discordgo.DangerButton,
+					CustomID: FORK_PREVIEW_ID + ":" + FORK_PREVIEW_CANCEL_ACTION + ":" + id,
+				},
+			},
+		},
+	}
+}
+
+//notePendingFork gives the fork an id and keeps it until it's confirmed or
+//FORK_PREVIEW_TIMEOUT passes.
+func (b *bot) notePendingFork(fork *pendingFork) {
+	now := b.controller.Now()
+	fork.expires = now.Add(FORK_PREVIEW_TIMEOUT)
+	b.pendingForksMutex.Lock()
+	defer b.pendingForksMutex.Unlock()
+	for id, other := range b.pendingForks {
+		if now.After(other.expires) {
+			delete(b.pendingForks, id)
+		}
+	}
+	b.pendingForkCount++
+	fork.id = strconv.Itoa(b.pendingForkCount)
+	b.pendingForks[fork.id] = fork
+}
+
+//pendingFork returns the fork waiting for confirmation with the given id, or
+//nil if there isn't one. If take is true it also forgets it.
+func (b *bot) pendingFork(id string, take bool) *pendingFork {
+	b.pendingForksMutex.Lock()
+	defer b.pendingForksMutex.Unlock()
+	fork := b.pendingForks[id]
+	if fork == nil || b.controller.Now().After(fork.expires) {
+		delete(b.pendingForks, id)
+		return nil
+	}
+	if take {
+		delete(b.pendingForks, id)
+	}
+	return fork
+}
+
+//postForkPreview asks the user who reacted to ref to confirm the fork. There's
+//no way to show them alone a message without an interaction, so it's a reply
+//that only they can confirm. It's deleted once the fork is confirmed,
+//cancelled or expires.
+func (b *bot) postForkPreview(fork *pendingFork, ref *discordgo.MessageReference) error {
+	b.notePendingFork(fork)
+	data := &discordgo.MessageSend{
+		Content:    "<@" + fork.userID + "> " + forkPreviewContent(fork),
+		Reference:  ref,
+		Components: forkPreviewComponents(fork.id),
+		AllowedMentions: &discordgo.MessageAllowedMentions{
+			Users: []string{fork.userID},
+		},
+	}
+	preview, err := b.session.ChannelMessageSendComplex(ref.ChannelID, data)
+	if err != nil {
+		return fmt.Errorf("couldn't post fork preview: %w", err)
+	}
+	b.pendingForksMutex.Lock()
+	fork.preview = messageReference(ref.GuildID, preview.ChannelID, preview.ID)
+	b.pendingForksMutex.Unlock()
+	time.AfterFunc(FORK_PREVIEW_TIMEOUT, func() {
+		b.expireForkPreview(fork)
+	})
+	return nil
+}
+
+//takeForkPreview returns the fork's preview in the channel, if it has one
+//that hasn't been taken already, so that it's only deleted once.
+func (b *bot) takeForkPreview(fork *pendingFork) *discordgo.MessageReference {
+	b.pendingForksMutex.Lock()
+	defer b.pendingForksMutex.Unlock()
+	preview := fork.preview
+	fork.preview = nil
+	return preview
+}
+
+func (b *bot) deleteForkPreview(preview *discordgo.MessageReference) {
+	if err := b.controller.ChannelMessageDelete(preview.ChannelID, preview.MessageID); err != nil && restErrorCode(err) != discordgo.ErrCodeUnknownMessage {
+		fmt.Printf("couldn't delete fork preview %v: %v\n", preview.MessageID, err)
+	}
+}
+
+//expireForkPreview forgets the fork and deletes its preview, so that its
+//buttons don't linger in the channel after they stop working. If the fork
+//wasn't made, the message can be forked via emoji again.
+func (b *bot) expireForkPreview(fork *pendingFork) {
+	b.pendingFork(fork.id, true)
+	if preview := b.takeForkPreview(fork); preview != nil {
+		b.deleteForkPreview(preview)
+		b.forgetForkTrigger(fork.trigger)
+	}
+}
+
+//forgetForkTrigger lets the message that was reacted to, if any, be forked
+//via emoji again.
+func (b *bot) forgetForkTrigger(trigger *discordgo.MessageReference) {
+	if trigger != nil {
+		b.noteEmojiForkedMessage(trigger.MessageID, false)
+	}
+}
+
+func (b *bot) forkPreviewInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	var customID string
+	if event.Type == discordgo.InteractionModalSubmit {
+		customID = event.ModalSubmitData().CustomID
+	} else {
+		customID = event.MessageComponentData().CustomID
+	}
+	var userID string
+	if event.Member != nil && event.Member.User != nil {
+		userID = event.Member.User.ID
+	}
+	pieces := strings.Split(customID, ":")
+	var fork *pendingFork
+	if len(pieces) == 3 {
+		fork = b.pendingFork(pieces[2], false)
+	}
+
+	respond := func(message string) {
+		s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: message,
+				Flags:   uint64(discordgo.MessageFlagsEphemeral),
+			},
+		})
+	}
+
+	switch {
+	case fork == nil:
+		respond("This fork preview has expired. Fork the messages again to start over.")
+		return
+	case fork.userID != userID:
+		respond("Only <@" + fork.userID + "> can confirm this fork")
+		return
+	}
+
+	switch pieces[1] {
+	case FORK_PREVIEW_RENAME_ACTION:
+		s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseModal,
+			Data: &discordgo.InteractionResponseData{
+				CustomID: FORK_PREVIEW_ID + ":" + FORK_PREVIEW_TITLE_ACTION + ":" + fork.id,
+				Title:    "Fork to a new thread",
+				Components: []discordgo.MessageComponent{
+					discordgo.ActionsRow{
+						Components: []discordgo.MessageComponent{
+							discordgo.TextInput{
+								CustomID:  TITLE_OPTION_NAME,
+								Label:     "Thread title",
+								Style:     discordgo.TextInputShort,
+								Value:     fork.title,
+								Required:  true,
+								MaxLength: MAX_CHANNEL_NAME_LENGTH,
+							},
+						},
+					},
+				},
+			},
+		})
+		return
+	case FORK_PREVIEW_CANCEL_ACTION:
+		b.pendingFork(fork.id, true)
+		b.forgetForkTrigger(fork.trigger)
+		if preview := b.takeForkPreview(fork); preview != nil {
+			s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredMessageUpdate,
+			})
+			b.deleteForkPreview(preview)
+			return
+		}
+		s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    "Fork cancelled",
+				Components: []discordgo.MessageComponent{},
+			},
+		})
+		return
+	}
+
+	message := "Couldn't fork: "
+	if pieces[1] == FORK_PREVIEW_TITLE_ACTION {
+		title := sanitizeChannelName(modalTextInputValue(event.ModalSubmitData().Components, TITLE_OPTION_NAME))
+		if title == "" {
+			respond(message + "That title doesn't have any letters or numbers in it")
+			return
+		}
+		fork.title = title
+	}
+	if b.pendingFork(fork.id, true) == nil {
+		//Someone double clicked
+		respond(message + "It's already been confirmed")
+		return
+	}
+
+	//Creating the thread and posting the messages can take longer than the 3
+	//seconds we have to respond.
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+
+	if thread, err := b.forkToNewThread(fork); err != nil {
+		message += err.Error()
+		fmt.Println(message)
+	} else if preview := b.takeForkPreview(fork); preview != nil {
+		//The fork's read out says where it went, so the preview can go
+		b.deleteForkPreview(preview)
+		return
+	} else {
+		message = "Forked to <#" + thread.ID + ">"
+	}
+
+	s.InteractionResponseEdit(s.State.User.ID, event.Interaction, &discordgo.WebhookEdit{
+		Content:    message,
+		Components: []discordgo.MessageComponent{},
+	})
+}
+
+//modalTextInputValue returns the value of the text input with the given
+//CustomID in a submitted modal.
+func modalTextInputValue(components []discordgo.MessageComponent, customID string) string {
+	for _, component := range components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, inner := range row.Components {
+			if input, ok := inner.(*discordgo.TextInput); ok && input.CustomID == customID {
+				return input.Value
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestForkPreviewContent(t *testing.T) {
+	var manyMessages []*discordgo.Message
+	for i := 0; i < MAX_FORK_PREVIEW_MESSAGES+3; i++ {
+		manyMessages = append(manyMessages, &discordgo.Message{
+			Content: "Message " + strconv.Itoa(i),
+		})
+	}
+	tests := []struct {
+		Description string
+		Messages    []*discordgo.Message
+		Expected    string
+	}{
+		{
+			"One message",
+			[]*discordgo.Message{
+				{
+					Content: "Let's talk\nabout   this",
+					Author: &discordgo.User{
+						Username: "alice",
+					},
+				},
+			},
+			"Fork 1 message into a new thread called **talk-about-this**?\n> **alice**: Let's talk about this",
+		},
+		{
+			"Too many to list",
+			manyMessages,
+			"Fork 13 messages into a new thread called **talk-about-this**?\n> **Someone**: Message 0\n> **Someone**: Message 1\n> **Someone**: Message 2\n> **Someone**: Message 3\n> **Someone**: Message 4\n> **Someone**: Message 5\n> **Someone**: Message 6\n> **Someone**: Message 7\n> **Someone**: Message 8\n> **Someone**: Message 9\n…and 3 more",
+		},
+	}
+	for i, test := range tests {
+		fork := &pendingFork{
+			title:    "talk-about-this",
+			messages: test.Messages,
+		}
+		result := forkPreviewContent(fork)
+		if result != test.Expected {
+			t.Errorf("Test %v (%v) failed. Got %v, expected %v", i, test.Description, result, test.Expected)
+		}
+	}
+}
+
+func TestPendingForks(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	controller := &TestController{
+		now: now,
+	}
+	bot := newBot(session, controller)
+	first := &pendingFork{
+		title: "first",
+	}
+	second := &pendingFork{
+		title: "second",
+	}
+	bot.notePendingFork(first)
+	bot.notePendingFork(second)
+	if first.id == second.id {
+		t.Fatalf("Pending forks should have different ids")
+	}
+	if bot.pendingFork(first.id, false) != first {
+		t.Errorf("Expected to find the first pending fork")
+	}
+	if bot.pendingFork(first.id, true) != first || bot.pendingFork(first.id, false) != nil {
+		t.Errorf("Taking a pending fork should forget it")
+	}
+	controller.now = now.Add(FORK_PREVIEW_TIMEOUT + time.Minute)
+	if bot.pendingFork(second.id, true) != nil {
+		t.Errorf("Pending forks should expire")
+	}
+}
+
+func TestExpireForkPreview(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	controller := &TestController{}
+	bot := newBot(session, controller)
+	fork := &pendingFork{
+		title:   "fork",
+		preview: messageReference(TEST_GUILD_ID, "channel-1", "preview-1"),
+	}
+	bot.notePendingFork(fork)
+	bot.expireForkPreview(fork)
+	if bot.pendingFork(fork.id, false) != nil {
+		t.Errorf("An expired fork should be forgotten")
+	}
+	bot.expireForkPreview(fork)
+	if len(controller.deletedMessageIDs) != 1 || controller.deletedMessageIDs[0] != "preview-1" {
+		t.Errorf("Expected the preview to be deleted once, got %v", controller.deletedMessageIDs)
+	}
+
+	//Previews shown in response to an interaction aren't in the channel
+	ephemeral := &pendingFork{
+		title: "ephemeral",
+	}
+	bot.notePendingFork(ephemeral)
+	bot.expireForkPreview(ephemeral)
+	if len(controller.deletedMessageIDs) != 1 {
+		t.Errorf("A fork without a preview in the channel shouldn't delete anything, got %v", controller.deletedMessageIDs)
+	}
+}
+
+func TestModalTextInputValue(t *testing.T) {
+	components := []discordgo.MessageComponent{
+		&discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				&discordgo.TextInput{
+					CustomID: "other",
+					Value:    "nope",
+				},
+				&discordgo.TextInput{
+					CustomID: TITLE_OPTION_NAME,
+					Value:    "my title",
+				},
+			},
+		},
+	}
+	if value := modalTextInputValue(components, TITLE_OPTION_NAME); value != "my title" {
+		t.Errorf("Expected my title, got %v", value)
+	}
+	if value := modalTextInputValue(components, "missing"); value != "" {
+		t.Errorf("Expected nothing for a missing input, got %v", value)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//How long to wait for more reorders of the same category before sending one.
+//Busy threads get lots of messages in a row, and each would otherwise be its
+//own GuildChannelsReorder.
+const REORDER_DEBOUNCE_INTERVAL = time.Second * 2
+
+//reorderStats counts how many reorders were asked for versus how many we
+//actually sent to Discord.
+type reorderStats struct {
+	//Reorders that would have changed at least one position
+	Requested int
+	//Reorders that wouldn't have changed anything so were dropped immediately
+	SkippedNoOp int
+	//Reorders that were folded into one that was already scheduled
+	Coalesced int
+	//GuildChannelsReorder calls actually made
+	Sent int
+	//Channels included in those calls
+	ChannelsSent int
+	//Channels left out of those calls because their position didn't change
+	ChannelsUnchanged int
+}
+
+//CallsSaved is how many fewer GuildChannelsReorder calls we made than we would
+//have by sending one for every request.
+func (r reorderStats) CallsSaved() int {
+	return r.Requested + r.SkippedNoOp - r.Sent
+}
+
+func (r reorderStats) String() string {
+	return fmt.Sprintf("%v reorders requested, %v no-ops skipped, %v coalesced, %v sent (%v calls saved), %v channels sent, %v unchanged channels left out", r.Requested, r.SkippedNoOp, r.Coalesced, r.Sent, r.CallsSaved(), r.ChannelsSent, r.ChannelsUnchanged)
+}
+
+type pendingReorder struct {
+	guildID string
+	//The latest order we want for the category
+	channels []*discordgo.Channel
+	//channelID -> the position Discord had before this batch started
+	originalPositions map[string]int
+	timer             *time.Timer
+}
+
+//reorderScheduler debounces reorders per category and only sends the
+//channels whose position actually changes. Get one from newReorderScheduler.
+type reorderScheduler struct {
+	controller Controller
+	interval   time.Duration
+	mutex      sync.Mutex
+	//categoryID -> reorder waiting to be sent
+	pending map[string]*pendingReorder
+	stats   reorderStats
+}
+
+func newReorderScheduler(controller Controller, interval time.Duration) *reorderScheduler {
+	return &reorderScheduler{
+		controller: controller,
+		interval:   interval,
+		pending:    make(map[string]*pendingReorder),
+	}
+}
+
+//requestOrder asks for the channels in the category to end up in the given
+//order. It updates the Position of each channel right away so that later
+//reorders build on this one, but doesn't tell Discord until the category has
+//been quiet for the scheduler's interval.
+func (r *reorderScheduler) requestOrder(guildID, categoryID string, channels []*discordgo.Channel) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	changed := false
+	for i, channel := range channels {
+		if channel.Position != i {
+			changed = true
+			break
+		}
+	}
+
+	if !changed {
+		r.stats.SkippedNoOp++
+		return
+	}
+
+	r.stats.Requested++
+
+	pending := r.pending[categoryID]
+	if pending == nil {
+		pending = &pendingReorder{
+			guildID:           guildID,
+			originalPositions: make(map[string]int),
+		}
+		r.pending[categoryID] = pending
+	} else {
+		r.stats.Coalesced++
+	}
+
+	for i, channel := range channels {
+		if _, ok := pending.originalPositions[channel.ID]; !ok {
+			pending.originalPositions[channel.ID] = channel.Position
+		}
+		channel.Position = i
+	}
+	pending.channels = channels
+
+	if pending.timer != nil {
+		pending.timer.Stop()
+	}
+	pending.timer = time.AfterFunc(r.interval, func() {
+		if err := r.flush(categoryID); err != nil {
+			fmt.Printf("Couldn't reorder category %v: %v\n", categoryID, err)
+		}
+	})
+}
+
+//flush sends the pending reorder for the category, if there is one.
+func (r *reorderScheduler) flush(categoryID string) error {
+	r.mutex.Lock()
+	pending := r.pending[categoryID]
+	if pending == nil {
+		r.mutex.Unlock()
+		return nil
+	}
+	delete(r.pending, categoryID)
+	if pending.timer != nil {
+		pending.timer.Stop()
+	}
+
+	var changedChannels []*discordgo.Channel
+	for _, channel := range pending.channels {
+		if channel.ParentID != categoryID {
+			//Archived or moved since the reorder was requested
+			continue
+		}
+		if channel.Position == pending.originalPositions[channel.ID] {
+			continue
+		}
+		changedChannels = append(changedChannels, channel)
+	}
+
+	if len(changedChannels) == 0 {
+		//The batch ended up right back where it started
+		r.stats.SkippedNoOp++
+		r.mutex.Unlock()
+		return nil
+	}
+
+	r.stats.Sent++
+	r.stats.ChannelsSent += len(changedChannels)
+	r.stats.ChannelsUnchanged += len(pending.channels) - len(changedChannels)
+	stats := r.stats
+	r.mutex.Unlock()
+
+	if err := r.controller.GuildChannelsReorder(pending.guildID, changedChannels); err != nil {
+		return fmt.Errorf("couldn't reorder channels: %w", err)
+	}
+	fmt.Printf("Reordered %v channels in category %v. So far: %v\n", len(changedChannels), categoryID, stats)
+	return nil
+}
+
+//flushAll immediately sends every pending reorder.
+func (r *reorderScheduler) flushAll() {
+	r.mutex.Lock()
+	var categoryIDs []string
+	for categoryID := range r.pending {
+		categoryIDs = append(categoryIDs, categoryID)
+	}
+	r.mutex.Unlock()
+	for _, categoryID := range categoryIDs {
+		if err := r.flush(categoryID); err != nil {
+			fmt.Printf("Couldn't reorder category %v: %v\n", categoryID, err)
+		}
+	}
+}
+
+func (r *reorderScheduler) Stats() reorderStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//The furthest back a fork can be configured to follow a chain of replies
+const MAX_REPLY_CONTEXT_DEPTH = 10
+
+//How much of the message replied to is quoted in a fork
+const MAX_REPLY_SNIPPET_LENGTH = 100
+
+//repliedToReference returns the message that msg is a reply to, or nil if it
+//isn't a reply.
+func repliedToReference(msg *discordgo.Message) *discordgo.MessageReference {
+	if msg.Type != discordgo.MessageTypeReply || msg.MessageReference == nil || msg.MessageReference.MessageID == "" {
+		return nil
+	}
+	ref := *msg.MessageReference
+	if ref.GuildID == "" {
+		ref.GuildID = msg.GuildID
+	}
+	if ref.ChannelID == "" {
+		ref.ChannelID = msg.ChannelID
+	}
+	return &ref
+}
+
+func urlForMessageReference(ref *discordgo.MessageReference) string {
+	return "https://discord.com/channels/" + ref.GuildID + "/" + ref.ChannelID + "/" + ref.MessageID
+}
+
+//replyContext describes the message that msg replied to as a markdown link
+//to it, quoting the start of it if we have it. url is formatted by
+//formatURL. Returns "" if msg isn't a reply.
+func replyContext(msg *discordgo.Message, formatURL func(url string) string) string {
+	ref := repliedToReference(msg)
+	if ref == nil {
+		return ""
+	}
+	link := formatURL(urlForMessageReference(ref))
+	parent := msg.ReferencedMessage
+	if parent == nil {
+		return "[an earlier message](" + link + ")"
+	}
+	name := "Someone"
+	if parent.Author != nil {
+		name = parent.Author.Username
+	}
+	result := "[" + name + "](" + link + ")"
+	if parent.Content != "" {
+		result += ": " + truncateString(strings.Join(strings.Fields(parent.Content), " "), MAX_REPLY_SNIPPET_LENGTH)
+	}
+	return result
+}
+
+func messageTime(msg *discordgo.Message) time.Time {
+	timestamp, err := discordgo.SnowflakeTimestamp(msg.ID)
+	if err != nil {
+		return time.Time{}
+	}
+	return timestamp
+}
+
+//withReplyContext returns messages plus, for each one that's a reply, the
+//chain of messages it replied to, up to depth messages back, all oldest
+//first. It stops adding messages once there are maxForkMessages.
+func (b *bot) withReplyContext(messages []*discordgo.Message, depth int) []*discordgo.Message {
+	if depth <= 0 {
+		return messages
+	}
+	included := make(map[string]bool)
+	for _, msg := range messages {
+		included[msg.ID] = true
+	}
+	result := append([]*discordgo.Message{}, messages...)
+	for _, msg := range messages {
+		current := msg
+		for i := 0; i < depth && len(result) < maxForkMessages; i++ {
+			ref := repliedToReference(current)
+			if ref == nil || included[ref.MessageID] {
+				//If it's already included its own replies are followed
+				//separately
+				break
+			}
+			parent := current.ReferencedMessage
+			if parent == nil {
+				fetched, err := b.channelMessage(ref)
+				if err != nil {
+					fmt.Printf("couldn't fetch message %v that %v replied to: %v\n", ref.MessageID, current.ID, err)
+					break
+				}
+				parent = fetched
+			}
+			//Discord omits these in some cases but the rest of the fork
+			//needs them. parent may be shared with the cache, so set them
+			//on a copy.
+			copied := *parent
+			copied.GuildID = ref.GuildID
+			copied.ChannelID = ref.ChannelID
+			parent = &copied
+			included[parent.ID] = true
+			result = append(result, parent)
+			current = parent
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return messageTime(result[i]).Before(messageTime(result[j]))
+	})
+	return result
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestWithReplyContext(t *testing.T) {
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	messages := make(map[string]*discordgo.Message)
+	var controllerMessages []*discordgo.Message
+	for i, name := range []string{"one", "two", "three", "four", "five"} {
+		message := &discordgo.Message{
+			ID:        snowflakeForTime(now.Add(time.Minute * time.Duration(i))),
+			ChannelID: "channel-1",
+			Type:      discordgo.MessageTypeDefault,
+			Content:   name,
+		}
+		messages[name] = message
+		controllerMessages = append([]*discordgo.Message{message}, controllerMessages...)
+	}
+	reply := func(child, parent string) {
+		messages[child].Type = discordgo.MessageTypeReply
+		messages[child].MessageReference = &discordgo.MessageReference{
+			GuildID:   TEST_GUILD_ID,
+			ChannelID: "channel-1",
+			MessageID: messages[parent].ID,
+		}
+	}
+	reply("three", "one")
+	reply("five", "three")
+	//Discord includes the message replied to, but not what that replied to
+	parent := *messages["three"]
+	messages["five"].ReferencedMessage = &parent
+
+	tests := []struct {
+		Description string
+		Messages    []string
+		Depth       int
+		Expected    []string
+	}{
+		{
+			"Off",
+			[]string{"five"},
+			0,
+			[]string{"five"},
+		},
+		{
+			"One back",
+			[]string{"five"},
+			1,
+			[]string{"three", "five"},
+		},
+		{
+			"Whole chain",
+			[]string{"five"},
+			MAX_REPLY_CONTEXT_DEPTH,
+			[]string{"one", "three", "five"},
+		},
+		{
+			"Parent already in range",
+			[]string{"three", "four", "five"},
+			2,
+			[]string{"one", "three", "four", "five"},
+		},
+		{
+			"Not a reply",
+			[]string{"four"},
+			2,
+			[]string{"four"},
+		},
+	}
+	for i, test := range tests {
+		session, _ := discordgo.New(TEST_TOKEN)
+		controller := &TestController{
+			messages: controllerMessages,
+		}
+		bot := newBot(session, controller)
+		for _, message := range messages {
+			bot.messageCache.put(message)
+		}
+		var input []*discordgo.Message
+		for _, name := range test.Messages {
+			input = append(input, messages[name])
+		}
+		var result []string
+		for _, message := range bot.withReplyContext(input, test.Depth) {
+			result = append(result, message.Content)
+		}
+		if strings.Join(result, ",") != strings.Join(test.Expected, ",") {
+			t.Errorf("Test %v (%v) failed. Got %v, expected %v", i, test.Description, result, test.Expected)
+		}
+	}
+	for name, message := range messages {
+		if message.GuildID != "" || (message.ReferencedMessage != nil && message.ReferencedMessage.GuildID != "") {
+			t.Errorf("Expected cached message %v not to be modified", name)
+		}
+	}
+}
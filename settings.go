@@ -0,0 +1,498 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const SETTINGS_PATH = "settings"
+
+//This number should be incremented every time the format of the settings JSON
+//changes in a way that old files can't be read.
+const SETTINGS_JSON_FORMAT_VERSION = 1
+
+type threadGroupSettingsJSON struct {
+	//0 means use the global default
+	MaxActiveThreads int `json:"maxActiveThreads,omitempty"`
+	//Threads with no messages for this many days will be archived. 0 means
+	//never archive for idleness.
+	IdleArchiveDays int `json:"idleArchiveDays,omitempty"`
+	//Archived threads beyond this many are exported and deleted, oldest
+	//first. 0 means keep them all.
+	MaxArchivedThreads int `json:"maxArchivedThreads,omitempty"`
+}
+
+type guildSettingsJSON struct {
+	FormatVersion int `json:"formatVersion"`
+	//Thread group name --> settings for that group. The default group is "".
+	ThreadGroups map[string]*threadGroupSettingsJSON `json:"threadGroups"`
+	//Thread channel IDs that should never be archived or reordered
+	PinnedThreadIDs map[string]bool `json:"pinnedThreadIDs"`
+	//Thread channel ID --> when the bot last archived it
+	ThreadArchiveTimes map[string]time.Time `json:"threadArchiveTimes"`
+	//Thread channel ID --> when it was last unarchived or moved into a
+	//group's main category, which counts as activity for idle archiving
+	ThreadReviveTimes map[string]time.Time `json:"threadReviveTimes,omitempty"`
+	//Name of the thread group new threads are created in when no group is
+	//given. If no group has this name the "" group is used.
+	DefaultThreadGroup string `json:"defaultThreadGroup,omitempty"`
+	//If true, forks are posted via a webhook with the original author's name
+	//and avatar instead of as an embed from the bot.
+	WebhookForks bool `json:"webhookForks,omitempty"`
+	//What to do to forks when the message they were forked from is deleted.
+	//One of the DELETED_SOURCE_FORK_* constants; "" means tombstone.
+	DeletedSourceForks string `json:"deletedSourceForks,omitempty"`
+	//Forks also include the messages that forked replies replied to, up to
+	//this many back. 0 means don't.
+	ReplyContextDepth int `json:"replyContextDepth,omitempty"`
+	//How many minutes after a fork it can be undone. 0 means
+	//DEFAULT_FORK_UNDO_MINUTES.
+	ForkUndoMinutes int `json:"forkUndoMinutes,omitempty"`
+	//If true, forks into a new thread wait for the user to confirm a preview
+	ForkPreview bool `json:"forkPreview,omitempty"`
+	//How many people must react with FORK_THREAD_EMOJI before a message is
+	//forked. 0 means 1.
+	ForkQuorum int `json:"forkQuorum,omitempty"`
+	//If set, only reactions from members with one of these roles fork
+	ForkRoleIDs []string `json:"forkRoleIDs,omitempty"`
+	//Channel IDs where reacting with FORK_THREAD_EMOJI doesn't fork
+	ForkDisabledChannelIDs map[string]bool `json:"forkDisabledChannelIDs,omitempty"`
+	//How long someone has to wait after forking with FORK_THREAD_EMOJI before
+	//they can again. 0 means they don't.
+	ForkCooldownMinutes int `json:"forkCooldownMinutes,omitempty"`
+	//Guild ID --> name of the thread group that messages forked from that
+	//guild to this one go in
+	ForkInboxGroups map[string]string `json:"forkInboxGroups,omitempty"`
+	//ID of the guild that messages in this one can be forked to, if it
+	//accepts them. "" means none.
+	ForkDestinationGuildID string `json:"forkDestinationGuildID,omitempty"`
+}
+
+//GuildSettings stores the per-guild configuration that admins can change via
+//commands. Get one from LoadGuildSettings or newGuildSettings.
+type GuildSettings struct {
+	data       *guildSettingsJSON
+	guildID    string
+	futureSave *time.Timer
+}
+
+func newGuildSettings(guildID string) *GuildSettings {
+	return &GuildSettings{
+		data: &guildSettingsJSON{
+			FormatVersion:      SETTINGS_JSON_FORMAT_VERSION,
+			ThreadGroups:       make(map[string]*threadGroupSettingsJSON),
+			PinnedThreadIDs:    make(map[string]bool),
+			ThreadArchiveTimes: make(map[string]time.Time),
+		},
+		guildID: guildID,
+	}
+}
+
+func settingsPathForGuild(guildID string) string {
+	return filepath.Join(CACHE_PATH, SETTINGS_PATH, guildID+".json")
+}
+
+//LoadGuildSettings loads the settings for the guild from disk, returning fresh
+//settings if none have been saved yet.
+func LoadGuildSettings(guildID string) *GuildSettings {
+	path := settingsPathForGuild(guildID)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return newGuildSettings(guildID)
+	}
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Printf("couldn't read settings file for %v: %v\n", guildID, err)
+		return newGuildSettings(guildID)
+	}
+	var data guildSettingsJSON
+	if err := json.Unmarshal(blob, &data); err != nil {
+		fmt.Printf("couldn't unmarshal settings for %v: %v\n", guildID, err)
+		return newGuildSettings(guildID)
+	}
+	if data.FormatVersion != SETTINGS_JSON_FORMAT_VERSION {
+		fmt.Printf("%v settings file had old version %v, expected %v, discarding\n", guildID, data.FormatVersion, SETTINGS_JSON_FORMAT_VERSION)
+		return newGuildSettings(guildID)
+	}
+	if data.ThreadGroups == nil {
+		data.ThreadGroups = make(map[string]*threadGroupSettingsJSON)
+	}
+	if data.PinnedThreadIDs == nil {
+		data.PinnedThreadIDs = make(map[string]bool)
+	}
+	if data.ThreadArchiveTimes == nil {
+		data.ThreadArchiveTimes = make(map[string]time.Time)
+	}
+	return &GuildSettings{
+		data:    &data,
+		guildID: guildID,
+	}
+}
+
+//Requests a persistence to be done in the near future, batched up so it doesn't happen all that often.
+func (g *GuildSettings) RequestPersistence() {
+	if g.futureSave != nil {
+		return
+	}
+	g.futureSave = time.AfterFunc(AUTO_SAVE_INTERVAL, func() {
+		if err := g.Persist(); err != nil {
+			fmt.Printf("couldn't autosave settings %v: %v\n", g.guildID, err)
+		} else {
+			fmt.Printf("Autosaved %v settings\n", g.guildID)
+		}
+		g.futureSave = nil
+	})
+}
+
+//Persist saves the settings to disk. Load them back up later with LoadGuildSettings.
+func (g *GuildSettings) Persist() error {
+	if g.guildID == "" {
+		return fmt.Errorf("settings had no guildID")
+	}
+	folderPath := filepath.Join(CACHE_PATH, SETTINGS_PATH)
+	blob, err := json.MarshalIndent(g.data, "", "\t")
+	if err != nil {
+		return fmt.Errorf("couldnt format json: %w", err)
+	}
+	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(folderPath, 0700); err != nil {
+			return fmt.Errorf("couldn't create settings folder: %w", err)
+		}
+	}
+	return ioutil.WriteFile(settingsPathForGuild(g.guildID), blob, 0644)
+}
+
+//threadGroup returns the settings for the named group, creating them if they don't exist yet.
+func (g *GuildSettings) threadGroup(name string) *threadGroupSettingsJSON {
+	result := g.data.ThreadGroups[name]
+	if result == nil {
+		result = &threadGroupSettingsJSON{}
+		g.data.ThreadGroups[name] = result
+	}
+	return result
+}
+
+//validateMaxActiveThreads returns an error if the count can't be used as the
+//number of active threads in a category.
+func validateMaxActiveThreads(count int) error {
+	if count < 1 {
+		return fmt.Errorf("max active threads must be at least 1, got %v", count)
+	}
+	//Leave room for at least one more so a new thread can be created before
+	//the oldest one is archived.
+	if count >= MAX_CATEGORY_CHANNELS {
+		return fmt.Errorf("max active threads must be less than %v, got %v", MAX_CATEGORY_CHANNELS, count)
+	}
+	return nil
+}
+
+//MaxActiveThreadsForGroup returns the max number of active threads for the
+//named group, falling back on the global maxActiveThreads.
+func (g *GuildSettings) MaxActiveThreadsForGroup(name string) int {
+	if group := g.data.ThreadGroups[name]; group != nil && group.MaxActiveThreads > 0 {
+		return group.MaxActiveThreads
+	}
+	return maxActiveThreads
+}
+
+//SetMaxActiveThreadsForGroup sets the limit for the named group. 0 resets it to
+//the global default.
+func (g *GuildSettings) SetMaxActiveThreadsForGroup(name string, count int) error {
+	if count != 0 {
+		if err := validateMaxActiveThreads(count); err != nil {
+			return err
+		}
+	}
+	g.threadGroup(name).MaxActiveThreads = count
+	g.RequestPersistence()
+	return nil
+}
+
+//IdleArchiveDurationForGroup returns how long a thread in the named group may
+//go without messages before it is archived, or 0 if idle threads are kept.
+func (g *GuildSettings) IdleArchiveDurationForGroup(name string) time.Duration {
+	group := g.data.ThreadGroups[name]
+	if group == nil {
+		return 0
+	}
+	return time.Duration(group.IdleArchiveDays) * time.Hour * 24
+}
+
+//SetIdleArchiveDaysForGroup sets how many idle days before threads in the named
+//group are archived. 0 turns idle archiving off.
+func (g *GuildSettings) SetIdleArchiveDaysForGroup(name string, days int) error {
+	if days < 0 || days > MAX_IDLE_ARCHIVE_DAYS {
+		return fmt.Errorf("idle days must be between 0 and %v, got %v", MAX_IDLE_ARCHIVE_DAYS, days)
+	}
+	g.threadGroup(name).IdleArchiveDays = days
+	g.RequestPersistence()
+	return nil
+}
+
+//MaxArchivedThreadsForGroup returns how many archived threads the named group
+//keeps before exporting and deleting the oldest, or 0 if it keeps them all.
+func (g *GuildSettings) MaxArchivedThreadsForGroup(name string) int {
+	group := g.data.ThreadGroups[name]
+	if group == nil {
+		return 0
+	}
+	return group.MaxArchivedThreads
+}
+
+//SetMaxArchivedThreadsForGroup sets how many archived threads the named group
+//keeps. 0 keeps them all.
+func (g *GuildSettings) SetMaxArchivedThreadsForGroup(name string, count int) error {
+	if count < 0 {
+		return fmt.Errorf("max archived threads must not be negative, got %v", count)
+	}
+	g.threadGroup(name).MaxArchivedThreads = count
+	g.RequestPersistence()
+	return nil
+}
+
+func (g *GuildSettings) DefaultThreadGroup() string {
+	return g.data.DefaultThreadGroup
+}
+
+func (g *GuildSettings) SetDefaultThreadGroup(name string) {
+	g.data.DefaultThreadGroup = name
+	g.RequestPersistence()
+}
+
+func (g *GuildSettings) WebhookForks() bool {
+	return g.data.WebhookForks
+}
+
+func (g *GuildSettings) SetWebhookForks(enabled bool) {
+	g.data.WebhookForks = enabled
+	g.RequestPersistence()
+}
+
+//DeletedSourceForks returns one of the DELETED_SOURCE_FORK_* constants.
+func (g *GuildSettings) DeletedSourceForks() string {
+	if g.data.DeletedSourceForks == "" {
+		return DELETED_SOURCE_FORK_TOMBSTONE
+	}
+	return g.data.DeletedSourceForks
+}
+
+func (g *GuildSettings) SetDeletedSourceForks(policy string) error {
+	if policy != DELETED_SOURCE_FORK_TOMBSTONE && policy != DELETED_SOURCE_FORK_DELETE {
+		return fmt.Errorf("unknown policy for forks of deleted messages: %v", policy)
+	}
+	g.data.DeletedSourceForks = policy
+	g.RequestPersistence()
+	return nil
+}
+
+func (g *GuildSettings) ReplyContextDepth() int {
+	return g.data.ReplyContextDepth
+}
+
+func (g *GuildSettings) SetReplyContextDepth(depth int) error {
+	if depth < 0 || depth > MAX_REPLY_CONTEXT_DEPTH {
+		return fmt.Errorf("reply depth must be between 0 and %v, got %v", MAX_REPLY_CONTEXT_DEPTH, depth)
+	}
+	g.data.ReplyContextDepth = depth
+	g.RequestPersistence()
+	return nil
+}
+
+func (g *GuildSettings) ForkUndoWindow() time.Duration {
+	minutes := g.data.ForkUndoMinutes
+	if minutes == 0 {
+		minutes = DEFAULT_FORK_UNDO_MINUTES
+	}
+	return time.Minute * time.Duration(minutes)
+}
+
+func (g *GuildSettings) SetForkUndoMinutes(minutes int) error {
+	if minutes < 0 || minutes > MAX_FORK_UNDO_MINUTES {
+		return fmt.Errorf("undo minutes must be between 0 and %v, got %v", MAX_FORK_UNDO_MINUTES, minutes)
+	}
+	g.data.ForkUndoMinutes = minutes
+	g.RequestPersistence()
+	return nil
+}
+
+func (g *GuildSettings) ForkPreview() bool {
+	return g.data.ForkPreview
+}
+
+func (g *GuildSettings) SetForkPreview(enabled bool) {
+	g.data.ForkPreview = enabled
+	g.RequestPersistence()
+}
+
+func (g *GuildSettings) ForkQuorum() int {
+	if g.data.ForkQuorum == 0 {
+		return 1
+	}
+	return g.data.ForkQuorum
+}
+
+func (g *GuildSettings) SetForkQuorum(count int) error {
+	if count < 0 || count > MAX_FORK_QUORUM {
+		return fmt.Errorf("quorum must be between 0 and %v, got %v", MAX_FORK_QUORUM, count)
+	}
+	g.data.ForkQuorum = count
+	g.RequestPersistence()
+	return nil
+}
+
+func (g *GuildSettings) ForkRoleIDs() []string {
+	return g.data.ForkRoleIDs
+}
+
+func (g *GuildSettings) SetForkRoleAllowed(roleID string, allowed bool) {
+	var roleIDs []string
+	for _, id := range g.data.ForkRoleIDs {
+		if id != roleID {
+			roleIDs = append(roleIDs, id)
+		}
+	}
+	if allowed {
+		roleIDs = append(roleIDs, roleID)
+	}
+	g.data.ForkRoleIDs = roleIDs
+	g.RequestPersistence()
+}
+
+func (g *GuildSettings) ForkingDisabledInChannel(channelID string) bool {
+	return g.data.ForkDisabledChannelIDs[channelID]
+}
+
+func (g *GuildSettings) SetForkingDisabledInChannel(channelID string, disabled bool) {
+	if disabled {
+		if g.data.ForkDisabledChannelIDs == nil {
+			g.data.ForkDisabledChannelIDs = make(map[string]bool)
+		}
+		g.data.ForkDisabledChannelIDs[channelID] = true
+	} else {
+		delete(g.data.ForkDisabledChannelIDs, channelID)
+	}
+	g.RequestPersistence()
+}
+
+func (g *GuildSettings) ForkCooldown() time.Duration {
+	return time.Minute * time.Duration(g.data.ForkCooldownMinutes)
+}
+
+func (g *GuildSettings) SetForkCooldownMinutes(minutes int) error {
+	if minutes < 0 || minutes > MAX_FORK_COOLDOWN_MINUTES {
+		return fmt.Errorf("cooldown must be between 0 and %v minutes, got %v", MAX_FORK_COOLDOWN_MINUTES, minutes)
+	}
+	g.data.ForkCooldownMinutes = minutes
+	g.RequestPersistence()
+	return nil
+}
+
+//ForkInboxGroup returns the name of the thread group that forks from
+//sourceGuildID go in, and false if this guild doesn't accept them.
+func (g *GuildSettings) ForkInboxGroup(sourceGuildID string) (string, bool) {
+	name, ok := g.data.ForkInboxGroups[sourceGuildID]
+	return name, ok
+}
+
+//ForkInboxSourceGuildIDs returns the IDs of the guilds whose forks go in the
+//named thread group.
+func (g *GuildSettings) ForkInboxSourceGuildIDs(groupName string) []string {
+	var result []string
+	for sourceGuildID, name := range g.data.ForkInboxGroups {
+		if name == groupName {
+			result = append(result, sourceGuildID)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (g *GuildSettings) SetForkInboxGroup(sourceGuildID string, groupName string) {
+	if g.data.ForkInboxGroups == nil {
+		g.data.ForkInboxGroups = make(map[string]string)
+	}
+	g.data.ForkInboxGroups[sourceGuildID] = groupName
+	g.RequestPersistence()
+}
+
+func (g *GuildSettings) RemoveForkInboxGroup(sourceGuildID string) {
+	delete(g.data.ForkInboxGroups, sourceGuildID)
+	g.RequestPersistence()
+}
+
+//ForkDestinationGuild returns the ID of the guild that messages in this one
+//can be forked to, or "" if they can't be forked to another guild.
+func (g *GuildSettings) ForkDestinationGuild() string {
+	return g.data.ForkDestinationGuildID
+}
+
+func (g *GuildSettings) SetForkDestinationGuild(guildID string) {
+	g.data.ForkDestinationGuildID = guildID
+	g.RequestPersistence()
+}
+
+func (g *GuildSettings) ThreadIsPinned(channelID string) bool {
+	return g.data.PinnedThreadIDs[channelID]
+}
+
+//PinnedThreadIDs returns a copy of the set of pinned thread IDs.
+func (g *GuildSettings) PinnedThreadIDs() map[string]bool {
+	result := make(map[string]bool, len(g.data.PinnedThreadIDs))
+	for id := range g.data.PinnedThreadIDs {
+		result[id] = true
+	}
+	return result
+}
+
+func (g *GuildSettings) SetThreadPinned(channelID string, pinned bool) {
+	if pinned {
+		g.data.PinnedThreadIDs[channelID] = true
+	} else {
+		delete(g.data.PinnedThreadIDs, channelID)
+	}
+	g.RequestPersistence()
+}
+
+//ThreadArchiveTime returns when the bot archived the thread, and false if it
+//doesn't know (e.g. it was archived before the bot started tracking).
+func (g *GuildSettings) ThreadArchiveTime(channelID string) (time.Time, bool) {
+	result, ok := g.data.ThreadArchiveTimes[channelID]
+	return result, ok
+}
+
+//SetThreadArchiveTime records when the thread was archived. A zero time clears
+//it, e.g. because the thread was unarchived.
+func (g *GuildSettings) SetThreadArchiveTime(channelID string, archivedAt time.Time) {
+	if archivedAt.IsZero() {
+		delete(g.data.ThreadArchiveTimes, channelID)
+	} else {
+		g.data.ThreadArchiveTimes[channelID] = archivedAt
+	}
+	g.RequestPersistence()
+}
+
+//ThreadReviveTime returns when the thread was last unarchived or moved into a
+//group's main category, and false if it never was.
+func (g *GuildSettings) ThreadReviveTime(channelID string) (time.Time, bool) {
+	result, ok := g.data.ThreadReviveTimes[channelID]
+	return result, ok
+}
+
+//SetThreadReviveTime records when the thread was unarchived or moved into a
+//group's main category. A zero time clears it, e.g. because the thread was
+//deleted.
+func (g *GuildSettings) SetThreadReviveTime(channelID string, revivedAt time.Time) {
+	if revivedAt.IsZero() {
+		delete(g.data.ThreadReviveTimes, channelID)
+	} else {
+		if g.data.ThreadReviveTimes == nil {
+			g.data.ThreadReviveTimes = make(map[string]time.Time)
+		}
+		g.data.ThreadReviveTimes[channelID] = revivedAt
+	}
+	g.RequestPersistence()
+}
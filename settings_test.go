@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetMaxActiveThreadsForGroup(t *testing.T) {
+	maxActiveThreads = 5
+	tests := []struct {
+		Description string
+		Input       int
+		Expected    int
+		ExpectError bool
+	}{
+		{
+			"Reset to default",
+			0,
+			5,
+			false,
+		},
+		{
+			"Normal limit",
+			15,
+			15,
+			false,
+		},
+		{
+			"Negative",
+			-1,
+			5,
+			true,
+		},
+		{
+			"Fills category",
+			MAX_CATEGORY_CHANNELS,
+			5,
+			true,
+		},
+		{
+			"Largest allowed",
+			MAX_CATEGORY_CHANNELS - 1,
+			MAX_CATEGORY_CHANNELS - 1,
+			false,
+		},
+	}
+
+	for i, test := range tests {
+		settings := newGuildSettings(TEST_GUILD_ID)
+		err := settings.SetMaxActiveThreadsForGroup("Design", test.Input)
+		if (err != nil) != test.ExpectError {
+			t.Errorf("Test %v %v: unexpected error state: %v", i, test.Description, err)
+		}
+		result := settings.MaxActiveThreadsForGroup("Design")
+		if result != test.Expected {
+			t.Errorf("Test %v %v : %v did not equal %v", i, test.Description, result, test.Expected)
+		}
+		if other := settings.MaxActiveThreadsForGroup(""); other != maxActiveThreads {
+			t.Errorf("Test %v %v : other group was changed to %v", i, test.Description, other)
+		}
+	}
+}
+
+func TestSetIdleArchiveDaysForGroup(t *testing.T) {
+	tests := []struct {
+		Description string
+		Input       int
+		Expected    time.Duration
+		ExpectError bool
+	}{
+		{
+			"Off",
+			0,
+			0,
+			false,
+		},
+		{
+			"Normal",
+			21,
+			time.Hour * 24 * 21,
+			false,
+		},
+		{
+			"Negative",
+			-1,
+			0,
+			true,
+		},
+		{
+			"Largest allowed",
+			MAX_IDLE_ARCHIVE_DAYS,
+			time.Hour * 24 * MAX_IDLE_ARCHIVE_DAYS,
+			false,
+		},
+		{
+			"So many it would overflow",
+			1 << 40,
+			0,
+			true,
+		},
+	}
+
+	for i, test := range tests {
+		settings := newGuildSettings(TEST_GUILD_ID)
+		err := settings.SetIdleArchiveDaysForGroup("Design", test.Input)
+		if (err != nil) != test.ExpectError {
+			t.Errorf("Test %v %v: unexpected error state: %v", i, test.Description, err)
+		}
+		if result := settings.IdleArchiveDurationForGroup("Design"); result != test.Expected {
+			t.Errorf("Test %v %v : %v did not equal %v", i, test.Description, result, test.Expected)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//The policies for what happens to forks when the message they were forked
+//from is deleted.
+const DELETED_SOURCE_FORK_TOMBSTONE = "tombstone"
+const DELETED_SOURCE_FORK_DELETE = "delete"
+
+//Forks of deleted messages are edited to say this
+const FORK_TOMBSTONE_TEXT = "[original message deleted]"
+
+//How many times to try tombstoning or deleting a fork before giving up
+const FORK_DELETION_ATTEMPTS = 3
+
+//How long to wait between attempts. A var so tests don't have to wait.
+var forkDeletionRetryInterval = time.Second * 5
+
+//deletedSourceFork is a fork of a message that was deleted. Its webhookID is
+//captured before the index forgets about it.
+type deletedSourceFork struct {
+	ref       *discordgo.MessageReference
+	webhookID string
+}
+
+func forksOfDeletedSource(idf *IDFIndex, forks []*discordgo.MessageReference) []*deletedSourceFork {
+	var result []*deletedSourceFork
+	for _, fork := range forks {
+		result = append(result, &deletedSourceFork{
+			ref:       fork,
+			webhookID: idf.ForkWebhookID(fork),
+		})
+	}
+	return result
+}
+
+//propagateSourceDeletion tombstones or deletes each of the forks, depending on
+//the guild's policy, so forks don't keep showing what the author deleted.
+func (b *bot) propagateSourceDeletion(guildID string, forks []*deletedSourceFork) {
+	if len(forks) == 0 {
+		return
+	}
+	policy := b.getGuildSettings(guildID).DeletedSourceForks()
+	for _, fork := range forks {
+		b.applyDeletedSourcePolicy(policy, fork, 1)
+	}
+}
+
+//applyDeletedSourcePolicy tombstones or deletes the fork. If that fails it
+//tries again in the background after forkDeletionRetryInterval, up to
+//FORK_DELETION_ATTEMPTS times in all, so that a channel with many forks
+//doesn't tie up the event handler. A fork that no longer exists counts as
+//success.
+func (b *bot) applyDeletedSourcePolicy(policy string, fork *deletedSourceFork, attempt int) {
+	var err error
+	if policy == DELETED_SOURCE_FORK_DELETE {
+		err = b.controller.ChannelMessageDelete(fork.ref.ChannelID, fork.ref.MessageID)
+	} else {
+		err = b.tombstoneFork(fork)
+	}
+	switch restErrorCode(err) {
+	case discordgo.ErrCodeUnknownMessage, discordgo.ErrCodeUnknownChannel:
+		//Someone beat us to it
+		err = nil
+	}
+	if err == nil {
+		fmt.Printf("Applied %v policy to fork %v because the message it was forked from was deleted\n", policy, fork.ref.MessageID)
+		return
+	}
+	if attempt >= FORK_DELETION_ATTEMPTS {
+		fmt.Printf("couldn't %v fork %v of a deleted message: %v\n", policy, fork.ref.MessageID, err)
+		return
+	}
+	b.forkDeletionRetries.Add(1)
+	time.AfterFunc(forkDeletionRetryInterval, func() {
+		defer b.forkDeletionRetries.Done()
+		b.applyDeletedSourcePolicy(policy, fork, attempt+1)
+	})
+}
+
+func (b *bot) tombstoneFork(fork *deletedSourceFork) error {
+	if fork.webhookID == "" {
+		embeds := []*discordgo.MessageEmbed{
+			{
+				Description: FORK_TOMBSTONE_TEXT,
+			},
+		}
+		_, err := b.controller.ChannelMessageEditEmbeds(fork.ref.ChannelID, fork.ref.MessageID, embeds)
+		return err
+	}
+	webhook, err := b.forkWebhookByID(fork.webhookID)
+	if err != nil {
+		return err
+	}
+	data := &discordgo.WebhookEdit{
+		Content: FORK_TOMBSTONE_TEXT,
+		//Remove any images and embeds from the original
+		Embeds: []*discordgo.MessageEmbed{},
+	}
+	_, err = b.controller.WebhookMessageEdit(webhook.ID, webhook.Token, fork.ref.MessageID, data)
+	return err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestDeletedSourceForks(t *testing.T) {
+	forkDeletionRetryInterval = 0
+	tests := []struct {
+		Description string
+		Policy      string
+		//The next this many edits fail
+		FailingEdits int
+		Delete       func(bot *bot, session *discordgo.Session)
+		//Whether the forks of message-1 should be tombstoned or deleted
+		ExpectForksChanged bool
+	}{
+		{
+			"Message deleted",
+			DELETED_SOURCE_FORK_TOMBSTONE,
+			0,
+			func(bot *bot, session *discordgo.Session) {
+				bot.messageDelete(session, &discordgo.MessageDelete{
+					Message: &discordgo.Message{
+						ID:        "300000000000000001",
+						ChannelID: "200000000000000001",
+						GuildID:   TEST_GUILD_ID,
+					},
+				})
+			},
+			true,
+		},
+		{
+			"Message deleted with failing edits",
+			DELETED_SOURCE_FORK_TOMBSTONE,
+			FORK_DELETION_ATTEMPTS - 1,
+			func(bot *bot, session *discordgo.Session) {
+				bot.messageDelete(session, &discordgo.MessageDelete{
+					Message: &discordgo.Message{
+						ID:        "300000000000000001",
+						ChannelID: "200000000000000001",
+						GuildID:   TEST_GUILD_ID,
+					},
+				})
+			},
+			true,
+		},
+		{
+			"Other message deleted",
+			DELETED_SOURCE_FORK_TOMBSTONE,
+			0,
+			func(bot *bot, session *discordgo.Session) {
+				bot.messageDelete(session, &discordgo.MessageDelete{
+					Message: &discordgo.Message{
+						ID:        "300000000000000002",
+						ChannelID: "200000000000000001",
+						GuildID:   TEST_GUILD_ID,
+					},
+				})
+			},
+			false,
+		},
+		{
+			"Bulk delete",
+			DELETED_SOURCE_FORK_DELETE,
+			0,
+			func(bot *bot, session *discordgo.Session) {
+				bot.messageDeleteBulk(session, &discordgo.MessageDeleteBulk{
+					Messages:  []string{"300000000000000002", "300000000000000001"},
+					ChannelID: "200000000000000001",
+					GuildID:   TEST_GUILD_ID,
+				})
+			},
+			true,
+		},
+		{
+			"Channel deleted",
+			DELETED_SOURCE_FORK_DELETE,
+			0,
+			func(bot *bot, session *discordgo.Session) {
+				bot.channelDelete(session, &discordgo.ChannelDelete{
+					Channel: &discordgo.Channel{
+						ID:      "200000000000000001",
+						GuildID: TEST_GUILD_ID,
+					},
+				})
+			},
+			true,
+		},
+		{
+			"Channel deleted by the bot",
+			DELETED_SOURCE_FORK_TOMBSTONE,
+			0,
+			func(bot *bot, session *discordgo.Session) {
+				bot.getPendingMoves(TEST_GUILD_ID).noteDeleting("200000000000000001")
+				bot.channelDelete(session, &discordgo.ChannelDelete{
+					Channel: &discordgo.Channel{
+						ID:      "200000000000000001",
+						GuildID: TEST_GUILD_ID,
+					},
+				})
+			},
+			false,
+		},
+	}
+	for i, test := range tests {
+		session, _ := discordgo.New(TEST_TOKEN)
+		controller := &TestController{
+			webhooks: []*discordgo.Webhook{
+				{
+					ID:        "webhook-1",
+					ChannelID: "400000000000000002",
+					Name:      FORK_WEBHOOK_NAME,
+					Token:     "webhook-token",
+				},
+			},
+			failingEditCount: test.FailingEdits,
+		}
+		bot := newBot(session, controller)
+		settings := newGuildSettings(TEST_GUILD_ID)
+		settings.SetDeletedSourceForks(test.Policy)
+		bot.settings[TEST_GUILD_ID] = settings
+		idf := newIDFIndex(TEST_GUILD_ID)
+		bot.indexes[TEST_GUILD_ID] = idf
+		idf.NoteForkedMessage(messageReference(TEST_GUILD_ID, "200000000000000001", "300000000000000001"), messageReference(TEST_GUILD_ID, "400000000000000001", "500000000000000001"))
+		idf.NoteForkMessage(&discordgo.Message{
+			ID:        "500000000000000002",
+			ChannelID: "400000000000000002",
+			WebhookID: "webhook-1",
+			Content:   "hello\n[" + WEBHOOK_FORK_LINK_TEXT + "](<https://discord.com/channels/100000000000000001/200000000000000001/300000000000000001>)",
+		})
+		//A fork of a fork shows the original's content too
+		idf.NoteForkedMessage(messageReference(TEST_GUILD_ID, "400000000000000001", "500000000000000001"), messageReference(TEST_GUILD_ID, "400000000000000003", "500000000000000004"))
+		//Forks in the same channel as their source go when the channel does
+		idf.NoteForkedMessage(messageReference(TEST_GUILD_ID, "200000000000000001", "300000000000000003"), messageReference(TEST_GUILD_ID, "200000000000000001", "500000000000000003"))
+
+		test.Delete(bot, session)
+		bot.forkDeletionRetries.Wait()
+
+		if len(idf.MessageForks("200000000000000001", "300000000000000001")) > 0 && test.ExpectForksChanged {
+			t.Errorf("Test %v (%v) should have removed the forks from the index", i, test.Description)
+		}
+		var changed []string
+		if test.Policy == DELETED_SOURCE_FORK_DELETE {
+			changed = append(changed, controller.deletedMessageIDs...)
+		} else {
+			for _, fork := range []*discordgo.MessageReference{
+				messageReference(TEST_GUILD_ID, "400000000000000001", "500000000000000001"),
+				messageReference(TEST_GUILD_ID, "400000000000000003", "500000000000000004"),
+			} {
+				if embeds := controller.editedMessageEmbeds[fork.ChannelID+"+"+fork.MessageID]; len(embeds) > 0 {
+					if len(embeds) != 1 || embeds[0].Description != FORK_TOMBSTONE_TEXT || embeds[0].URL != "" {
+						t.Errorf("Test %v (%v) didn't tombstone the embed fork %v, got %v", i, test.Description, fork.MessageID, embeds[0])
+					}
+					changed = append(changed, fork.MessageID)
+				}
+			}
+			changed = append(changed, controller.editedWebhookMessageIDs...)
+			if controller.lastWebhookEdit != nil && (controller.lastWebhookEdit.Content != FORK_TOMBSTONE_TEXT || controller.lastWebhookEdit.Embeds == nil) {
+				t.Errorf("Test %v (%v) didn't tombstone the webhook fork, got %v", i, test.Description, controller.lastWebhookEdit)
+			}
+		}
+		sort.Strings(changed)
+		expected := ""
+		if test.ExpectForksChanged {
+			expected = "500000000000000001,500000000000000002,500000000000000004"
+		}
+		if strings.Join(changed, ",") != expected {
+			t.Errorf("Test %v (%v) expected %v to be changed, got %v", i, test.Description, expected, changed)
+		}
+	}
+}
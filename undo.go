@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//How long after a fork it can be undone, unless the guild configures otherwise
+const DEFAULT_FORK_UNDO_MINUTES = 5
+
+//The longest a guild can let forks be undone for
+const MAX_FORK_UNDO_MINUTES = 60
+
+//forkUndo is everything needed to undo a fork within its grace period.
+type forkUndo struct {
+	//The ID of the fork's intro message, which identifies the fork
+	id      string
+	guildID string
+	//The user who forked
+	userID string
+	//The thread created for the fork, or "" if it was forked into an existing
+	//thread
+	threadID string
+	//The message reacted to with FORK_THREAD_EMOJI, if that's how it was forked
+	trigger *discordgo.MessageReference
+	//Every message the fork posted in the thread
+	posted  []*discordgo.MessageReference
+	readOut *discordgo.MessageReference
+	expires time.Time
+}
+
+func newForkUndo(guildID, userID string, trigger *discordgo.MessageReference) *forkUndo {
+	return &forkUndo{
+		guildID: guildID,
+		userID:  userID,
+		trigger: trigger,
+	}
+}
+
+//notePosted records that the fork posted msg. Safe to call on nil.
+func (u *forkUndo) notePosted(msg *discordgo.Message) {
+	if u == nil || msg == nil {
+		return
+	}
+	u.posted = append(u.posted, messageReference(u.guildID, msg.ChannelID, msg.ID))
+}
+
+//canUndo returns true if userID may undo the fork. Only the user who forked
+//and moderators may.
+func (u *forkUndo) canUndo(userID string, isModerator bool) bool {
+	return isModerator || (userID != "" && userID == u.userID)
+}
+
+func undoForkComponents(id string) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Undo",
+					Style:    discordgo.SecondaryButton,
+					CustomID: UNDO_FORK_BUTTON_ID + ":" + id,
+				},
+			},
+		},
+	}
+}
+
+//registerForkUndo makes the fork undoable until its grace period is over, at
+//which point the undo button is removed.
+func (b *bot) registerForkUndo(undo *forkUndo) {
+	window := b.getGuildSettings(undo.guildID).ForkUndoWindow()
+	undo.expires = b.controller.Now().Add(window)
+	b.forkUndosMutex.Lock()
+	b.forkUndos[undo.id] = undo
+	b.forkUndosMutex.Unlock()
+	time.AfterFunc(window, func() {
+		b.expireForkUndo(undo)
+	})
+}
+
+//expireForkUndo forgets the undo once its grace period is over and removes
+//the undo button from the read out. Unlike takeForkUndo it doesn't check
+//expires, which has passed by the time it's called.
+func (b *bot) expireForkUndo(undo *forkUndo) {
+	b.forkUndosMutex.Lock()
+	registered := b.forkUndos[undo.id] == undo
+	if registered {
+		delete(b.forkUndos, undo.id)
+	}
+	b.forkUndosMutex.Unlock()
+	if !registered {
+		//Already undone
+		return
+	}
+	edit := discordgo.NewMessageEdit(undo.readOut.ChannelID, undo.readOut.MessageID)
+	edit.Components = []discordgo.MessageComponent{}
+	if _, err := b.controller.ChannelMessageEditComplex(edit); err != nil {
+		fmt.Printf("couldn't remove undo button: %v\n", err)
+	}
+}
+
+//takeForkUndo returns the undo for the fork with the given id and forgets it,
+//or returns nil if it has expired or doesn't exist.
+func (b *bot) takeForkUndo(id string) *forkUndo {
+	b.forkUndosMutex.Lock()
+	defer b.forkUndosMutex.Unlock()
+	undo := b.forkUndos[id]
+	delete(b.forkUndos, id)
+	if undo == nil || b.controller.Now().After(undo.expires) {
+		return nil
+	}
+	return undo
+}
+
+//takeForkUndoForReaction is takeForkUndo for the fork that userID started by
+//reacting to trigger.
+func (b *bot) takeForkUndoForReaction(trigger *discordgo.MessageReference, userID string) *forkUndo {
+	b.forkUndosMutex.Lock()
+	var id string
+	for _, undo := range b.forkUndos {
+		if undo.trigger != nil && undo.trigger.MessageID == trigger.MessageID && undo.userID == userID {
+			id = undo.id
+			break
+		}
+	}
+	b.forkUndosMutex.Unlock()
+	if id == "" {
+		return nil
+	}
+	return b.takeForkUndo(id)
+}
+
+//undoFork deletes the thread the fork created, or the messages it posted if
+//it forked into an existing thread, and its read out, and forgets the forks.
+func (b *bot) undoFork(undo *forkUndo) error {
+	idf, err := b.getLiveIDFIndex(undo.guildID)
+	if err != nil {
+		return fmt.Errorf("couldn't get idf: %w", err)
+	}
+	if undo.threadID != "" {
+		pendingMoves := b.getPendingMoves(undo.guildID)
+		pendingMoves.noteDeleting(undo.threadID)
+		if _, err := b.controller.ChannelDelete(undo.threadID); err != nil {
+			pendingMoves.takeDeleting(undo.threadID)
+			return fmt.Errorf("couldn't delete thread: %w", err)
+		}
+		idf.NoteChannelDeleted(undo.threadID)
+	} else {
+		for _, posted := range undo.posted {
+			if err := b.controller.ChannelMessageDelete(posted.ChannelID, posted.MessageID); err != nil && restErrorCode(err) != discordgo.ErrCodeUnknownMessage {
+				return fmt.Errorf("couldn't delete forked message: %w", err)
+			}
+			idf.NoteMessageDeleted(posted.MessageID)
+		}
+	}
+	idf.RequestPeristence()
+	b.forgetForkTrigger(undo.trigger)
+	if undo.readOut != nil {
+		if err := b.controller.ChannelMessageDelete(undo.readOut.ChannelID, undo.readOut.MessageID); err != nil && restErrorCode(err) != discordgo.ErrCodeUnknownMessage {
+			return fmt.Errorf("couldn't delete read out: %w", err)
+		}
+	}
+	fmt.Printf("Undid fork %v by %v\n", undo.id, undo.userID)
+	return nil
+}
+
+//undoForkViaReactionRemoval undoes the fork that userID started by reacting
+//to ref, if it's still in its grace period.
+func (b *bot) undoForkViaReactionRemoval(ref *discordgo.MessageReference, userID string) error {
+	undo := b.takeForkUndoForReaction(ref, userID)
+	if undo == nil {
+		return nil
+	}
+	return b.undoFork(undo)
+}
+
+func (b *bot) undoForkInteraction(s *discordgo.Session, event *discordgo.InteractionCreate) {
+	var userID string
+	if event.Member != nil && event.Member.User != nil {
+		userID = event.Member.User.ID
+	}
+	pieces := strings.Split(event.MessageComponentData().CustomID, ":")
+	message := "Couldn't undo fork: "
+	if len(pieces) != 2 {
+		message += "Unexpected undo button"
+	} else {
+		b.forkUndosMutex.Lock()
+		undo := b.forkUndos[pieces[1]]
+		b.forkUndosMutex.Unlock()
+		switch {
+		case undo == nil:
+			message += "It's too late to undo this fork"
+		case !undo.canUndo(userID, memberCanManageChannels(event)):
+			message += "Only the person who forked or a moderator can undo it"
+		default:
+			if undo = b.takeForkUndo(undo.id); undo == nil {
+				message += "It's too late to undo this fork"
+			} else if err := b.undoFork(undo); err != nil {
+				message += err.Error()
+				fmt.Println(message)
+			} else {
+				message = "Undid the fork"
+			}
+		}
+	}
+
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: message,
+			Flags:   uint64(discordgo.MessageFlagsEphemeral),
+		},
+	})
+}
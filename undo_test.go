@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestForkUndoCanUndo(t *testing.T) {
+	undo := newForkUndo(TEST_GUILD_ID, "user-1", nil)
+	tests := []struct {
+		Description string
+		UserID      string
+		IsModerator bool
+		Expected    bool
+	}{
+		{
+			"Forker",
+			"user-1",
+			false,
+			true,
+		},
+		{
+			"Someone else",
+			"user-2",
+			false,
+			false,
+		},
+		{
+			"Moderator",
+			"user-2",
+			true,
+			true,
+		},
+		{
+			"Unknown user",
+			"",
+			false,
+			false,
+		},
+	}
+	for i, test := range tests {
+		result := undo.canUndo(test.UserID, test.IsModerator)
+		if result != test.Expected {
+			t.Errorf("Test %v (%v) failed. Got %v, expected %v", i, test.Description, result, test.Expected)
+		}
+	}
+}
+
+func TestUndoFork(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	controller := &TestController{
+		now: now,
+	}
+	bot := newBot(session, controller)
+	bot.settings[TEST_GUILD_ID] = newGuildSettings(TEST_GUILD_ID)
+	idf := newIDFIndex(TEST_GUILD_ID)
+	bot.indexes[TEST_GUILD_ID] = idf
+	source := messageReference(TEST_GUILD_ID, "channel-1", "message-1")
+	idf.NoteForkedMessage(source, messageReference(TEST_GUILD_ID, "new-thread", "fork-1"))
+	idf.NoteForkedMessage(source, messageReference(TEST_GUILD_ID, "existing-thread", "fork-2"))
+
+	//Forked via emoji into a new thread
+	emojiUndo := newForkUndo(TEST_GUILD_ID, "user-1", source)
+	emojiUndo.id = "intro-1"
+	emojiUndo.threadID = "new-thread"
+	emojiUndo.readOut = messageReference(TEST_GUILD_ID, "channel-1", "read-out-1")
+	bot.registerForkUndo(emojiUndo)
+
+	//Forked via the context menu into an existing thread
+	existingUndo := newForkUndo(TEST_GUILD_ID, "user-2", nil)
+	existingUndo.id = "intro-2"
+	existingUndo.notePosted(&discordgo.Message{ID: "intro-2", ChannelID: "existing-thread"})
+	existingUndo.notePosted(&discordgo.Message{ID: "fork-2", ChannelID: "existing-thread"})
+	existingUndo.readOut = messageReference(TEST_GUILD_ID, "channel-1", "read-out-2")
+	bot.registerForkUndo(existingUndo)
+
+	if err := bot.undoForkViaReactionRemoval(source, "user-2"); err != nil {
+		t.Fatalf("undoForkViaReactionRemoval returned an error: %v", err)
+	}
+	if len(controller.deletedChannelIDs) != 0 {
+		t.Errorf("Removing someone else's reaction shouldn't undo the fork")
+	}
+
+	if err := bot.undoForkViaReactionRemoval(source, "user-1"); err != nil {
+		t.Fatalf("undoForkViaReactionRemoval returned an error: %v", err)
+	}
+	if strings.Join(controller.deletedChannelIDs, ",") != "new-thread" {
+		t.Errorf("Expected the new thread to be deleted, got %v", controller.deletedChannelIDs)
+	}
+	if strings.Join(controller.deletedMessageIDs, ",") != "read-out-1" {
+		t.Errorf("Expected the read out to be deleted, got %v", controller.deletedMessageIDs)
+	}
+	forks := idf.MessageForks("channel-1", "message-1")
+	if len(forks) != 1 || forks[0].MessageID != "fork-2" {
+		t.Errorf("Expected only the undone fork to be forgotten, got %v", forks)
+	}
+	if bot.takeForkUndo("intro-1") != nil {
+		t.Errorf("An undone fork shouldn't be undoable again")
+	}
+
+	controller.now = now.Add(time.Minute * (DEFAULT_FORK_UNDO_MINUTES + 1))
+	if bot.takeForkUndo("intro-2") != nil {
+		t.Errorf("Forks shouldn't be undoable after the grace period")
+	}
+
+	controller.now = now
+	bot.registerForkUndo(existingUndo)
+	if err := bot.undoFork(bot.takeForkUndo("intro-2")); err != nil {
+		t.Fatalf("undoFork returned an error: %v", err)
+	}
+	if strings.Join(controller.deletedMessageIDs, ",") != "read-out-1,intro-2,fork-2,read-out-2" {
+		t.Errorf("Expected the forked messages and read out to be deleted, got %v", controller.deletedMessageIDs)
+	}
+	if len(controller.deletedChannelIDs) != 1 {
+		t.Errorf("An existing thread shouldn't be deleted on undo")
+	}
+	if forks := idf.MessageForks("channel-1", "message-1"); len(forks) != 0 {
+		t.Errorf("Expected every fork to be forgotten, got %v", forks)
+	}
+}
+
+func TestExpireForkUndo(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	now := time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC)
+	controller := &TestController{
+		now: now,
+	}
+	bot := newBot(session, controller)
+	bot.settings[TEST_GUILD_ID] = newGuildSettings(TEST_GUILD_ID)
+
+	undo := newForkUndo(TEST_GUILD_ID, "user-1", nil)
+	undo.id = "intro-1"
+	undo.readOut = messageReference(TEST_GUILD_ID, "channel-1", "read-out-1")
+	bot.registerForkUndo(undo)
+
+	//As if the timer fired once the grace period was over
+	controller.now = now.Add(time.Minute * DEFAULT_FORK_UNDO_MINUTES)
+	bot.expireForkUndo(undo)
+	components, ok := controller.editedMessageComponents["channel-1+read-out-1"]
+	if !ok || len(components) != 0 {
+		t.Errorf("Expected the undo button to be removed, got %v", components)
+	}
+	if _, ok := bot.forkUndos["intro-1"]; ok {
+		t.Errorf("Expected the expired undo to be forgotten")
+	}
+
+	controller.editedMessageComponents = nil
+	controller.now = now
+	undone := newForkUndo(TEST_GUILD_ID, "user-1", nil)
+	undone.id = "intro-2"
+	undone.readOut = messageReference(TEST_GUILD_ID, "channel-1", "read-out-2")
+	bot.registerForkUndo(undone)
+	if bot.takeForkUndo("intro-2") == nil {
+		t.Fatalf("Expected the fork to be undoable")
+	}
+	controller.now = now.Add(time.Minute * DEFAULT_FORK_UNDO_MINUTES)
+	bot.expireForkUndo(undone)
+	if len(controller.editedMessageComponents) != 0 {
+		t.Errorf("Expected an undone fork's read out, which is deleted, not to be edited")
+	}
+}
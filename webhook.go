@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//The name of the webhook the bot creates in each thread to post webhook forks
+const FORK_WEBHOOK_NAME = "flux-bot forks"
+
+//Webhook forks end with a link to the original with this text. Like
+//FORKED_MESSAGE_LINK_TEXT, it's how we recognize them as forks.
+const WEBHOOK_FORK_LINK_TEXT = "jump to original"
+
+//These limits are configured by discord.
+const MAX_MESSAGE_CONTENT_LENGTH = 2000
+const MAX_WEBHOOK_USERNAME_LENGTH = 80
+
+//How much of a webhook fork's content the reactions and file links may use
+const MAX_WEBHOOK_FORK_REACTIONS_LENGTH = 200
+const MAX_WEBHOOK_FORK_FILES_LENGTH = 600
+
+//Matches the link at the end of a webhook fork, capturing the guild, channel
+//and message IDs of the original. The <> stop Discord from showing a preview.
+var webhookForkLinkRegExp = regexp.MustCompile(`\[` + regexp.QuoteMeta(WEBHOOK_FORK_LINK_TEXT) + `\]\(<https://discord\.com/channels/(\d+)/(\d+)/(\d+)>\)$`)
+
+//webhookForkSourceRef returns the message that message is a webhook fork of,
+//or nil if it isn't one.
+func webhookForkSourceRef(message *discordgo.Message) *discordgo.MessageReference {
+	if message.WebhookID == "" {
+		return nil
+	}
+	match := webhookForkLinkRegExp.FindStringSubmatch(message.Content)
+	if match == nil {
+		return nil
+	}
+	return messageReference(match[1], match[2], match[3])
+}
+
+//webhookForkContent is the content of a webhook fork of msg: its text,
+//followed by its reactions, links to any files that aren't images and a link
+//back to msg, all within Discord's limit.
+func webhookForkContent(msg *discordgo.Message) string {
+	var extras []string
+	if reactions := forkReactionDescriptions(msg); len(reactions) > 0 {
+		extras = append(extras, truncateString(strings.Join(reactions, "\t"), MAX_WEBHOOK_FORK_REACTIONS_LENGTH))
+	}
+	var files []*discordgo.MessageAttachment
+	for _, attachment := range msg.Attachments {
+		if !isImageAttachment(attachment) {
+			files = append(files, attachment)
+		}
+	}
+	if len(files) > 0 {
+		extras = append(extras, truncateString(attachmentLinks(files), MAX_WEBHOOK_FORK_FILES_LENGTH))
+	}
+	//Note: if you change this, also change webhookForkLinkRegExp to be able to
+	//detect it!
+	extras = append(extras, "["+WEBHOOK_FORK_LINK_TEXT+"](<"+urlForMessage(msg)+">)")
+	suffix := strings.Join(extras, "\n")
+	prefix := ""
+	if context := replyContext(msg, func(url string) string { return "<" + url + ">" }); context != "" {
+		prefix = "> In reply to " + context + "\n"
+	}
+	if msg.Content == "" {
+		return prefix + suffix
+	}
+	return prefix + truncateString(msg.Content, MAX_MESSAGE_CONTENT_LENGTH-len([]rune(prefix))-len([]rune(suffix))-1) + "\n" + suffix
+}
+
+//webhookForkEmbeds returns msg's images and embeds, as many as fit in one
+//message. Unlike createForkMessageEmbeds there is no main embed, since the
+//text is posted as the webhook message's content.
+func webhookForkEmbeds(msg *discordgo.Message) []*discordgo.MessageEmbed {
+	var embeds []*discordgo.MessageEmbed
+	for _, attachment := range msg.Attachments {
+		if !isImageAttachment(attachment) {
+			continue
+		}
+		//Embeds with the same URL are shown by Discord as one gallery
+		embeds = append(embeds, &discordgo.MessageEmbed{
+			URL: urlForMessage(msg),
+			Image: &discordgo.MessageEmbedImage{
+				URL: attachment.URL,
+			},
+		})
+	}
+	for _, embed := range msg.Embeds {
+		embeds = append(embeds, clampEmbed(embed))
+	}
+	if len(embeds) == 0 {
+		return nil
+	}
+	embeds, _ = limitEmbeds(embeds, MAX_EMBED_TOTAL_LENGTH)
+	return embeds
+}
+
+//forkWebhook returns the webhook the bot uses to post forks in the channel,
+//creating it if it doesn't exist yet.
+func (b *bot) forkWebhook(channelID string) (*discordgo.Webhook, error) {
+	b.forkWebhooksMutex.Lock()
+	defer b.forkWebhooksMutex.Unlock()
+	if webhook, ok := b.forkWebhooks[channelID]; ok {
+		return webhook, nil
+	}
+	webhooks, err := b.controller.ChannelWebhooks(channelID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list webhooks: %w", err)
+	}
+	for _, webhook := range webhooks {
+		//Only webhooks we created come with a token we can use
+		if webhook.Name == FORK_WEBHOOK_NAME && webhook.Token != "" {
+			b.forkWebhooks[channelID] = webhook
+			return webhook, nil
+		}
+	}
+	webhook, err := b.controller.WebhookCreate(channelID, FORK_WEBHOOK_NAME, "")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create webhook: %w", err)
+	}
+	b.forkWebhooks[channelID] = webhook
+	return webhook, nil
+}
+
+//forkWebhookByID returns the webhook with the given ID, which must be one the
+//bot created since editing its messages needs its token.
+func (b *bot) forkWebhookByID(webhookID string) (*discordgo.Webhook, error) {
+	b.forkWebhooksMutex.Lock()
+	defer b.forkWebhooksMutex.Unlock()
+	for _, webhook := range b.forkWebhooks {
+		if webhook.ID == webhookID {
+			return webhook, nil
+		}
+	}
+	webhook, err := b.controller.Webhook(webhookID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch webhook: %w", err)
+	}
+	if webhook.Token == "" {
+		return nil, fmt.Errorf("webhook %v wasn't created by the bot", webhookID)
+	}
+	b.forkWebhooks[webhook.ChannelID] = webhook
+	return webhook, nil
+}
+
+//sendWebhookFork posts a fork of msg to the target channel via the channel's
+//fork webhook, with msg's author's name and avatar.
+func (b *bot) sendWebhookFork(targetChannelID string, msg *discordgo.Message) (*discordgo.Message, error) {
+	webhook, err := b.forkWebhook(targetChannelID)
+	if err != nil {
+		return nil, err
+	}
+	params := &discordgo.WebhookParams{
+		Content: webhookForkContent(msg),
+		Embeds:  webhookForkEmbeds(msg),
+		//The original already pinged everyone it mentioned
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	if msg.Author != nil {
+		params.Username = truncateString(msg.Author.Username, MAX_WEBHOOK_USERNAME_LENGTH)
+		params.AvatarURL = msg.Author.AvatarURL("")
+	}
+	posted, err := b.controller.WebhookExecute(webhook.ID, webhook.Token, true, params)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute webhook: %w", err)
+	}
+	return posted, nil
+}
+
+//editWebhookFork updates the webhook fork to match sourceMessage.
+func (b *bot) editWebhookFork(webhookID string, fork *discordgo.MessageReference, sourceMessage *discordgo.Message) error {
+	webhook, err := b.forkWebhookByID(webhookID)
+	if err != nil {
+		return err
+	}
+	embeds := webhookForkEmbeds(sourceMessage)
+	if embeds == nil {
+		//Remove any the fork used to have
+		embeds = []*discordgo.MessageEmbed{}
+	}
+	data := &discordgo.WebhookEdit{
+		Content:         webhookForkContent(sourceMessage),
+		Embeds:          embeds,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	if _, err := b.controller.WebhookMessageEdit(webhook.ID, webhook.Token, fork.MessageID, data); err != nil {
+		return err
+	}
+	return nil
+}
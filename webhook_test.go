@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestWebhookForkContent(t *testing.T) {
+	tests := []struct {
+		Description     string
+		Message         *discordgo.Message
+		ExpectedContent string
+	}{
+		{
+			"Text only",
+			&discordgo.Message{
+				Content: "hello",
+			},
+			"hello\n[" + WEBHOOK_FORK_LINK_TEXT + "](<https://discord.com/channels/100000000000000001/200000000000000001/300000000000000001>)",
+		},
+		{
+			"No text",
+			&discordgo.Message{},
+			"[" + WEBHOOK_FORK_LINK_TEXT + "](<https://discord.com/channels/100000000000000001/200000000000000001/300000000000000001>)",
+		},
+		{
+			"Reactions and files",
+			&discordgo.Message{
+				Content: "look",
+				Reactions: []*discordgo.MessageReactions{
+					{
+						Count: 2,
+						Emoji: &discordgo.Emoji{
+							Name: "👍",
+						},
+					},
+					{
+						Count: 1,
+						Emoji: &discordgo.Emoji{
+							Name: FORK_THREAD_EMOJI,
+						},
+					},
+				},
+				Attachments: []*discordgo.MessageAttachment{
+					{
+						Filename:    "notes.pdf",
+						URL:         "https://cdn.example.com/notes.pdf",
+						ContentType: "application/pdf",
+					},
+					{
+						Filename:    "first.png",
+						URL:         "https://cdn.example.com/first.png",
+						ContentType: "image/png",
+					},
+				},
+			},
+			"look\n👍 : 2\n[notes.pdf](https://cdn.example.com/notes.pdf)\n[" + WEBHOOK_FORK_LINK_TEXT + "](<https://discord.com/channels/100000000000000001/200000000000000001/300000000000000001>)",
+		},
+	}
+	for i, test := range tests {
+		test.Message.ID = "300000000000000001"
+		test.Message.ChannelID = "200000000000000001"
+		test.Message.GuildID = TEST_GUILD_ID
+		content := webhookForkContent(test.Message)
+		if content != test.ExpectedContent {
+			t.Errorf("Test %v (%v) failed. Got %v, expected %v", i, test.Description, content, test.ExpectedContent)
+		}
+	}
+
+	long := &discordgo.Message{
+		ID:        "300000000000000001",
+		ChannelID: "200000000000000001",
+		GuildID:   TEST_GUILD_ID,
+		Content:   strings.Repeat("a", MAX_MESSAGE_CONTENT_LENGTH),
+	}
+	content := webhookForkContent(long)
+	if length := len([]rune(content)); length != MAX_MESSAGE_CONTENT_LENGTH {
+		t.Errorf("Long content should have been truncated to %v characters, got %v", MAX_MESSAGE_CONTENT_LENGTH, length)
+	}
+	fork := &discordgo.Message{
+		WebhookID: "webhook-1",
+		Content:   content,
+	}
+	if ref := messageIsForkOf(fork); ref == nil || ref.ChannelID != "200000000000000001" || ref.MessageID != "300000000000000001" {
+		t.Errorf("Truncated webhook fork should still be detected, got %v", ref)
+	}
+	fork.WebhookID = ""
+	if ref := messageIsForkOf(fork); ref != nil {
+		t.Errorf("Only messages posted by webhooks should be detected as webhook forks, got %v", ref)
+	}
+}
+
+func TestWebhookForkSync(t *testing.T) {
+	session, _ := discordgo.New(TEST_TOKEN)
+	controller := &TestController{}
+	bot := newBot(session, controller)
+	idf := newIDFIndex(TEST_GUILD_ID)
+	bot.indexes[TEST_GUILD_ID] = idf
+
+	source := &discordgo.Message{
+		ID:        "300000000000000001",
+		ChannelID: "200000000000000001",
+		GuildID:   TEST_GUILD_ID,
+		Content:   "hello",
+		Author: &discordgo.User{
+			ID:       "user-1",
+			Username: "alice",
+		},
+	}
+	for i := 0; i < 2; i++ {
+		posted, err := bot.sendWebhookFork("400000000000000001", source)
+		if err != nil {
+			t.Fatalf("sendWebhookFork returned an error: %v", err)
+		}
+		//Discord would tell us about this via messageCreate
+		posted.ChannelID = "400000000000000001"
+		posted.GuildID = TEST_GUILD_ID
+		if err := bot.noteMessageIfFork(posted); err != nil {
+			t.Fatalf("noteMessageIfFork returned an error: %v", err)
+		}
+	}
+	if controller.webhookCreateCallCount != 1 {
+		t.Errorf("Expected the thread's webhook to be created once and then reused, got %v creations", controller.webhookCreateCallCount)
+	}
+	params := controller.executedWebhookParams[0]
+	if params.Username != "alice" {
+		t.Errorf("Expected the fork to be posted as alice, got %v", params.Username)
+	}
+	if params.AllowedMentions == nil || len(params.AllowedMentions.Parse) != 0 {
+		t.Errorf("Webhook forks shouldn't ping anyone again")
+	}
+	forks := idf.MessageForks("200000000000000001", "300000000000000001")
+	if len(forks) != 2 {
+		t.Fatalf("Expected both webhook forks to be indexed, got %v", forks)
+	}
+
+	//Forget the cached webhook, as if the bot restarted
+	bot.forkWebhooks = make(map[string]*discordgo.Webhook)
+	source.Content = "hello again"
+	if err := bot.updateForkedMessages(source); err != nil {
+		t.Fatalf("updateForkedMessages returned an error: %v", err)
+	}
+	if strings.Join(controller.editedWebhookMessageIDs, ",") != "webhook-message-1,webhook-message-2" {
+		t.Errorf("Expected both webhook forks to be edited, got %v", controller.editedWebhookMessageIDs)
+	}
+	if !strings.HasPrefix(controller.lastWebhookEdit.Content, "hello again\n") {
+		t.Errorf("Webhook fork wasn't edited to match, got %v", controller.lastWebhookEdit.Content)
+	}
+
+	idf.NoteChannelDeleted("400000000000000001")
+	if idf.ForkWebhookID(forks[0]) != "" {
+		t.Errorf("Deleted webhook forks should be forgotten")
+	}
+}